@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"imagetools/filter"
+	"strings"
+)
+
+var (
+	ErrInvalidAlphaMode    = errors.New("unsupported alpha mode")
+	ErrInvalidAlphaValue   = errors.New("alpha opacity must be within 0 to 1, tolerance and feather within 0 to 100")
+	ErrInvalidCheckerboard = errors.New("checkerboard cell size must not be negative")
+)
+
+var white = color.NRGBA{255, 255, 255, 255} // Default background and matte color.
+
+// Parse color, or get fallback color if empty.
+func parseColorOr(s string, fallback color.NRGBA) (color.NRGBA, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return filter.ParseColor(s)
+}
+
+// Check the integrity of flatten configuration.
+func (fc *FlattenConfig) check() error {
+
+	if fc.Checkerboard < 0 {
+		return ErrInvalidCheckerboard
+	}
+	for _, c := range []string{fc.Background, fc.CheckerColor} {
+		_, err := filter.ParseColor(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create flatten filter, block may come without configuration.
+func (fc *FlattenConfig) filter() filter.Filter {
+
+	if fc == nil {
+		return filter.Flatten(white, 0, color.NRGBA{})
+	}
+
+	// Checked while loading config.
+	background, _ := parseColorOr(fc.Background, white)
+	checker_color, _ := parseColorOr(fc.CheckerColor, color.NRGBA{204, 204, 204, 255})
+	return filter.Flatten(background, fc.Checkerboard, checker_color)
+}
+
+// Check the integrity of alpha configuration.
+func (ac AlphaConfig) check() error {
+
+	if !filter.IsAlphaMode(ac.Mode) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidAlphaMode, ac.Mode)
+	}
+	if (ac.Opacity != nil && (*ac.Opacity < 0 || *ac.Opacity > 1)) ||
+		ac.Tolerance < 0 || ac.Tolerance > 100 || ac.Feather < 0 || ac.Feather > 100 {
+		return ErrInvalidAlphaValue
+	}
+
+	_, err := filter.ParseColor(ac.Color)
+	return err
+}
+
+// Create alpha filter.
+func (ac AlphaConfig) filter() filter.Filter {
+
+	opacity := 1.0
+	if ac.Opacity != nil {
+		opacity = *ac.Opacity
+	}
+	key, _ := parseColorOr(ac.Color, white) // Checked while loading config.
+
+	return filter.Alpha(ac.Mode, opacity, key, ac.Tolerance, ac.Feather)
+}
+
+// Check the integrity of encode configuration.
+func (ec EncodeConfig) check() error {
+	if !isEncodeFormat(ec.Format) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidEncodeFormat, ec.Format)
+	}
+	if ec.Options != nil && (ec.Options.Quality < 0 || ec.Options.Quality > 100) {
+		return ErrInvalidQuality // Zero means not set, the encoder default is used.
+	}
+	_, err := filter.ParseColor(ec.Background)
+	return err
+}
+
+// Check if the output format has no alpha channel, so the image needs flattening before encoding.
+func (ec EncodeConfig) needsFlatten() bool {
+	switch strings.ToLower(ec.Format) {
+	case "jpeg", "jpg":
+		return true
+	}
+	return false
+}
+
+// Create filter flattening image onto encode background.
+func (ec EncodeConfig) flatten() filter.Filter {
+	background, _ := parseColorOr(ec.Background, white) // Checked while loading config.
+	return filter.Flatten(background, 0, color.NRGBA{})
+}
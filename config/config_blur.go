@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"imagetools/filter"
+)
+
+var (
+	ErrInvalidSharpenValue = errors.New("sharpen radius and amount must not be negative, and threshold must be within 0 to 255")
+	ErrInvalidBlurType     = errors.New("unsupported blur type")
+	ErrInvalidBlurRadius   = errors.New("blur radius must be positive")
+	ErrInvalidKernel       = errors.New("convolution kernel must have odd width and height, with rows of equal length")
+	ErrInvalidEdgeMode     = errors.New("unsupported edge mode")
+)
+
+// Check the integrity of sharpen configuration.
+func (sc *SharpenConfig) check() error {
+	if sc.Radius < 0 || (sc.Amount != nil && *sc.Amount < 0) || sc.Threshold < 0 || sc.Threshold > 255 {
+		return ErrInvalidSharpenValue
+	}
+	return nil
+}
+
+// Create unsharp mask filter, block may come without configuration.
+func (sc *SharpenConfig) filter() filter.Filter {
+
+	radius, amount, threshold := 1.0, 0.5, 0
+	if sc != nil {
+		if sc.Radius > 0 {
+			radius = sc.Radius
+		}
+		if sc.Amount != nil {
+			amount = *sc.Amount
+		}
+		threshold = sc.Threshold
+	}
+
+	return filter.Sharpen(radius, amount, threshold)
+}
+
+// Check the integrity of blur configuration.
+func (bc BlurConfig) check() error {
+	if !filter.IsBlurType(bc.Type) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidBlurType, bc.Type)
+	}
+	if bc.Radius <= 0 {
+		return ErrInvalidBlurRadius
+	}
+	return nil
+}
+
+// Check the integrity of convolve configuration.
+func (cc ConvolveConfig) check() error {
+
+	if len(cc.Kernel)%2 == 0 || len(cc.Kernel[0])%2 == 0 {
+		return ErrInvalidKernel
+	}
+	for _, row := range cc.Kernel {
+		if len(row) != len(cc.Kernel[0]) {
+			return ErrInvalidKernel
+		}
+	}
+
+	if !filter.IsEdgeMode(cc.Edge) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidEdgeMode, cc.Edge)
+	}
+	return nil
+}
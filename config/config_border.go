@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"image"
+	"imagetools/filter"
+)
+
+var (
+	ErrInvalidBorderWidth  = errors.New("border requires width of at least one side")
+	ErrInvalidCornerRadius = errors.New("corner radius must be positive")
+)
+
+// Default colors of border block.
+const (
+	defaultBorderColor  = "black"
+	defaultPaddingColor = "white"
+)
+
+// Check the integrity of border configuration.
+func (bc BorderConfig) check() error {
+
+	for _, length := range []Length{bc.Width, bc.Top, bc.Right, bc.Bottom, bc.Left, bc.Padding} {
+		err := length.check(false)
+		if err != nil {
+			return err
+		}
+	}
+	if bc.Width == "" && bc.Top == "" && bc.Right == "" && bc.Bottom == "" && bc.Left == "" {
+		return ErrInvalidBorderWidth
+	}
+
+	for _, c := range []string{bc.Color, bc.PaddingColor} {
+		_, err := filter.ParseColor(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create border operation which resolves widths by current image.
+func borderOperation(bc BorderConfig) Operation {
+
+	// Colors are checked while loading config.
+	border, _ := filter.ParseColor(valueOrDefault(bc.Color, defaultBorderColor))
+	padding_color, _ := filter.ParseColor(valueOrDefault(bc.PaddingColor, defaultPaddingColor))
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+
+		top, right, bottom, left, padding := bc.widths(img.Bounds().Dx(), img.Bounds().Dy())
+		return filter.Border(top, right, bottom, left, padding, border, padding_color)(img)
+	})
+}
+
+// Resolve border widths of each side and padding width for image of given size.
+//
+// Percentages are relative to the shorter side, so that all sides match.
+func (bc BorderConfig) widths(width int, height int) (int, int, int, int, int) {
+	reference := min(width, height)
+	side := func(length Length) int {
+		return Length(valueOrDefault(string(length), string(bc.Width))).pixels(reference)
+	}
+	return side(bc.Top), side(bc.Right), side(bc.Bottom), side(bc.Left), bc.Padding.pixels(reference)
+}
+
+// Compute image size with border.
+func (bc BorderConfig) targetSize(width int, height int) (int, int) {
+	top, right, bottom, left, padding := bc.widths(width, height)
+	return width + left + right + padding*2, height + top + bottom + padding*2
+}
+
+// Check the integrity of round corners configuration.
+func (rc RoundCornersConfig) check() error {
+
+	err := rc.Radius.check(false)
+	if err != nil {
+		return err
+	}
+	if value, _, _ := rc.Radius.parse(); value <= 0 {
+		return ErrInvalidCornerRadius
+	}
+
+	_, err = filter.ParseColor(rc.Background)
+	return err
+}
+
+// Create round corners operation which resolves radius by current image.
+func roundCornersOperation(rc RoundCornersConfig) Operation {
+
+	background, _ := filter.ParseColor(rc.Background) // Checked while loading config.
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+		radius := rc.Radius.pixels(min(img.Bounds().Dx(), img.Bounds().Dy()))
+		return filter.RoundCorners(radius, background)(img)
+	})
+}
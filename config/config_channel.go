@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"imagetools/filter"
+	"strings"
+)
+
+var (
+	ErrInvalidGrayWeights    = errors.New("unsupported grayscale weights")
+	ErrInvalidSepiaValue     = errors.New("sepia strength must be within 0 to 1")
+	ErrInvalidDuotoneColors  = errors.New("duotone requires at least two colors")
+	ErrInvalidChannelSource  = errors.New("unsupported channel source")
+	ErrInvalidChannelExtract = errors.New("channel extraction can not be combined with channel sources")
+)
+
+// Check the integrity of grayscale configuration.
+func (gc *GrayscaleConfig) check() error {
+	if !filter.IsGrayWeights(gc.Weights) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidGrayWeights, gc.Weights)
+	}
+	return nil
+}
+
+// Get grayscale weights, block may come without configuration.
+func (gc *GrayscaleConfig) weights() string {
+	if gc == nil {
+		return ""
+	}
+	return gc.Weights
+}
+
+// Check the integrity of sepia configuration.
+func (sc *SepiaConfig) check() error {
+	if sc.Strength != nil && (*sc.Strength < 0 || *sc.Strength > 1) {
+		return ErrInvalidSepiaValue
+	}
+	return nil
+}
+
+// Get sepia strength, full effect if omitted.
+func (sc *SepiaConfig) strength() float64 {
+	if sc == nil || sc.Strength == nil {
+		return 1
+	}
+	return *sc.Strength
+}
+
+// Check the integrity of duotone configuration.
+func (dc DuotoneConfig) check() error {
+
+	if len(dc.Colors) < 2 {
+		return ErrInvalidDuotoneColors
+	}
+	for _, c := range dc.Colors {
+		_, err := filter.ParseColor(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !filter.IsGrayWeights(dc.Weights) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidGrayWeights, dc.Weights)
+	}
+	return nil
+}
+
+// Create gradient map filter.
+func (dc DuotoneConfig) filter() filter.Filter {
+	colors := make([]color.NRGBA, len(dc.Colors))
+	for i, c := range dc.Colors {
+		colors[i], _ = filter.ParseColor(c) // Checked while loading config.
+	}
+	return filter.GradientMap(colors, dc.Weights)
+}
+
+// Check the integrity of channels configuration.
+func (cc ChannelsConfig) check() error {
+
+	for _, source := range []string{cc.Red, cc.Green, cc.Blue, cc.Alpha} {
+		if !filter.IsChannelSource(source) {
+			return fmt.Errorf("%w: '%s'", ErrInvalidChannelSource, source)
+		}
+	}
+
+	switch strings.ToLower(cc.Extract) {
+	case "":
+	case filter.ChannelSourceRed, filter.ChannelSourceGreen, filter.ChannelSourceBlue, filter.ChannelSourceAlpha:
+		if cc.Red != "" || cc.Green != "" || cc.Blue != "" || cc.Alpha != "" {
+			return ErrInvalidChannelExtract
+		}
+	default:
+		return fmt.Errorf("%w: '%s'", ErrInvalidChannelSource, cc.Extract)
+	}
+
+	return nil
+}
+
+// Get source of red, green, blue and alpha channel.
+func (cc ChannelsConfig) sources() [4]string {
+
+	if cc.Extract == "" {
+		return [4]string{cc.Red, cc.Green, cc.Blue, cc.Alpha}
+	}
+
+	// Extracted channel becomes gray image, extracted alpha is shown as opaque mask.
+	alpha := ""
+	if strings.EqualFold(cc.Extract, filter.ChannelSourceAlpha) {
+		alpha = filter.ChannelSourceWhite
+	}
+	return [4]string{cc.Extract, cc.Extract, cc.Extract, alpha}
+}
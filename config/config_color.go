@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"imagetools/filter"
+)
+
+// Channels of levels block.
+const (
+	ChannelRGB   = "rgb"
+	ChannelRed   = "red"
+	ChannelGreen = "green"
+	ChannelBlue  = "blue"
+)
+
+var (
+	ErrInvalidAdjustValue = errors.New("adjust values out of range")
+	ErrInvalidLevelsValue = errors.New("levels values must be within 0 to 255, with black below white")
+	ErrInvalidChannel     = errors.New("unsupported channel")
+)
+
+// Check the integrity of adjust configuration.
+func (ac AdjustConfig) check() error {
+	if ac.Gamma < 0 || ac.Brightness < -1 || ac.Brightness > 1 || ac.Contrast < -1 || ac.Saturation < -1 {
+		return fmt.Errorf("%w: gamma must not be negative, brightness must be within -1 to 1, contrast and saturation must not be below -1", ErrInvalidAdjustValue)
+	}
+	return nil
+}
+
+// Convert to filter adjustment.
+func (ac AdjustConfig) adjustment() filter.Adjustment {
+	return filter.Adjustment{
+		Exposure:   ac.Exposure,
+		Gamma:      ac.Gamma,
+		Brightness: ac.Brightness,
+		Contrast:   ac.Contrast,
+		Saturation: ac.Saturation,
+		Hue:        ac.Hue,
+	}
+}
+
+// Check the integrity of levels configuration.
+func (lc LevelsConfig) check() error {
+
+	in_white, out_white := lc.whitePoints()
+	for _, v := range []int{lc.InputBlack, in_white, lc.OutputBlack, out_white} {
+		if v < 0 || v > 255 {
+			return ErrInvalidLevelsValue
+		}
+	}
+	if lc.InputBlack >= in_white || lc.Gamma < 0 {
+		return ErrInvalidLevelsValue
+	}
+
+	switch lc.Channel {
+	case "", ChannelRGB, ChannelRed, ChannelGreen, ChannelBlue:
+	default:
+		return fmt.Errorf("%w: '%s'", ErrInvalidChannel, lc.Channel)
+	}
+
+	return nil
+}
+
+// Get input and output white points, 255 if omitted.
+func (lc LevelsConfig) whitePoints() (int, int) {
+	in_white, out_white := lc.InputWhite, lc.OutputWhite
+	if in_white == 0 {
+		in_white = 255
+	}
+	if out_white == 0 {
+		out_white = 255
+	}
+	return in_white, out_white
+}
+
+// Create levels filter.
+func (lc LevelsConfig) filter() filter.Filter {
+
+	in_white, out_white := lc.whitePoints()
+	curve := filter.LevelsCurve(uint8(lc.InputBlack), uint8(in_white), lc.Gamma, uint8(lc.OutputBlack), uint8(out_white))
+	identity := filter.IdentityCurve()
+
+	switch lc.Channel {
+	case ChannelRed:
+		return filter.ApplyToneCurves(curve, identity, identity)
+	case ChannelGreen:
+		return filter.ApplyToneCurves(identity, curve, identity)
+	case ChannelBlue:
+		return filter.ApplyToneCurves(identity, identity, curve)
+	default:
+		return filter.ApplyToneCurves(curve, curve, curve)
+	}
+}
+
+// Compute tone curves of red, green and blue channels, master curve included.
+func (cc CurvesConfig) curves() ([3]filter.ToneCurve, error) {
+
+	// Omitted curve is identity.
+	build := func(points [][2]float64) (filter.ToneCurve, error) {
+		if points == nil {
+			return filter.IdentityCurve(), nil
+		}
+		return filter.PointCurve(points)
+	}
+
+	master, err := build(cc.RGB)
+	if err != nil {
+		return [3]filter.ToneCurve{}, err
+	}
+
+	curves := [3]filter.ToneCurve{}
+	for i, points := range [][][2]float64{cc.Red, cc.Green, cc.Blue} {
+		channel, err := build(points)
+		if err != nil {
+			return [3]filter.ToneCurve{}, err
+		}
+		curves[i] = master.Then(channel)
+	}
+
+	return curves, nil
+}
@@ -0,0 +1,416 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"imagetools/metadata"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the tiny expression language used by `when` clauses.
+//
+// Supported syntax:
+// - Identifiers: `width`, `height`, `aspect`, `has_alpha`, `format`, `true`, `false`.
+// - Literals: numbers (`4000`, `1.5`) and quoted strings (`'png'`, `"jpeg"`).
+// - Comparison: `>`, `>=`, `<`, `<=`, `==`, `!=`.
+// - Logic: `&&` (`and`), `||` (`or`), `!` (`not`), and parentheses.
+//
+// Example: `width > 4000 && format == 'png'`
+
+var ErrInvalidCondition = errors.New("malformed when condition")
+
+// Image properties which `when` clauses are evaluated against.
+//
+// Width: Current image width.
+//
+// Height: Current image height.
+//
+// HasAlpha: Whether current image has non-opaque pixels.
+//
+// Format: Format of input file, e.g. `jpeg`, `png`.
+type ImageState struct {
+	Width    int    // Current image width.
+	Height   int    // Current image height.
+	HasAlpha bool   // Current image has transparency.
+	Format   string // Input file format.
+}
+
+// Aspect ratio (width / height) of current image.
+func (state ImageState) Aspect() float64 {
+	if state.Height == 0 {
+		return 0
+	}
+	return float64(state.Width) / float64(state.Height)
+}
+
+// Check if the block should be applied to image with given state.
+//
+// Blocks without `when` clause are always applied.
+func (pb PipelineBlock) ShouldApply(state ImageState) (bool, error) {
+	if strings.TrimSpace(pb.When) == "" {
+		return true, nil
+	}
+	return EvaluateCondition(pb.When, state)
+}
+
+// Evaluate `when` condition against image state.
+//
+// expr: Condition expression.
+// state: Current image state.
+func EvaluateCondition(expr string, state ImageState) (bool, error) {
+
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return false, err
+	}
+
+	parser := conditionParser{tokens: tokens, state: state}
+	result, err := parser.parseOr()
+	if err != nil {
+		return false, err
+	}
+
+	// All tokens should be consumed.
+	if parser.pos != len(parser.tokens) {
+		return false, fmt.Errorf("%w: unexpected '%s'", ErrInvalidCondition, parser.tokens[parser.pos].text)
+	}
+
+	boolean, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: '%s' is not a boolean expression", ErrInvalidCondition, expr)
+	}
+
+	return boolean, nil
+}
+
+// Check the syntax of `when` condition without actual image.
+func checkCondition(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return nil
+	}
+	_, err := EvaluateCondition(expr, ImageState{})
+	return err
+}
+
+// Token kinds of condition expression.
+const (
+	tokenNumber     = iota // Numeric literal.
+	tokenString            // Quoted string literal.
+	tokenIdentifier        // Identifier.
+	tokenOperator          // Operator or parenthesis.
+)
+
+// Token of condition expression.
+type conditionToken struct {
+	kind int    // Token kind.
+	text string // Raw token text.
+}
+
+// Split condition expression into tokens.
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+
+	tokens := []conditionToken{}
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r): // Skip whitespaces.
+			i++
+
+		case unicode.IsDigit(r) || r == '.': // Numeric literal.
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, conditionToken{tokenNumber, string(runes[start:i])})
+
+		case r == '\'' || r == '"': // String literal.
+			start := i + 1
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidCondition)
+			}
+			tokens = append(tokens, conditionToken{tokenString, string(runes[start:i])})
+			i++ // Skip closing quote.
+
+		case unicode.IsLetter(r) || r == '_': // Identifier or word operator.
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			word := strings.ToLower(string(runes[start:i]))
+			switch word {
+			case "and":
+				tokens = append(tokens, conditionToken{tokenOperator, "&&"})
+			case "or":
+				tokens = append(tokens, conditionToken{tokenOperator, "||"})
+			case "not":
+				tokens = append(tokens, conditionToken{tokenOperator, "!"})
+			default:
+				tokens = append(tokens, conditionToken{tokenIdentifier, word})
+			}
+
+		default: // Operators.
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case ">=", "<=", "==", "!=", "&&", "||":
+				tokens = append(tokens, conditionToken{tokenOperator, two})
+				i += 2
+				continue
+			}
+			switch r {
+			case '>', '<', '!', '(', ')':
+				tokens = append(tokens, conditionToken{tokenOperator, string(r)})
+				i++
+			default:
+				return nil, fmt.Errorf("%w: unexpected character '%c'", ErrInvalidCondition, r)
+			}
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidCondition)
+	}
+
+	return tokens, nil
+}
+
+// Recursive descent parser which evaluates the expression while parsing.
+//
+// Values are represented as `float64`, `string` or `bool`.
+type conditionParser struct {
+	tokens []conditionToken // Tokenized expression.
+	pos    int              // Current token index.
+	state  ImageState       // Image state to evaluate against.
+}
+
+// Peek current operator, returns empty string if current token is not an operator.
+func (p *conditionParser) peekOperator() string {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// or := and ( "||" and )*
+func (p *conditionParser) parseOr() (any, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r, err := bothBoolean(left, right, "||")
+		if err != nil {
+			return nil, err
+		}
+		left = l || r
+	}
+	return left, nil
+}
+
+// and := unary ( "&&" unary )*
+func (p *conditionParser) parseAnd() (any, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l, r, err := bothBoolean(left, right, "&&")
+		if err != nil {
+			return nil, err
+		}
+		left = l && r
+	}
+	return left, nil
+}
+
+// unary := "!" unary | comparison
+func (p *conditionParser) parseUnary() (any, error) {
+	if p.peekOperator() == "!" {
+		p.pos++
+		value, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		boolean, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: '!' requires a boolean operand", ErrInvalidCondition)
+		}
+		return !boolean, nil
+	}
+	return p.parseComparison()
+}
+
+// comparison := primary ( op primary )?
+func (p *conditionParser) parseComparison() (any, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := p.peekOperator()
+	switch operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+		p.pos++
+	default:
+		return left, nil // Not a comparison.
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	return compareValues(left, right, operator)
+}
+
+// primary := "(" or ")" | number | string | identifier
+func (p *conditionParser) parsePrimary() (any, error) {
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidCondition)
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.kind {
+	case tokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number '%s'", ErrInvalidCondition, token.text)
+		}
+		return number, nil
+
+	case tokenString:
+		return token.text, nil
+
+	case tokenIdentifier:
+		return p.lookupIdentifier(token.text)
+
+	default: // Operator.
+		if token.text != "(" {
+			return nil, fmt.Errorf("%w: unexpected '%s'", ErrInvalidCondition, token.text)
+		}
+		value, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekOperator() != ")" {
+			return nil, fmt.Errorf("%w: missing ')'", ErrInvalidCondition)
+		}
+		p.pos++
+		return value, nil
+	}
+}
+
+// Resolve identifier to its value.
+func (p *conditionParser) lookupIdentifier(name string) (any, error) {
+	switch name {
+	case "width":
+		return float64(p.state.Width), nil
+	case "height":
+		return float64(p.state.Height), nil
+	case "aspect":
+		return p.state.Aspect(), nil
+	case "has_alpha":
+		return p.state.HasAlpha, nil
+	case "format":
+		return p.state.Format, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown identifier '%s'", ErrInvalidCondition, name)
+	}
+}
+
+// Assert both operands are boolean.
+func bothBoolean(left any, right any, operator string) (bool, bool, error) {
+	l, ok_left := left.(bool)
+	r, ok_right := right.(bool)
+	if !ok_left || !ok_right {
+		return false, false, fmt.Errorf("%w: '%s' requires boolean operands", ErrInvalidCondition, operator)
+	}
+	return l, r, nil
+}
+
+// Compare two values with given operator.
+func compareValues(left any, right any, operator string) (bool, error) {
+
+	switch l := left.(type) {
+
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		switch operator {
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		// Strings are only compared against `format`, so normalize them the same way.
+		l, r = metadata.NormalizeFormat(l), metadata.NormalizeFormat(r)
+		switch operator {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		default:
+			return false, fmt.Errorf("%w: '%s' is not supported for strings", ErrInvalidCondition, operator)
+		}
+
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			break
+		}
+		switch operator {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		default:
+			return false, fmt.Errorf("%w: '%s' is not supported for booleans", ErrInvalidCondition, operator)
+		}
+	}
+
+	return false, fmt.Errorf("%w: cannot compare %v with %v", ErrInvalidCondition, left, right)
+}
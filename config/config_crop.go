@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"imagetools/filter"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Content-aware crop alignment, see `CropConfig`.
+const CropAlignmentSmart = "smart"
+
+// Suffix of focus sidecar file, replacing the extension of image file.
+const focusSidecarSuffix = ".focus.yaml"
+
+var (
+	ErrInvalidCropTarget = errors.New("crop block requires width, height, aspect, x, y or trim")
+	ErrEmptyCrop         = errors.New("crop rectangle is empty")
+)
+
+// Get crop width, `WidthLength` if set, otherwise pixel `Width`.
+func (cc CropConfig) width() Length {
+	return lengthOrPixels(cc.WidthLength, cc.Width)
+}
+
+// Get crop height, `HeightLength` if set, otherwise pixel `Height`.
+func (cc CropConfig) height() Length {
+	return lengthOrPixels(cc.HeightLength, cc.Height)
+}
+
+// Read crop configuration, pixel sizes are also set as `Width` and `Height`.
+func (cc *CropConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CropConfig // Without methods, avoids recursion.
+	err := unmarshal((*plain)(cc))
+	if err != nil {
+		return err
+	}
+	if cc.WidthLength.isPixels() {
+		cc.Width = cc.WidthLength.pixels(0)
+	}
+	if cc.HeightLength.isPixels() {
+		cc.Height = cc.HeightLength.pixels(0)
+	}
+	return nil
+}
+
+// Serialize crop configuration, pixel sizes are written as `width` and `height`.
+func (cc CropConfig) MarshalYAML() (interface{}, error) {
+	type plain CropConfig
+	cc.WidthLength, cc.HeightLength = cc.width(), cc.height()
+	return plain(cc), nil
+}
+
+// Check the integrity of crop configuration.
+func (cc CropConfig) check() error {
+
+	if cc.width() == "" && cc.height() == "" && cc.Aspect == "" && cc.X == "" && cc.Y == "" && cc.Trim == nil {
+		return ErrInvalidCropTarget
+	}
+
+	// Sizes, positions and margins must not be negative, offsets may be.
+	lengths := []Length{cc.width(), cc.height(), cc.X, cc.Y}
+	if cc.Trim != nil {
+		lengths = append(lengths, cc.Trim.Top, cc.Trim.Bottom, cc.Trim.Left, cc.Trim.Right)
+	}
+	for _, length := range lengths {
+		err := length.check(false)
+		if err != nil {
+			return err
+		}
+	}
+	for _, length := range []Length{cc.OffsetX, cc.OffsetY} {
+		err := length.check(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cc.Aspect != "" {
+		_, err := parseAspect(cc.Aspect)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, _, ok := filter.AnchorPosition(cc.Alignment); !ok && !cc.isSmart() {
+		return fmt.Errorf("%w: '%s'", ErrInvalidAlignment, cc.Alignment)
+	}
+
+	if cc.Focus != nil {
+		return cc.Focus.check()
+	}
+
+	return nil
+}
+
+// Check if crop uses content-aware alignment.
+func (cc CropConfig) isSmart() bool {
+	return strings.ToLower(cc.Alignment) == CropAlignmentSmart
+}
+
+// Check the integrity of focal point.
+func (cf CropFocus) check() error {
+	for _, length := range []Length{cf.X, cf.Y} {
+		err := length.check(false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get focal point in image coordinates, unset axis is centered.
+func (cf CropFocus) point(width int, height int) image.Point {
+	point := image.Pt(width/2, height/2)
+	if cf.X != "" {
+		point.X = cf.X.pixels(width)
+	}
+	if cf.Y != "" {
+		point.Y = cf.Y.pixels(height)
+	}
+	return point
+}
+
+// Get path of focus sidecar file of input image.
+func FocusSidecarPath(input_file string) string {
+	return strings.TrimSuffix(input_file, filepath.Ext(input_file)) + focusSidecarSuffix
+}
+
+// Read focus sidecar file of input image.
+//
+// Returns nil without error if the image has no sidecar file.
+//
+// input_file: Path to input image.
+func readFocusSidecar(input_file string) (*CropFocus, error) {
+
+	sidecar := FocusSidecarPath(input_file)
+	data, err := os.ReadFile(sidecar)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	focus := &CropFocus{}
+	err = yaml.Unmarshal(data, focus)
+	if err == nil {
+		err = focus.check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed focus sidecar file '%s': %w", sidecar, err)
+	}
+
+	return focus, nil
+}
+
+// Compute crop rectangle for image of given size, placed by alignment.
+//
+// Smart alignment is placed at center, the size is the same wherever the crop ends up.
+//
+// width, height: Image size before cropping.
+func (cc CropConfig) cropRect(width int, height int) image.Rectangle {
+	alignment := cc.Alignment
+	if cc.isSmart() {
+		alignment = filter.AnchorCenter
+	}
+	return cc.placeCrop(width, height, func(region image.Rectangle, size image.Point) image.Point {
+		return region.Min.Add(filter.AnchorOffset(alignment, region.Size(), size))
+	})
+}
+
+// Compute crop rectangle for image of given size.
+//
+// The rectangle always lies inside the image, it is empty if the margins leave nothing.
+//
+// width, height: Image size before cropping.
+// locate: Position crop of `size` inside `region`, before coordinates and offsets are applied.
+func (cc CropConfig) placeCrop(width int, height int, locate func(region image.Rectangle, size image.Point) image.Point) image.Rectangle {
+
+	// Region left after removing margins, percentages are relative to the whole image.
+	region := image.Rect(0, 0, width, height)
+	if cc.Trim != nil {
+		region = image.Rectangle{ // Not `image.Rect`, it would swap overlapping margins.
+			image.Pt(cc.Trim.Left.pixels(width), cc.Trim.Top.pixels(height)),
+			image.Pt(width-cc.Trim.Right.pixels(width), height-cc.Trim.Bottom.pixels(height)),
+		}
+	}
+	if region.Empty() {
+		return image.Rectangle{}
+	}
+
+	// Crop size, percentages are relative to the region.
+	region_w, region_h := region.Dx(), region.Dy()
+	crop_w, crop_h := float64(cc.width().pixels(region_w)), float64(cc.height().pixels(region_h))
+
+	if aspect, err := parseAspect(cc.Aspect); err == nil && cc.Aspect != "" {
+		switch {
+		case crop_w == 0 && crop_h == 0: // Largest crop of the ratio.
+			if float64(region_w)/float64(region_h) > aspect {
+				crop_w, crop_h = float64(region_h)*aspect, float64(region_h)
+			} else {
+				crop_w, crop_h = float64(region_w), float64(region_w)/aspect
+			}
+		case crop_h == 0:
+			crop_h = crop_w / aspect
+		case crop_w == 0:
+			crop_w = crop_h * aspect
+		}
+
+		// Shrink oversized crop while keeping the ratio.
+		if scale := math.Min(float64(region_w)/crop_w, float64(region_h)/crop_h); scale < 1 {
+			crop_w, crop_h = crop_w*scale, crop_h*scale
+		}
+	}
+
+	if crop_w == 0 {
+		crop_w = float64(region_w)
+	}
+	if crop_h == 0 {
+		crop_h = float64(region_h)
+	}
+	size := image.Pt(min(region_w, roundDimension(crop_w)), min(region_h, roundDimension(crop_h)))
+
+	// Position, explicit coordinates override alignment on their axis.
+	position := locate(region, size)
+	if cc.X != "" {
+		position.X = region.Min.X + cc.X.pixels(region_w)
+	}
+	if cc.Y != "" {
+		position.Y = region.Min.Y + cc.Y.pixels(region_h)
+	}
+	position.X += cc.OffsetX.pixels(region_w)
+	position.Y += cc.OffsetY.pixels(region_h)
+
+	// Keep the rectangle inside the region.
+	position.X = min(max(position.X, region.Min.X), region.Max.X-size.X)
+	position.Y = min(max(position.Y, region.Min.Y), region.Max.Y-size.Y)
+
+	return image.Rectangle{position, position.Add(size)}
+}
+
+// Create crop operation which computes crop rectangle from current image.
+//
+// input_file: Path to input image, used to find focus sidecar file.
+func cropOperation(cc CropConfig, input_file string) Operation {
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		rect := cc.cropRect(width, height)
+
+		if cc.isSmart() {
+			// Focal point by priority: config, sidecar file, content analysis.
+			focus := cc.Focus
+			if focus == nil {
+				sidecar, err := readFocusSidecar(input_file)
+				if err != nil {
+					return nil, err
+				}
+				focus = sidecar
+			}
+
+			rect = cc.placeCrop(width, height, func(region image.Rectangle, size image.Point) image.Point {
+				if focus == nil {
+					return filter.SmartCropPosition(img, region.Add(img.Bounds().Min), size).Sub(img.Bounds().Min)
+				}
+				return focus.point(width, height).Sub(size.Div(2)) // Centered on focal point, clamped later.
+			})
+		}
+
+		if rect.Empty() {
+			return nil, fmt.Errorf("%w: margins exceed image size %dx%d", ErrEmptyCrop, width, height)
+		}
+
+		return filter.Crop(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())(img)
+	})
+}
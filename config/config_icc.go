@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"imagetools/filter"
+	"imagetools/icc"
+	"os"
+	"strings"
+)
+
+// Profile assumed for images without embedded profile, if not configured.
+const defaultSourceProfile = "sRGB"
+
+// Profile name keeping the profile embedded in input image.
+const IccNameSource = "source"
+
+var (
+	ErrInvalidIccTarget  = errors.New("icc conversion requires target profile")
+	ErrInvalidIntent     = errors.New("unsupported rendering intent")
+	ErrInvalidIccProfile = errors.New("icc embedding requires either profile name or profile file")
+)
+
+// Check the integrity of ICC embedding configuration, profile file is validated as well.
+func (ic IccEmbedConfig) check() error {
+
+	if (ic.ProfileName == "") == (ic.File == "") {
+		return ErrInvalidIccProfile
+	}
+	if ic.File == "" {
+		if !icc.IsNamed(ic.ProfileName) && !strings.EqualFold(ic.ProfileName, IccNameSource) {
+			return fmt.Errorf("%w: '%s'", ErrUnknownIccName, ic.ProfileName)
+		}
+		return nil
+	}
+
+	data, err := ic.load()
+	if err != nil {
+		return err
+	}
+	if _, err := icc.ReadInfo(data); err != nil {
+		return fmt.Errorf("profile file '%s': %w", ic.File, err)
+	}
+	return nil
+}
+
+// Read profile file, blocks loaded from config file read it once while checking.
+func (ic IccEmbedConfig) load() ([]byte, error) {
+	return ic.data.get("", func() ([]byte, error) { return os.ReadFile(ic.File) })
+}
+
+// Create ICC embedding operation, profile is embedded into encoded image, or by next encoding.
+//
+// job: Metadata of current job, source of profile kept by `source` name.
+func iccEmbedOperation(ic IccEmbedConfig, job *JobMetadata) Operation {
+
+	if ic.File == "" && !strings.EqualFold(ic.ProfileName, IccNameSource) {
+		return embedNamedProfile(ic.ProfileName)
+	}
+
+	return func(pi ProcessingImage) ProcessingImage {
+
+		profile := job.SourceICC
+		if ic.File != "" {
+			var err error
+			profile, err = ic.load()
+			if err != nil {
+				pi.lastErr = err
+				return pi
+			}
+		}
+		if profile == nil {
+			return pi // Input without profile, output stays untagged as well.
+		}
+
+		return pi.Then(embedProfile(profile))
+	}
+}
+
+// Check the integrity of ICC conversion configuration, profile files are parsed as well.
+func (ic IccConvertConfig) check() error {
+
+	if ic.Target == "" {
+		return ErrInvalidIccTarget
+	}
+
+	if !icc.IsIntent(ic.Intent) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidIntent, ic.Intent)
+	}
+
+	target, err := ic.loadProfile(ic.Target)
+	if err != nil {
+		return err
+	}
+	if target.Gray {
+		return fmt.Errorf("%w: gray profile as target", icc.ErrUnsupportedProfile)
+	}
+
+	_, err = ic.loadProfile(valueOrDefault(ic.Source, defaultSourceProfile))
+	return err
+}
+
+// Load configured profile, built-in name or ICC file. Blocks loaded from config file read each file once.
+func (ic IccConvertConfig) loadProfile(name_or_path string) (*icc.Profile, error) {
+	return ic.profiles.get(name_or_path, func() (*icc.Profile, error) { return icc.Load(name_or_path) })
+}
+
+// Get profile of current pixel data, falls back to configured profile if the image has none.
+//
+// job: Metadata of current job, holds embedded profile or target of previous conversion.
+func (ic IccConvertConfig) sourceProfile(job *JobMetadata) (*icc.Profile, error) {
+
+	switch {
+	case job.ICC != nil:
+		profile, err := icc.Parse(job.ICC)
+		if err != nil {
+			return nil, fmt.Errorf("embedded profile: %w", err)
+		}
+		return profile, nil
+	case job.ICCName != "":
+		return ic.loadProfile(job.ICCName)
+	}
+
+	return ic.loadProfile(valueOrDefault(ic.Source, defaultSourceProfile))
+}
+
+// Get key of profile of current pixel data, see `sourceProfile`. Jobs with equal keys share the transform.
+func (ic IccConvertConfig) sourceKey(job *JobMetadata) string {
+	switch {
+	case job.ICC != nil:
+		return "\x00" + string(job.ICC) // Not a valid name or path.
+	case job.ICCName != "":
+		return job.ICCName
+	}
+	return valueOrDefault(ic.Source, defaultSourceProfile)
+}
+
+// Create ICC conversion operation, target profile is loaded when the operation is created.
+//
+// Transforms are shared by all jobs with the same source profile.
+//
+// job: Metadata of current job, source profile is read from it, and replaced by target profile.
+func iccConvertOperation(ic IccConvertConfig, job *JobMetadata) Operation {
+
+	target, err := ic.loadProfile(ic.Target)
+	if err != nil {
+		return failedOperation(err)
+	}
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+
+		transform, err := ic.transforms.get(ic.sourceKey(job), func() (*icc.Transform, error) {
+			source, err := ic.sourceProfile(job)
+			if err != nil {
+				return nil, err
+			}
+			return icc.NewTransform(source, target, ic.Intent, ic.BlackPointCompensation)
+		})
+		if err != nil {
+			return nil, err
+		}
+		converted, err := filter.ConvertColors(transform.Convert)(img)
+		if err != nil {
+			return nil, err
+		}
+
+		job.converted(ic.Target, target)
+		return converted, nil
+	})
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"imagetools/icc"
+	"imagetools/metadata"
+)
+
+// Metadata of one processing job, shared by the blocks of its pipeline.
+//
+// SourceICC: ICC profile embedded in input image, nil if absent. Filled by `decode` block.
+//
+// ICC, ICCName: Color profile of current pixel data, either profile data or built-in name. Starts as the
+// source profile and is changed by `icc_convert` block. Carried into output by `encode` block.
+//
+// Oriented: EXIF orientation was applied to pixel data, later `auto_orient` blocks do nothing. Cleared when
+// input data is decoded again.
+type JobMetadata struct {
+	SourceICC []byte // Embedded profile of input image.
+	ICC       []byte // Profile of current pixel data.
+	ICCName   string // Built-in profile name of current pixel data.
+	Oriented  bool   // EXIF orientation applied.
+}
+
+// Read embedded profile from encoded input image.
+func (job *JobMetadata) readSource(data []byte) error {
+
+	profile, err := metadata.ExtractICCProfile(data)
+	if err != nil {
+		return err
+	}
+
+	job.SourceICC, job.ICC, job.ICCName = profile, profile, ""
+	return nil
+}
+
+// Record conversion of pixel data to another profile.
+//
+// target: Built-in profile name or ICC file path.
+// profile: Loaded target profile.
+func (job *JobMetadata) converted(target string, profile *icc.Profile) {
+	if icc.IsNamed(target) {
+		job.ICC, job.ICCName = nil, target
+	} else {
+		job.ICC, job.ICCName = profile.Data, ""
+	}
+}
+
+// Create operation reading embedded profile of input image into job metadata, before decoding.
+func readJobMetadata(job *JobMetadata) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		if !pi.encoded {
+			job.Oriented = false // Input data is decoded as stored, not yet oriented.
+		}
+		pi.lastErr = job.readSource(pi.data)
+		return pi
+	}
+}
+
+// Create operation embedding profile of current pixel data into encoded image.
+//
+// Profile is looked up when the operation runs, so conversions earlier in the pipeline are respected.
+// Images without profile are left untouched.
+func carryProfile(job *JobMetadata) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		switch {
+		case pi.profile != nil:
+			return pi // Explicit `icc_embed` block wins.
+		case job.ICC != nil:
+			return pi.Then(embedProfile(job.ICC))
+		case job.ICCName != "":
+			return pi.Then(embedNamedProfile(job.ICCName))
+		}
+		return pi
+	}
+}
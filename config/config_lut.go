@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register decoders for Hald images.
+	_ "image/png"
+	"imagetools/filter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidLUTValue         = errors.New("lut strength must be within 0 to 1")
+	ErrInvalidLUTInterpolation = errors.New("unsupported lut interpolation")
+)
+
+// Check the integrity of LUT configuration, the LUT file is parsed as well.
+func (lc LUTConfig) check() error {
+
+	if lc.Strength != nil && (*lc.Strength < 0 || *lc.Strength > 1) {
+		return ErrInvalidLUTValue
+	}
+
+	if !filter.IsLUTInterpolation(lc.Interpolation) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidLUTInterpolation, lc.Interpolation)
+	}
+
+	_, err := lc.load()
+	return err
+}
+
+// Get parsed LUT, blocks loaded from config file parse it once while checking.
+func (lc LUTConfig) load() (*filter.LUT3D, error) {
+	return lc.lut.get("", func() (*filter.LUT3D, error) { return loadLUT(lc.File) })
+}
+
+// Load LUT file, `.cube` or Hald CLUT image by extension.
+func loadLUT(file_path string) (*filter.LUT3D, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(file_path)) == ".cube" {
+		lut, err := filter.ParseCubeLUT(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load LUT '%s': %w", file_path, err)
+		}
+		return lut, nil
+	}
+
+	hald, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Hald image '%s': %w", file_path, err)
+	}
+	lut, err := filter.HaldLUT(hald)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load LUT '%s': %w", file_path, err)
+	}
+	return lut, nil
+}
+
+// Create LUT operation, the LUT is parsed when the operation is created.
+func lutOperation(lc LUTConfig) Operation {
+
+	strength := 1.0
+	if lc.Strength != nil {
+		strength = *lc.Strength
+	}
+
+	lut, err := lc.load()
+	if err != nil {
+		return failedOperation(err)
+	}
+
+	return applyImageFilter(filter.ApplyLUT(lut, lc.Interpolation, strength))
+}
@@ -1,43 +1,209 @@
 package config
 
 import (
-	op "imagecore/operation" // Grab `EncoderOption` from operation package.
+	"image"
+	"imagetools/filter"
+	"imagetools/metadata"
 )
 
 // This ts a utility function to convert pipeline block to image operation.
 
-func PipelineBlockToOperation(pb PipelineBlock) op.Operation {
+func PipelineBlockToOperation(pb PipelineBlock) Operation {
 
 	// We assume the loaded config has been fully checked.
 	// Therefore we don't return error here.
 	switch pb.Operation {
 
 	case OperationDecode:
-		return op.Decode()
+		return decodeImage()
 
-	case OperationResize: // Resize block.
-		if pb.Resize.Factor != 0.0 { // `Factor` nas first priority.
-			return op.ResizeImageByFactor(pb.Resize.Algorithm, pb.Resize.Factor)
-		} else if pb.Resize.Width != 0 { // If `Factor` is not set, use `Width`.
-			return op.ResizeImageByWidth(pb.Resize.Algorithm, pb.Resize.Width)
-		} else if pb.Resize.Height != 0 { // If `Width` is not set, use `Height`.
-			return op.ResizeImageByHeight(pb.Resize.Algorithm, pb.Resize.Height)
-		} else { // Empty resize block.
-			return nil // This should not happen, since the config has been checked.
-		}
+	case OperationResize: // Resize block, target size depends on current image size.
+		return resizeOperation(*pb.Resize)
 
-	case OperationCrop: // Crop block.
-		return op.Crop(pb.Crop.Width, pb.Crop.Height, pb.Crop.Alignment)
+	case OperationCrop: // Crop block, percentages, aspect, margins and smart alignment depend on current image.
+		return cropOperation(*pb.Crop, pb.assignedFilePath)
 
 	case OperationEncode: // Encode block.
-		return op.Encode(pb.Encode.Format, (*op.EncoderOption)(pb.Encode.Options))
+		return encodeImage(pb.Encode.Format, pb.Encode.Options)
 
 	case OperationIccEmbed: // ICC embedding block.
-		return op.EmbedProfile(pb.ICCEmbedProfile.ProfileName)
+		return iccEmbedOperation(*pb.ICCEmbedProfile, pb.jobMetadata())
 
 	case OperationWrite: // File output block.
-		return op.WriteImageToFile(pb.Write.GenerateFileName())
+		return writeImageFile(pb.Write.GenerateFileName())
+
+	case OperationRotate: // Rotate block.
+		background, _ := filter.ParseColor(pb.Rotate.Background) // Checked while loading config.
+		return applyImageFilter(filter.Rotate(pb.Rotate.Angle, background))
+
+	case OperationFlip: // Flip block.
+		return applyImageFilter(filter.Flip(pb.Flip.Direction))
+
+	case OperationAutoOrient: // EXIF orientation block.
+		return autoOrient(pb.assignedFilePath, pb.jobMetadata())
+
+	case OperationPad: // Pad block.
+		return padOperation(*pb.Pad)
+
+	case OperationTrim: // Trim block.
+		if pb.Trim.Background == "" { // Detect border color from image.
+			return applyImageFilter(filter.Trim(pb.Trim.Tolerance, pb.Trim.Padding, nil))
+		}
+		background, _ := filter.ParseColor(pb.Trim.Background) // Checked while loading config.
+		return applyImageFilter(filter.Trim(pb.Trim.Tolerance, pb.Trim.Padding, &background))
+
+	case OperationWatermark: // Watermark block.
+		return watermarkOperation(*pb.Watermark)
+
+	case OperationText: // Text block.
+		return textOperation(*pb.Text, pb.assignedFilePath)
+
+	case OperationAdjust: // Adjust block.
+		return applyImageFilter(filter.Adjust(pb.Adjust.adjustment()))
+
+	case OperationLevels: // Levels block.
+		return applyImageFilter(pb.Levels.filter())
+
+	case OperationCurves: // Curves block.
+		curves, _ := pb.Curves.curves() // Checked while loading config.
+		return applyImageFilter(filter.ApplyToneCurves(curves[0], curves[1], curves[2]))
+
+	case OperationLUT: // LUT block.
+		return lutOperation(*pb.LUT)
+
+	case OperationIccConvert: // ICC conversion block.
+		return iccConvertOperation(*pb.ICCConvert, pb.jobMetadata())
+
+	case OperationGrayscale: // Grayscale block.
+		return applyImageFilter(filter.Grayscale(pb.Grayscale.weights()))
+
+	case OperationSepia: // Sepia block.
+		return applyImageFilter(filter.Sepia(pb.Sepia.strength()))
+
+	case OperationDuotone: // Duotone block.
+		return applyImageFilter(pb.Duotone.filter())
+
+	case OperationChannels: // Channels block.
+		return applyImageFilter(filter.MapChannels(pb.Channels.sources()))
+
+	case OperationInvert: // Invert block.
+		return applyImageFilter(filter.Invert())
+
+	case OperationSharpen: // Sharpen block.
+		return applyImageFilter(pb.Sharpen.filter())
+
+	case OperationBlur: // Blur block.
+		return applyImageFilter(filter.Blur(pb.Blur.Type, pb.Blur.Radius))
+
+	case OperationConvolve: // Convolve block.
+		return applyImageFilter(filter.Convolve(pb.Convolve.Kernel, pb.Convolve.Divisor, pb.Convolve.Bias, pb.Convolve.Edge))
+
+	case OperationFlatten: // Flatten block.
+		return applyImageFilter(pb.Flatten.filter())
+
+	case OperationAlpha: // Alpha block.
+		return applyImageFilter(pb.Alpha.filter())
+
+	case OperationBorder: // Border block.
+		return borderOperation(*pb.Border)
+
+	case OperationRoundCorners: // Round corners block.
+		return roundCornersOperation(*pb.RoundCorners)
+
+	case OperationQuantize: // Quantize block.
+		return applyImageFilter(filter.Quantize(pb.Quantize.Colors, pb.Quantize.Method, pb.Quantize.Dither))
 	default:
 		return nil // This should not happen, since the config has been checked.
 	}
 }
+
+// Convert pipeline block to image operations.
+//
+// Some blocks expand to several operations, e.g. `decode` with `auto_orient` enabled.
+func PipelineBlockToOperations(pb PipelineBlock) []Operation {
+
+	operations := []Operation{PipelineBlockToOperation(pb)}
+
+	switch pb.Operation {
+	case OperationDecode: // Embedded profile is read from encoded data first.
+		operations = append([]Operation{readJobMetadata(pb.jobMetadata())}, operations...)
+		if pb.Decode != nil && pb.Decode.AutoOrient {
+			operations = append(operations, autoOrient(pb.assignedFilePath, pb.jobMetadata()))
+		}
+	case OperationEncode: // Formats without alpha channel would show hidden colors of transparent pixels.
+		if pb.Encode.needsFlatten() {
+			operations = append([]Operation{applyImageFilter(pb.Encode.flatten())}, operations...)
+		}
+		if pb.Encode.KeepICC == nil || *pb.Encode.KeepICC {
+			operations = append(operations, carryProfile(pb.jobMetadata()))
+		}
+	}
+
+	return operations
+}
+
+// Get metadata of current job, blocks without assigned input file get their own.
+func (pb *PipelineBlock) jobMetadata() *JobMetadata {
+	if pb.job == nil {
+		pb.job = &JobMetadata{}
+	}
+	return pb.job
+}
+
+// Apply EXIF orientation of input file to decoded image.
+//
+// Orientation is applied once per decoded input, see `JobMetadata.Oriented`.
+func autoOrient(input_file string, job *JobMetadata) Operation {
+	orient := applyImageFilter(func(img image.Image) (image.Image, error) {
+		exif, err := metadata.ReadExif(input_file)
+		if err != nil {
+			return nil, err
+		}
+		return filter.Orient(exif.Orientation)(img)
+	})
+
+	return func(pi ProcessingImage) ProcessingImage {
+		if job.Oriented {
+			return pi
+		}
+		pi = orient(pi)
+		job.Oriented = pi.lastErr == nil
+		return pi
+	}
+}
+
+// Capture current image dimensions and transparency into `state`.
+//
+// The image is passed through untouched, this is used to evaluate `when` clauses mid-pipeline.
+func CaptureImageState(state *ImageState) Operation {
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil { // Image not decoded yet.
+			return img, nil
+		}
+		state.Width = img.Bounds().Dx()
+		state.Height = img.Bounds().Dy()
+		state.HasAlpha = hasAlpha(img)
+		return img, nil
+	})
+}
+
+// Check if image contains any non-opaque pixel.
+func hasAlpha(img image.Image) bool {
+
+	// Most standard image types can answer this directly.
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a != 0xffff {
+				return true
+			}
+		}
+	}
+
+	return false
+}
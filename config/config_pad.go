@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"image"
+	"imagetools/filter"
+	"math"
+)
+
+var (
+	ErrInvalidPadTarget = errors.New("pad block requires width, height or aspect")
+	ErrInvalidAlignment = errors.New("unsupported alignment")
+	ErrInvalidPadFill   = errors.New("unsupported pad fill")
+)
+
+// Check the integrity of pad configuration.
+func (pc PadConfig) check() error {
+
+	if pc.Width < 0 || pc.Height < 0 || (pc.Width == 0 && pc.Height == 0 && pc.Aspect == "") {
+		return ErrInvalidPadTarget
+	}
+
+	if pc.Aspect != "" {
+		_, err := parseAspect(pc.Aspect)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, _, ok := filter.AnchorPosition(pc.Alignment); !ok {
+		return ErrInvalidAlignment
+	}
+
+	switch pc.Fill {
+	case "", filter.FillColor, filter.FillBlur:
+	default:
+		return ErrInvalidPadFill
+	}
+
+	_, err := filter.ParseColor(pc.Background)
+	return err
+}
+
+// Compute canvas size for image of given size.
+//
+// Canvas never shrinks below the image.
+func (pc PadConfig) targetSize(width int, height int) (int, int) {
+
+	canvas_w, canvas_h := max(width, pc.Width), max(height, pc.Height)
+
+	// Extend one side to match the aspect ratio.
+	if aspect, err := parseAspect(pc.Aspect); err == nil && pc.Aspect != "" && canvas_h != 0 {
+		if float64(canvas_w)/float64(canvas_h) < aspect {
+			canvas_w = int(math.Round(float64(canvas_h) * aspect))
+		} else {
+			canvas_h = int(math.Round(float64(canvas_w) / aspect))
+		}
+	}
+
+	return canvas_w, canvas_h
+}
+
+// Create pad operation which computes canvas size from current image.
+func padOperation(pc PadConfig) Operation {
+
+	background, _ := filter.ParseColor(pc.Background) // Checked while loading config.
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+		canvas_w, canvas_h := pc.targetSize(img.Bounds().Dx(), img.Bounds().Dy())
+		return filter.Pad(canvas_w, canvas_h, pc.Alignment, background, pc.Fill)(img)
+	})
+}
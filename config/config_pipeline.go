@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders of input images.
+	"image/jpeg"
+	"image/png"
+	"imagetools/filter"
+	"imagetools/icc"
+	"imagetools/metadata"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidEncodeFormat = errors.New("unsupported encode format")
+	ErrUnknownIccName      = errors.New("unknown built-in ICC profile")
+)
+
+// Image being processed by pipeline.
+//
+// Blocks work on encoded data (e.g. metadata and profiles) or on decoded image (pixel blocks).
+// The first error stops the pipeline, later operations are skipped.
+type ProcessingImage struct {
+	data    []byte      // Encoded data, input file until `encode` block.
+	img     image.Image // Decoded image, nil before `decode` block.
+	encoded bool        // `data` was produced by `encode` block.
+	profile []byte      // Profile embedded by next encoding, set by `icc_embed` block before `encode`.
+	lastErr error       // First error of pipeline.
+}
+
+// Operation on image being processed.
+type Operation func(ProcessingImage) ProcessingImage
+
+// Apply operation, nothing is done after an error.
+func (pi ProcessingImage) Then(operation Operation) ProcessingImage {
+	if pi.lastErr != nil {
+		return pi
+	}
+	if operation == nil {
+		pi.lastErr = ErrNotImplemented
+		return pi
+	}
+	return operation(pi)
+}
+
+// Get first error of pipeline, nil if all operations succeeded.
+func (pi ProcessingImage) LastError() error {
+	return pi.lastErr
+}
+
+// Read input image file, it is decoded by `decode` block.
+func ReadImageFile(file_path string) (ProcessingImage, error) {
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		return ProcessingImage{}, err
+	}
+	return ProcessingImage{data: data}, nil
+}
+
+// Create operation failing with error, for operations whose setup failed.
+func failedOperation(err error) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		pi.lastErr = err
+		return pi
+	}
+}
+
+// Create operation applying filter to decoded image.
+func applyImageFilter(fn filter.Filter) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		pi.img, pi.lastErr = fn(pi.img)
+		return pi
+	}
+}
+
+// Create operation applying function to encoded data.
+func applyEncodedFilter(fn func(data []byte) ([]byte, error)) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		pi.data, pi.lastErr = fn(pi.data)
+		return pi
+	}
+}
+
+// Create operation decoding input data.
+func decodeImage() Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		pi.img, _, pi.lastErr = image.Decode(bytes.NewReader(pi.data))
+		return pi
+	}
+}
+
+// Check if the format can be encoded, see `encodeImage`.
+func isEncodeFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg", "png":
+		return true
+	}
+	return false
+}
+
+// Create operation encoding decoded image.
+//
+// format: Either `jpeg` (`jpg`) or `png`. Paletted images are written as paletted PNG.
+// options: Encoder options, JPEG quality is the library default if not set.
+func encodeImage(format string, options *OutputOptionConfig) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		if pi.img == nil {
+			pi.lastErr = filter.ErrNoImage
+			return pi
+		}
+
+		buf := &bytes.Buffer{}
+		switch strings.ToLower(format) {
+		case "jpeg", "jpg":
+			quality := jpeg.DefaultQuality
+			if options != nil && options.Quality != 0 {
+				quality = options.Quality
+			}
+			pi.lastErr = jpeg.Encode(buf, pi.img, &jpeg.Options{Quality: quality})
+		case "png":
+			pi.lastErr = png.Encode(buf, pi.img)
+		default:
+			pi.lastErr = fmt.Errorf("%w: '%s'", ErrInvalidEncodeFormat, format)
+		}
+		if pi.lastErr != nil {
+			return pi
+		}
+
+		pi.data, pi.encoded = buf.Bytes(), true
+		if pi.profile != nil {
+			pi.data, pi.lastErr = metadata.EmbedICCProfile(pi.data, pi.profile)
+		}
+		return pi
+	}
+}
+
+// Create operation embedding ICC profile.
+//
+// Encoded image gets the profile right away, otherwise it is embedded by the next encoding.
+func embedProfile(profile []byte) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		if !pi.encoded {
+			pi.profile = profile
+			return pi
+		}
+		pi.data, pi.lastErr = metadata.EmbedICCProfile(pi.data, profile)
+		return pi
+	}
+}
+
+// Create operation embedding built-in ICC profile by name, see `icc.Named`.
+func embedNamedProfile(name string) Operation {
+	profile, ok := icc.Named(name)
+	if !ok {
+		return failedOperation(fmt.Errorf("%w: '%s'", ErrUnknownIccName, name))
+	}
+	return embedProfile(profile.Bytes())
+}
+
+// Create operation writing encoded data to file.
+func writeImageFile(file_path string) Operation {
+	return func(pi ProcessingImage) ProcessingImage {
+		pi.lastErr = os.WriteFile(file_path, pi.data, 0644)
+		return pi
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"imagetools/filter"
+	"imagetools/metadata"
+)
+
+// Planned result of a single pipeline block, computed without decoding pixel data.
+//
+// Operation: Operation name.
+//
+// Skipped: The block will be skipped since its `when` clause is not met.
+//
+// Width, Height: Image dimensions after this block.
+//
+// OutputPath: Output file path, only set for `write` block.
+type PlanStep struct {
+	Operation  string // Operation name.
+	Condition  string // Condition of the block, if any.
+	Skipped    bool   // Block skipped since condition not met.
+	Width      int    // Image width after this block.
+	Height     int    // Image height after this block.
+	OutputPath string // Output file path of `write` block.
+}
+
+// Compute execution plan of the profile from input file header.
+//
+// Input file must be assigned before calling this function, otherwise output paths are meaningless.
+// Since pixels are never decoded, `has_alpha` is always treated as false when evaluating conditions.
+//
+// header: Header of input file.
+func (pf ImageProcessingProfile) Plan(header metadata.Header) ([]PlanStep, error) {
+
+	steps := []PlanStep{}
+	state := ImageState{Width: header.Width, Height: header.Height, Format: header.Format}
+	oriented, encoded := false, false // Same rules as `JobMetadata.Oriented`.
+
+	for _, pb := range pf.PipelineBlocks {
+
+		step := PlanStep{Operation: pb.Operation, Condition: pb.When}
+
+		apply, err := pb.ShouldApply(state)
+		if err != nil {
+			return nil, err
+		}
+
+		if apply {
+			state.Width, state.Height = OutputDimensions(pb, state.Width, state.Height)
+			if pb.Operation == OperationDecode && !encoded { // Input is decoded as stored again.
+				state.Width, state.Height, oriented = header.Width, header.Height, false
+			}
+			if orientsImage(pb) && !oriented {
+				oriented = true
+				if header.Orientation >= 5 { // Orientations 5 to 8 swap width and height.
+					state.Width, state.Height = state.Height, state.Width
+				}
+			}
+			if pb.Operation == OperationEncode {
+				encoded = true
+			}
+			if pb.Operation == OperationWrite {
+				step.OutputPath = pb.Write.GenerateFileName()
+			}
+		} else {
+			step.Skipped = true
+		}
+
+		step.Width, step.Height = state.Width, state.Height
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// Compute image dimensions after applying the block.
+//
+// pb: Pipeline block.
+// width, height: Image dimensions before the block.
+func OutputDimensions(pb PipelineBlock, width int, height int) (int, int) {
+
+	switch pb.Operation {
+
+	case OperationCrop: // Crop never exceeds original image.
+		rect := pb.Crop.cropRect(width, height)
+		return rect.Dx(), rect.Dy()
+
+	case OperationResize:
+		_, _, final_w, final_h := pb.Resize.targetSize(width, height)
+		return final_w, final_h
+
+	case OperationRotate:
+		return filter.RotatedSize(width, height, pb.Rotate.Angle)
+
+	case OperationPad:
+		return pb.Pad.targetSize(width, height)
+
+	case OperationBorder:
+		return pb.Border.targetSize(width, height)
+	}
+
+	// Other blocks keep image dimensions.
+	// Trim depends on image content, the original size is its upper bound.
+	return width, height
+}
+
+// Check if the block applies EXIF orientation.
+func orientsImage(pb PipelineBlock) bool {
+	return pb.Operation == OperationAutoOrient || (pb.Operation == OperationDecode && pb.Decode != nil && pb.Decode.AutoOrient)
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"imagetools/filter"
+)
+
+var (
+	ErrInvalidPaletteSize    = errors.New("palette size must be within 2 to 256")
+	ErrInvalidQuantizeMethod = errors.New("unsupported quantization method")
+	ErrInvalidDitherMethod   = errors.New("unsupported dithering method")
+)
+
+// Check the integrity of quantize configuration.
+func (qc QuantizeConfig) check() error {
+
+	if qc.Colors < 2 || qc.Colors > 256 {
+		return ErrInvalidPaletteSize
+	}
+	if !filter.IsQuantizeMethod(qc.Method) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidQuantizeMethod, qc.Method)
+	}
+	if !filter.IsDitherMethod(qc.Dither) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidDitherMethod, qc.Dither)
+	}
+	return nil
+}
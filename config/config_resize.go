@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"image"
+	"imagetools/filter"
+	"math"
+	"strings"
+)
+
+// Resize modes, see `ResizeConfig`.
+const (
+	ResizeModeContain = "contain" // Fit inside target, preserving aspect ratio.
+	ResizeModeCover   = "cover"   // Cover target preserving aspect ratio, then crop overflow at center.
+	ResizeModeStretch = "stretch" // Exact target size, aspect ratio is ignored.
+	ResizeModeInside  = "inside"  // Same as `contain`, but never upscale.
+	ResizeModeOutside = "outside" // Smallest size covering target, preserving aspect ratio, no cropping.
+)
+
+var (
+	ErrInvalidResizeMode   = errors.New("unsupported resize mode")
+	ErrInvalidResizeTarget = errors.New("resize mode requires both width and height")
+	ErrInvalidResizeValue  = errors.New("resize dimensions must not be negative")
+	ErrInvalidResizeAlgo   = errors.New("unsupported resize algorithm")
+)
+
+// Check the integrity of resize configuration.
+func (rc ResizeConfig) check() error {
+
+	if rc.Width < 0 || rc.Height < 0 || rc.Factor < 0 || rc.MaxWidth < 0 || rc.MaxHeight < 0 || rc.MinWidth < 0 || rc.MinHeight < 0 {
+		return ErrInvalidResizeValue
+	}
+
+	switch rc.Mode {
+	case "":
+		// Legacy behavior, `Factor`, `Width` and `Height` by priority.
+	case ResizeModeContain, ResizeModeCover, ResizeModeStretch, ResizeModeInside, ResizeModeOutside:
+		if rc.Width == 0 || rc.Height == 0 {
+			return ErrInvalidResizeTarget
+		}
+	default:
+		return ErrInvalidResizeMode
+	}
+
+	if rc.Algorithm != "" && !filter.IsResizeAlgorithm(strings.ToLower(rc.Algorithm)) {
+		return ErrInvalidResizeAlgo
+	}
+
+	return nil
+}
+
+// Compute resize target for image of given size.
+//
+// Returns size to resample to, and final size after cropping. They only differ in `cover` mode.
+//
+// width, height: Image size before resizing.
+func (rc ResizeConfig) targetSize(width int, height int) (int, int, int, int) {
+
+	if width == 0 || height == 0 { // Unknown size.
+		return width, height, width, height
+	}
+
+	w, h := float64(width), float64(height)
+	target_w, target_h := float64(rc.Width), float64(rc.Height)
+
+	// Resample size, in float to avoid accumulating rounding error.
+	resize_w, resize_h := w, h
+
+	switch rc.Mode {
+	case ResizeModeContain:
+		scale := math.Min(target_w/w, target_h/h)
+		resize_w, resize_h = w*scale, h*scale
+	case ResizeModeInside:
+		scale := math.Min(1, math.Min(target_w/w, target_h/h))
+		resize_w, resize_h = w*scale, h*scale
+	case ResizeModeCover, ResizeModeOutside:
+		scale := math.Max(target_w/w, target_h/h)
+		resize_w, resize_h = w*scale, h*scale
+	case ResizeModeStretch:
+		resize_w, resize_h = target_w, target_h
+	default: // Legacy behavior, `Factor`, `Width` and `Height` by priority.
+		if rc.Factor != 0.0 {
+			resize_w, resize_h = w*float64(rc.Factor), h*float64(rc.Factor)
+		} else if rc.Width != 0 {
+			resize_w, resize_h = target_w, h*target_w/w
+		} else if rc.Height != 0 {
+			resize_w, resize_h = w*target_h/h, target_h
+		}
+	}
+
+	// Final size, only `cover` crops the overflow.
+	final_w, final_h := resize_w, resize_h
+	if rc.Mode == ResizeModeCover {
+		final_w, final_h = target_w, target_h
+	}
+
+	// Apply constraints on final size, minimum first so maximum wins on conflict.
+	scale := 1.0
+	if rc.MinWidth != 0 && final_w*scale < float64(rc.MinWidth) {
+		scale = float64(rc.MinWidth) / final_w
+	}
+	if rc.MinHeight != 0 && final_h*scale < float64(rc.MinHeight) {
+		scale = float64(rc.MinHeight) / final_h
+	}
+	if rc.MaxWidth != 0 && final_w*scale > float64(rc.MaxWidth) {
+		scale = float64(rc.MaxWidth) / final_w
+	}
+	if rc.MaxHeight != 0 && final_h*scale > float64(rc.MaxHeight) {
+		scale = float64(rc.MaxHeight) / final_h
+	}
+
+	return roundDimension(resize_w * scale), roundDimension(resize_h * scale), roundDimension(final_w * scale), roundDimension(final_h * scale)
+}
+
+// Round dimension to pixels, at least 1 pixel.
+func roundDimension(size float64) int {
+	return max(1, int(math.Round(size)))
+}
+
+// Create resize operation which computes target size from current image.
+func resizeOperation(rc ResizeConfig) Operation {
+
+	algorithm := strings.ToLower(rc.Algorithm)
+	if algorithm == "" {
+		algorithm = filter.AlgorithmCatmullRom
+	}
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+
+		resize_w, resize_h, final_w, final_h := rc.targetSize(img.Bounds().Dx(), img.Bounds().Dy())
+
+		resized, err := filter.Resize(resize_w, resize_h, algorithm, rc.Linear)(img)
+		if err != nil {
+			return nil, err
+		}
+
+		if resize_w == final_w && resize_h == final_h {
+			return resized, nil
+		}
+
+		// Crop overflow at center.
+		return filter.Crop((resize_w-final_w)/2, (resize_h-final_h)/2, final_w, final_h)(resized)
+	})
+}
@@ -2,18 +2,46 @@ package config
 
 import (
 	"errors"
+	"image"
+	"imagetools/filter"
+	"imagetools/icc"
 
 	op "imagecore/operation" // Grab `EncoderOption` from operation package.
 )
 
 // Constants
 const (
-	OperationDecode   = "decode"    // Block signature for decoding image, should be the first block in the pipeline.
-	OperationCrop     = "crop"      // Block signature for cropping image.
-	OperationResize   = "resize"    // Block signature for resizing image.
-	OperationIccEmbed = "icc_embed" // Block signature for embedding ICC profile.
-	OperationEncode   = "encode"    // Block signature for encoding image.
-	OperationWrite    = "write"     // Block signature for writing image to file.
+	OperationDecode       = "decode"        // Block signature for decoding image, should be the first block in the pipeline.
+	OperationCrop         = "crop"          // Block signature for cropping image.
+	OperationResize       = "resize"        // Block signature for resizing image.
+	OperationIccEmbed     = "icc_embed"     // Block signature for embedding ICC profile.
+	OperationEncode       = "encode"        // Block signature for encoding image.
+	OperationWrite        = "write"         // Block signature for writing image to file.
+	OperationRotate       = "rotate"        // Block signature for rotating image.
+	OperationFlip         = "flip"          // Block signature for mirroring image.
+	OperationAutoOrient   = "auto_orient"   // Block signature for applying EXIF orientation.
+	OperationPad          = "pad"           // Block signature for placing image on a larger canvas.
+	OperationTrim         = "trim"          // Block signature for removing uniform borders.
+	OperationWatermark    = "watermark"     // Block signature for compositing watermark onto image.
+	OperationText         = "text"          // Block signature for drawing text onto image.
+	OperationAdjust       = "adjust"        // Block signature for tonal and color adjustments.
+	OperationLevels       = "levels"        // Block signature for levels adjustment.
+	OperationCurves       = "curves"        // Block signature for tone curves.
+	OperationLUT          = "lut"           // Block signature for 3D LUT color grading.
+	OperationIccConvert   = "icc_convert"   // Block signature for converting pixels between ICC profiles.
+	OperationGrayscale    = "grayscale"     // Block signature for grayscale conversion.
+	OperationSepia        = "sepia"         // Block signature for sepia tone.
+	OperationDuotone      = "duotone"       // Block signature for duotone and gradient map.
+	OperationChannels     = "channels"      // Block signature for channel extraction and swapping.
+	OperationInvert       = "invert"        // Block signature for inverting colors.
+	OperationSharpen      = "sharpen"       // Block signature for unsharp mask sharpening.
+	OperationBlur         = "blur"          // Block signature for blurring image.
+	OperationConvolve     = "convolve"      // Block signature for convolution with custom kernel.
+	OperationFlatten      = "flatten"       // Block signature for compositing image onto opaque background.
+	OperationAlpha        = "alpha"         // Block signature for alpha channel handling.
+	OperationBorder       = "border"        // Block signature for surrounding image with border.
+	OperationRoundCorners = "round_corners" // Block signature for rounding image corners.
+	OperationQuantize     = "quantize"      // Block signature for palette quantization.
 )
 
 // Errors
@@ -46,37 +74,496 @@ type OutputConfig struct {
 //
 // Factor: Resize factor.
 //
-// Algorithm: Resize algorithm. Either `nearestneighbor`, `catmullrom`, or `approxbilinear`.
+// Algorithm: Resize algorithm. One of `nearestneighbor`, `catmullrom`, `approxbilinear`,
+// `mitchell`, `lanczos2`, `lanczos3` or `box` (area averaging, for large reductions).
 //
-// NOTE: The `Factor` is prioritized over `Width` and `Height`.
+// Linear: Resize in linear light (gamma-correct), avoids dark halos around line art.
+//
+// Mode: Use both `Width` and `Height` as target box. One of `contain`, `cover`, `stretch`, `inside` or `outside`.
+//
+// MaxWidth, MaxHeight, MinWidth, MinHeight: Constraints on output size, aspect ratio is preserved.
+// Maximum wins if constraints conflict.
+//
+// NOTE: Without `Mode`, the `Factor` is prioritized over `Width` and `Height`.
 type ResizeConfig struct {
-	Width     int     `yaml:"width"`     // Output image width
-	Height    int     `yaml:"height"`    // Output image height
-	Factor    float32 `yaml:"factor"`    // Resize factor
-	Algorithm string  `yaml:"algorithm"` // Resize algorithm
+	Width     int     `yaml:"width"`      // Output image width
+	Height    int     `yaml:"height"`     // Output image height
+	Factor    float32 `yaml:"factor"`     // Resize factor
+	Algorithm string  `yaml:"algorithm"`  // Resize algorithm
+	Mode      string  `yaml:"mode"`       // Resize mode
+	MaxWidth  int     `yaml:"max_width"`  // Maximum output width
+	MaxHeight int     `yaml:"max_height"` // Maximum output height
+	MinWidth  int     `yaml:"min_width"`  // Minimum output width
+	MinHeight int     `yaml:"min_height"` // Minimum output height
+	Linear    bool    `yaml:"linear"`     // Resize in linear light
+}
+
+// Config structure for decoding image.
+//
+// AutoOrient: Apply EXIF orientation right after decoding, so the image is upright.
+// Encoded output never carries the source EXIF, hence the orientation tag is reset as well.
+type DecodeConfig struct {
+	AutoOrient bool `yaml:"auto_orient"` // Apply EXIF orientation
+}
+
+// Config structure for rotating image.
+//
+// Angle: Rotation angle in degrees, clockwise. Multiples of 90 are lossless.
+//
+// Background: Fill color of uncovered area for other angles, e.g. `#ffffff`. Transparent if omitted.
+type RotateConfig struct {
+	Angle      float64 `yaml:"angle"`      // Rotation angle
+	Background string  `yaml:"background"` // Background color
+}
+
+// Config structure for mirroring image.
+//
+// Direction: One of `horizontal`, `vertical` or `both`.
+type FlipConfig struct {
+	Direction string `yaml:"direction"` // Flip direction
+}
+
+// Config structure for placing image on a larger canvas.
+//
+// Width, Height: Canvas size. Canvas never shrinks below the image.
+//
+// Aspect: Canvas aspect ratio, e.g. `1:1` or `4:5`. One side is extended to match it.
+//
+// Alignment: Image position on canvas. One of `center`, `top`, `bottom`, `left`, `right`,
+// `topleft`, `topright`, `bottomleft` or `bottomright`.
+//
+// Background: Background color, e.g. `#ffffff`. Transparent if omitted.
+//
+// Fill: Either `color` (default) or `blur`, which fills with blurred and enlarged copy of the image.
+type PadConfig struct {
+	Width      int    `yaml:"width"`      // Canvas width
+	Height     int    `yaml:"height"`     // Canvas height
+	Aspect     string `yaml:"aspect"`     // Canvas aspect ratio
+	Alignment  string `yaml:"alignment"`  // Image position
+	Background string `yaml:"background"` // Background color
+	Fill       string `yaml:"fill"`       // Fill mode
 }
 
+// Config structure for removing uniform borders.
+//
+// Tolerance: Maximum channel difference to border color, in percent. 0 only trims exact matches.
+//
+// Padding: Pixels of the border kept around the content.
+//
+// Background: Border color, e.g. `#ffffff`. Color of top-left pixel if omitted.
+// Fully transparent pixels always match each other, whatever their color channels are.
+type TrimConfig struct {
+	Tolerance  float64 `yaml:"tolerance"`  // Color difference tolerance
+	Padding    int     `yaml:"padding"`    // Border kept around content
+	Background string  `yaml:"background"` // Border color
+}
+
+// Config structure for compositing watermark onto image.
+//
+// File: Path to watermark image, usually a PNG with transparency.
+//
+// Alignment: Watermark position. One of `center`, `top`, `bottom`, `left`, `right`,
+// `topleft`, `topright`, `bottomleft` or `bottomright`.
+//
+// OffsetX, OffsetY: Offset added to aligned position, in pixels or percentage of the image, may be negative.
+//
+// Scale: Watermark width relative to image width, e.g. `0.2`. Original size if omitted.
+//
+// Opacity: Watermark opacity in [0, 1]. Opaque if omitted.
+//
+// Blend: Blend mode. One of `normal`, `multiply`, `screen`, `overlay`, `darken`, `lighten` or `difference`.
+//
+// Tile: Repeat watermark across the whole image, starting from aligned position.
+//
+// Spacing: Gap between tiles, in pixels or percentage of the image.
+type WatermarkConfig struct {
+	File      string   `yaml:"file"`               // Watermark image path
+	Alignment string   `yaml:"alignment"`          // Watermark position
+	OffsetX   Length   `yaml:"offset_x,omitempty"` // Horizontal offset from aligned position
+	OffsetY   Length   `yaml:"offset_y,omitempty"` // Vertical offset from aligned position
+	Scale     float64  `yaml:"scale"`              // Width relative to image width
+	Opacity   *float64 `yaml:"opacity,omitempty"`  // Watermark opacity
+	Blend     string   `yaml:"blend"`              // Blend mode
+	Tile      bool     `yaml:"tile"`               // Repeat across the image
+	Spacing   Length   `yaml:"spacing,omitempty"`  // Gap between tiles
+
+	mark *sharedValues[image.Image] // Decoded watermark, shared by all jobs.
+}
+
+// Config structure for encoding image.
+//
+// Format: Output format, either `jpeg` or `png`.
+//
+// Options: Encoder options.
+//
+// KeepICC: Embed color profile of the image, i.e. the input profile or the `icc_convert` target. Enabled if omitted.
+//
+// Background: Formats without alpha channel (`jpeg`) are flattened onto this color before encoding. White if omitted.
 type EncodeConfig struct {
-	Format  string              `yaml:"format"`  // Output file format
-	Options *OutputOptionConfig `yaml:"options"` // Encoder option
+	Format     string              `yaml:"format"`               // Output file format
+	Options    *OutputOptionConfig `yaml:"options"`              // Encoder option
+	KeepICC    *bool               `yaml:"keep_icc,omitempty"`   // Carry color profile into output
+	Background string              `yaml:"background,omitempty"` // Background of automatic flattening
 }
 
+// Config structure for embedding ICC profile.
+//
+// ProfileName: Built-in profile name, or `source` to keep the profile embedded in input image.
+//
+// File: Path to ICC profile file, used instead of profile name.
 type IccEmbedConfig struct {
-	ProfileName string `yaml:"icc_name"` // Profile name
+	ProfileName string `yaml:"icc_name,omitempty"` // Profile name
+	File        string `yaml:"icc_file,omitempty"` // Profile file path
+
+	data *sharedValues[[]byte] // Content of profile file, shared by all jobs.
+}
+
+// Config structure for converting pixels between ICC profiles.
+//
+// Source: Profile assumed if the image has no embedded profile, built-in name or ICC file. `sRGB` if omitted.
+//
+// Target: Profile to convert to, built-in name or ICC file.
+//
+// Intent: Rendering intent, one of `perceptual` (default), `relative`, `absolute`, `saturation`.
+//
+// BlackPointCompensation: Map source black onto target black.
+type IccConvertConfig struct {
+	Source                 string `yaml:"source"`                   // Assumed source profile
+	Target                 string `yaml:"target"`                   // Target profile
+	Intent                 string `yaml:"intent"`                   // Rendering intent
+	BlackPointCompensation bool   `yaml:"black_point_compensation"` // Black point compensation
 
+	profiles   *sharedValues[*icc.Profile]   // Configured profiles, keyed by name or path.
+	transforms *sharedValues[*icc.Transform] // Transforms, keyed by source profile, see `sourceKey`.
 }
 
 // Config structure for cropping image.
 //
-// Alignment: Crop alignment. One of `center` or `topleft`.
+// Alignment: Crop alignment. One of `center`, `top`, `bottom`, `left`, `right`,
+// `topleft`, `topright`, `bottomleft`, `bottomright` or `smart` (content-aware).
+//
+// Width, Height: Crop size in pixels.
 //
-// Width: Crop width.
+// WidthLength, HeightLength: Crop size, in pixels or percentage of the image, e.g. `50%`.
+// Read from `width` and `height`, overrides `Width` and `Height` if set.
+// If only one size is set, the other one is the full image, or derived from `Aspect`.
 //
-// Height: Crop height.
+// Aspect: Crop aspect ratio, e.g. `16:9`. Without size, the largest crop of that ratio is used.
+//
+// X, Y: Top-left corner of crop rectangle, overrides `Alignment` on that axis.
+//
+// OffsetX, OffsetY: Offset added to aligned position, may be negative.
+//
+// Trim: Margins removed from each side before cropping.
+//
+// Focus: Focal point kept in crop, used by `smart` alignment. Overrides focus sidecar file and content analysis.
 type CropConfig struct {
-	Alignment string `yaml:"alignment"` // Crop alignment
-	Width     int    `yaml:"width"`     // Crop width
-	Height    int    `yaml:"height"`    // Crop height
+	Alignment    string       `yaml:"alignment"`          // Crop alignment
+	Width        int          `yaml:"-"`                  // Crop width in pixels
+	Height       int          `yaml:"-"`                  // Crop height in pixels
+	WidthLength  Length       `yaml:"width"`              // Crop width
+	HeightLength Length       `yaml:"height"`             // Crop height
+	Aspect       string       `yaml:"aspect,omitempty"`   // Crop aspect ratio
+	X            Length       `yaml:"x,omitempty"`        // Left edge of crop rectangle
+	Y            Length       `yaml:"y,omitempty"`        // Top edge of crop rectangle
+	OffsetX      Length       `yaml:"offset_x,omitempty"` // Horizontal offset from aligned position
+	OffsetY      Length       `yaml:"offset_y,omitempty"` // Vertical offset from aligned position
+	Trim         *CropMargins `yaml:"trim,omitempty"`     // Margins removed before cropping
+	Focus        *CropFocus   `yaml:"focus,omitempty"`    // Focal point for smart alignment
+}
+
+// Focal point of image, in pixels or percentage of the image.
+//
+// Also the format of focus sidecar file, `<image name>.focus.yaml` next to the image.
+type CropFocus struct {
+	X Length `yaml:"x"` // Horizontal position
+	Y Length `yaml:"y"` // Vertical position
+}
+
+// Margins of crop block, in pixels or percentage of the image.
+type CropMargins struct {
+	Top    Length `yaml:"top"`    // Top margin
+	Bottom Length `yaml:"bottom"` // Bottom margin
+	Left   Length `yaml:"left"`   // Left margin
+	Right  Length `yaml:"right"`  // Right margin
+}
+
+// Length in pixels, e.g. `120`, or percentage of a reference length, e.g. `12.5%`.
+//
+// Empty length is not set.
+type Length string
+
+// Config structure for drawing text onto image.
+//
+// Text: Text to draw, lines are separated by `\n`. Placeholders: `{filename}`, `{name}` (file name without extension),
+// `{artist}` and `{copyright}` (from EXIF), `{date}` (today, `YYYY-MM-DD`).
+//
+// Font: Path to TTF or OTF font file. Bundled Go Regular font if omitted.
+//
+// Size: Font size, in pixels or percentage of image height. `5%` if omitted.
+//
+// Color: Text color, white if omitted.
+//
+// Alignment: Text position. One of `center`, `top`, `bottom`, `left`, `right`,
+// `topleft`, `topright`, `bottomleft` or `bottomright`.
+//
+// OffsetX, OffsetY: Offset added to aligned position, in pixels or percentage of the image, may be negative.
+//
+// StrokeWidth, StrokeColor: Outline around glyphs, in pixels or percentage of font size. Black if color is omitted.
+//
+// ShadowOffset, ShadowColor: Drop shadow offset towards bottom-right, in pixels or percentage of font size.
+// Half transparent black if color is omitted.
+//
+// Background: Color of box behind the text, no box if omitted.
+//
+// Padding: Space between text and box edge, in pixels or percentage of font size.
+//
+// FullWidth: Stretch box over whole image width, as a caption bar.
+type TextConfig struct {
+	Text         string `yaml:"text"`                    // Text template
+	Font         string `yaml:"font,omitempty"`          // Font file path
+	Size         Length `yaml:"size,omitempty"`          // Font size
+	Color        string `yaml:"color,omitempty"`         // Text color
+	Alignment    string `yaml:"alignment"`               // Text position
+	OffsetX      Length `yaml:"offset_x,omitempty"`      // Horizontal offset from aligned position
+	OffsetY      Length `yaml:"offset_y,omitempty"`      // Vertical offset from aligned position
+	StrokeWidth  Length `yaml:"stroke_width,omitempty"`  // Outline width
+	StrokeColor  string `yaml:"stroke_color,omitempty"`  // Outline color
+	ShadowOffset Length `yaml:"shadow_offset,omitempty"` // Shadow offset
+	ShadowColor  string `yaml:"shadow_color,omitempty"`  // Shadow color
+	Background   string `yaml:"background,omitempty"`    // Box color
+	Padding      Length `yaml:"padding,omitempty"`       // Box padding
+	FullWidth    bool   `yaml:"full_width,omitempty"`    // Box spans image width
+
+	font *sharedValues[[]byte] // Content of font file, shared by all jobs.
+}
+
+// Config structure for tonal and color adjustments, omitted fields change nothing.
+//
+// Exposure: Exposure change in stops, e.g. `0.5`. Applied in linear light.
+//
+// Gamma: Gamma correction, values above 1 brighten midtones.
+//
+// Brightness: Offset added to channels, in [-1, 1].
+//
+// Contrast: Contrast change, e.g. `0.2` for 20% more contrast. -1 is flat gray.
+//
+// Saturation: Saturation change, e.g. `-0.3` for 30% less saturation. -1 is grayscale.
+//
+// Hue: Hue rotation in degrees.
+type AdjustConfig struct {
+	Exposure   float64 `yaml:"exposure"`   // Exposure in stops
+	Gamma      float64 `yaml:"gamma"`      // Gamma correction
+	Brightness float64 `yaml:"brightness"` // Brightness offset
+	Contrast   float64 `yaml:"contrast"`   // Contrast change
+	Saturation float64 `yaml:"saturation"` // Saturation change
+	Hue        float64 `yaml:"hue"`        // Hue rotation
+}
+
+// Config structure for levels adjustment.
+//
+// InputBlack, InputWhite: Input range, stretched to output range. White is 255 if omitted.
+//
+// Gamma: Midtone gamma, values above 1 brighten midtones.
+//
+// OutputBlack, OutputWhite: Output range, raising black lifts shadows. White is 255 if omitted.
+//
+// Channel: Channel to adjust. One of `rgb` (default), `red`, `green` or `blue`.
+type LevelsConfig struct {
+	InputBlack  int     `yaml:"input_black"`  // Input black point
+	InputWhite  int     `yaml:"input_white"`  // Input white point
+	Gamma       float64 `yaml:"gamma"`        // Midtone gamma
+	OutputBlack int     `yaml:"output_black"` // Output black point
+	OutputWhite int     `yaml:"output_white"` // Output white point
+	Channel     string  `yaml:"channel"`      // Adjusted channel
+}
+
+// Config structure for tone curves.
+//
+// Each curve is a list of `[input, output]` control points in [0, 255], interpolated smoothly.
+// `RGB` curve is applied to all channels first, then the channel curves. Omitted curves change nothing.
+type CurvesConfig struct {
+	RGB   [][2]float64 `yaml:"rgb,omitempty"`   // Curve of all channels
+	Red   [][2]float64 `yaml:"red,omitempty"`   // Curve of red channel
+	Green [][2]float64 `yaml:"green,omitempty"` // Curve of green channel
+	Blue  [][2]float64 `yaml:"blue,omitempty"`  // Curve of blue channel
+}
+
+// Config structure for 3D LUT color grading.
+//
+// File: Path to `.cube` LUT, or Hald CLUT image (e.g. PNG).
+//
+// Interpolation: Either `tetrahedral` (default) or `trilinear`.
+//
+// Strength: Mix of graded and original image in [0, 1]. Full effect if omitted.
+type LUTConfig struct {
+	File          string   `yaml:"file"`               // LUT file path
+	Interpolation string   `yaml:"interpolation"`      // Interpolation method
+	Strength      *float64 `yaml:"strength,omitempty"` // Effect strength
+
+	lut *sharedValues[*filter.LUT3D] // Parsed LUT, shared by all jobs.
+}
+
+// Config structure for grayscale conversion.
+//
+// Weights: Channel weights, one of `rec709` (default), `rec601`, `luminance` (Rec. 709 weights in linear light).
+type GrayscaleConfig struct {
+	Weights string `yaml:"weights"` // Channel weights
+}
+
+// Config structure for sepia tone.
+//
+// Strength: Mix of toned and original image in [0, 1]. Full effect if omitted.
+type SepiaConfig struct {
+	Strength *float64 `yaml:"strength,omitempty"` // Effect strength
+}
+
+// Config structure for duotone, or gradient map with more colors.
+//
+// Colors: Colors from shadows to highlights, evenly spaced, at least two.
+//
+// Weights: Channel weights of gray value, same as grayscale block.
+type DuotoneConfig struct {
+	Colors  []string `yaml:"colors"`  // Gradient colors
+	Weights string   `yaml:"weights"` // Channel weights
+}
+
+// Config structure for channel extraction and swapping.
+//
+// Extract: Channel copied into all color channels, resulting in gray image. One of `red`, `green`, `blue`, `alpha`.
+// Extracted alpha channel makes the image opaque.
+//
+// Red, Green, Blue, Alpha: Source of output channels, used if not extracting. One of `red`, `green`, `blue`,
+// `alpha`, `black`, `white`. Channel is kept if omitted.
+type ChannelsConfig struct {
+	Extract string `yaml:"extract,omitempty"` // Channel to extract
+	Red     string `yaml:"red,omitempty"`     // Source of red channel
+	Green   string `yaml:"green,omitempty"`   // Source of green channel
+	Blue    string `yaml:"blue,omitempty"`    // Source of blue channel
+	Alpha   string `yaml:"alpha,omitempty"`   // Source of alpha channel
+}
+
+// Config structure for unsharp mask sharpening.
+//
+// Radius: Blur radius of the mask in pixels, about the width of enhanced edges. 1 if omitted.
+//
+// Amount: Sharpening strength, 1 adds the full difference to the blurred image. 0.5 if omitted.
+//
+// Threshold: Smallest channel difference in [0, 255] to sharpen, keeps smooth areas and noise untouched.
+type SharpenConfig struct {
+	Radius    float64  `yaml:"radius"`           // Mask radius
+	Amount    *float64 `yaml:"amount,omitempty"` // Sharpening strength
+	Threshold int      `yaml:"threshold"`        // Sharpening threshold
+}
+
+// Config structure for blurring image.
+//
+// Type: Either `gaussian` (default) or `box`.
+//
+// Radius: Blur radius in pixels. Standard deviation of gaussian blur, or half width of box blur.
+type BlurConfig struct {
+	Type   string  `yaml:"type"`   // Blur type
+	Radius float64 `yaml:"radius"` // Blur radius
+}
+
+// Config structure for convolution with custom kernel.
+//
+// Kernel: Kernel rows with odd width and height, centered on the pixel. E.g. `[[-2, -1, 0], [-1, 1, 1], [0, 1, 2]]` embosses.
+//
+// Divisor: Weighted sum is divided by it. Sum of kernel if omitted, or 1 if the kernel sums up to zero.
+//
+// Bias: Added to result in channel levels, e.g. 128 for mid gray base of edge detection.
+//
+// Edge: How pixels outside of the image are sampled. One of `clamp` (default), `wrap`, `mirror`.
+type ConvolveConfig struct {
+	Kernel  [][]float64 `yaml:"kernel"`  // Kernel rows
+	Divisor float64     `yaml:"divisor"` // Kernel divisor
+	Bias    float64     `yaml:"bias"`    // Result offset
+	Edge    string      `yaml:"edge"`    // Edge mode
+}
+
+// Config structure for compositing image onto opaque background.
+//
+// Background: Background color, e.g. `#ffffff`. White if omitted.
+//
+// Checkerboard: Cell size of checkerboard in pixels, e.g. for previews of transparency. Solid background if omitted.
+//
+// CheckerColor: Second color of checkerboard. `#cccccc` if omitted.
+type FlattenConfig struct {
+	Background   string `yaml:"background"`              // Background color
+	Checkerboard int    `yaml:"checkerboard"`            // Checkerboard cell size
+	CheckerColor string `yaml:"checker_color,omitempty"` // Second checkerboard color
+}
+
+// Config structure for alpha channel handling.
+//
+// Mode: One of the following:
+// - `remove`: Make image opaque, colors of transparent pixels show up as stored. Use `flatten` for a defined background.
+// - `add`: Scale alpha channel by `opacity`, e.g. for translucent overlays.
+// - `color_key`: Make pixels close to `color` transparent.
+// - `premultiply`: Multiply colors by alpha.
+// - `unmatte`: Remove `color` blended into semi-transparent pixels, e.g. white halo around cutouts.
+//
+// Opacity: Alpha multiplier of `add` mode in [0, 1]. Opaque if omitted.
+//
+// Color: Key color of `color_key` mode, or matte color of `unmatte` mode. White if omitted.
+//
+// Tolerance: Maximum channel difference to key color, in percent. 0 only keys exact matches.
+//
+// Feather: Range in percent above tolerance where alpha ramps up again, softens edges.
+type AlphaConfig struct {
+	Mode      string   `yaml:"mode"`              // Alpha mode
+	Opacity   *float64 `yaml:"opacity,omitempty"` // Alpha multiplier
+	Color     string   `yaml:"color"`             // Key or matte color
+	Tolerance float64  `yaml:"tolerance"`         // Key color tolerance
+	Feather   float64  `yaml:"feather"`           // Key color feather
+}
+
+// Config structure for surrounding image with border.
+//
+// Width: Border width of all sides, in pixels or percentage of the shorter image side.
+//
+// Top, Right, Bottom, Left: Border width of single side, overrides `Width`.
+//
+// Color: Border color, black if omitted.
+//
+// Padding: Space between image and border, in pixels or percentage of the shorter image side.
+//
+// PaddingColor: Color of padding, white if omitted.
+type BorderConfig struct {
+	Width        Length `yaml:"width,omitempty"`         // Border width of all sides
+	Top          Length `yaml:"top,omitempty"`           // Top border width
+	Right        Length `yaml:"right,omitempty"`         // Right border width
+	Bottom       Length `yaml:"bottom,omitempty"`        // Bottom border width
+	Left         Length `yaml:"left,omitempty"`          // Left border width
+	Color        string `yaml:"color"`                   // Border color
+	Padding      Length `yaml:"padding,omitempty"`       // Padding width
+	PaddingColor string `yaml:"padding_color,omitempty"` // Padding color
+}
+
+// Config structure for rounding image corners.
+//
+// Radius: Corner radius, in pixels or percentage of the shorter image side. `50%` makes a circle of square image.
+//
+// Background: Color outside of rounded corners, e.g. page color for JPEG output. Transparent if omitted.
+type RoundCornersConfig struct {
+	Radius     Length `yaml:"radius"`     // Corner radius
+	Background string `yaml:"background"` // Background color
+}
+
+// Config structure for palette quantization, e.g. for icons and pixel art. PNG output becomes paletted,
+// unless later pixel blocks change the image.
+//
+// Colors: Palette size in [2, 256].
+//
+// Method: How the palette is chosen. One of `median_cut` (default), `kmeans` (slower, closer colors),
+// `octree` (keeps frequent colors exact).
+//
+// Dither: One of `none` (default), `floyd_steinberg` (smooth gradients), `ordered` (regular pattern, compresses well).
+type QuantizeConfig struct {
+	Colors int    `yaml:"colors"` // Palette size
+	Method string `yaml:"method"` // Quantization method
+	Dither string `yaml:"dither"` // Dithering method
 }
 
 // Config structure for processing profile.
@@ -109,13 +596,68 @@ type OutputDirConfig struct {
 // - `icc_embed`
 // - `encode`
 // - `write`
+// - `rotate`
+// - `flip`
+// - `auto_orient`
+// - `pad`
+// - `trim`
+// - `watermark`
+// - `text`
+// - `adjust`
+// - `levels`
+// - `curves`
+// - `lut`
+// - `icc_convert`
+// - `grayscale`
+// - `sepia`
+// - `duotone`
+// - `channels`
+// - `invert`
+// - `sharpen`
+// - `blur`
+// - `convolve`
+// - `flatten`
+// - `alpha`
+// - `border`
+// - `round_corners`
+// - `quantize`
+//
+// When: Optional condition, the block is skipped if it evaluates to false. See `EvaluateCondition`.
 type PipelineBlock struct {
-	Operation       string          `yaml:"operation"`               // Operation name.
-	Crop            *CropConfig     `yaml:"crop_config,omitempty"`   // Crop configuration.
-	Resize          *ResizeConfig   `yaml:"resize_config,omitempty"` // Resize configuration.
-	ICCEmbedProfile *IccEmbedConfig `yaml:"icc_config,omitempty"`    // Embed profile configuration.
-	Encode          *EncodeConfig   `yaml:"encode_config,omitempty"` // Encode configuration.
-	Write           *OutputConfig   `yaml:"write_config,omitempty"`  // Write configuration.
+	Operation       string              `yaml:"operation"`                      // Operation name.
+	When            string              `yaml:"when,omitempty"`                 // Condition to apply this block.
+	Decode          *DecodeConfig       `yaml:"decode_config,omitempty"`        // Decode configuration.
+	Crop            *CropConfig         `yaml:"crop_config,omitempty"`          // Crop configuration.
+	Resize          *ResizeConfig       `yaml:"resize_config,omitempty"`        // Resize configuration.
+	ICCEmbedProfile *IccEmbedConfig     `yaml:"icc_config,omitempty"`           // Embed profile configuration.
+	Encode          *EncodeConfig       `yaml:"encode_config,omitempty"`        // Encode configuration.
+	Write           *OutputConfig       `yaml:"write_config,omitempty"`         // Write configuration.
+	Rotate          *RotateConfig       `yaml:"rotate_config,omitempty"`        // Rotate configuration.
+	Flip            *FlipConfig         `yaml:"flip_config,omitempty"`          // Flip configuration.
+	Pad             *PadConfig          `yaml:"pad_config,omitempty"`           // Pad configuration.
+	Trim            *TrimConfig         `yaml:"trim_config,omitempty"`          // Trim configuration.
+	Watermark       *WatermarkConfig    `yaml:"watermark_config,omitempty"`     // Watermark configuration.
+	Text            *TextConfig         `yaml:"text_config,omitempty"`          // Text configuration.
+	Adjust          *AdjustConfig       `yaml:"adjust_config,omitempty"`        // Adjust configuration.
+	Levels          *LevelsConfig       `yaml:"levels_config,omitempty"`        // Levels configuration.
+	Curves          *CurvesConfig       `yaml:"curves_config,omitempty"`        // Curves configuration.
+	LUT             *LUTConfig          `yaml:"lut_config,omitempty"`           // LUT configuration.
+	ICCConvert      *IccConvertConfig   `yaml:"icc_convert_config,omitempty"`   // Profile conversion configuration.
+	Grayscale       *GrayscaleConfig    `yaml:"grayscale_config,omitempty"`     // Grayscale configuration.
+	Sepia           *SepiaConfig        `yaml:"sepia_config,omitempty"`         // Sepia configuration.
+	Duotone         *DuotoneConfig      `yaml:"duotone_config,omitempty"`       // Duotone configuration.
+	Channels        *ChannelsConfig     `yaml:"channels_config,omitempty"`      // Channels configuration.
+	Sharpen         *SharpenConfig      `yaml:"sharpen_config,omitempty"`       // Sharpen configuration.
+	Blur            *BlurConfig         `yaml:"blur_config,omitempty"`          // Blur configuration.
+	Convolve        *ConvolveConfig     `yaml:"convolve_config,omitempty"`      // Convolution configuration.
+	Flatten         *FlattenConfig      `yaml:"flatten_config,omitempty"`       // Flatten configuration.
+	Alpha           *AlphaConfig        `yaml:"alpha_config,omitempty"`         // Alpha configuration.
+	Border          *BorderConfig       `yaml:"border_config,omitempty"`        // Border configuration.
+	RoundCorners    *RoundCornersConfig `yaml:"round_corners_config,omitempty"` // Round corners configuration.
+	Quantize        *QuantizeConfig     `yaml:"quantize_config,omitempty"`      // Quantize configuration.
+
+	assignedFilePath string       // This is used to store the file name of input image, hence no need to serialize this field.
+	job              *JobMetadata // Metadata of current job, shared by all blocks of the profile.
 }
 
 // Config structure for config file.
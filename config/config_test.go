@@ -1,7 +1,21 @@
 package config
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"imagetools/filter"
+	"imagetools/icc"
+	"imagetools/metadata"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"gopkg.in/yaml.v2"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -108,6 +122,10 @@ func TestLoadConfigAndParse(t *testing.T) {
 		t.Fatalf("Expected operation to be 'Resize', got '%s'", pb[2].Operation)
 	}
 
+	if pb[2].When != "width > 100 && aspect > 1.5" {
+		t.Fatalf("Expected condition to be 'width > 100 && aspect > 1.5', got '%s'", pb[2].When)
+	}
+
 	if pb[2].Resize.Width != 100 {
 		t.Fatalf("Expected width to be 100, got '%d'", pb[2].Resize.Width)
 	}
@@ -163,3 +181,917 @@ func TestLoadConfigAndParse(t *testing.T) {
 		t.Fatalf("Expected format to be 'jpeg', got '%s'", pb[5].Write.Format)
 	}
 }
+
+func TestLoadGeometryBlocks(t *testing.T) {
+
+	config, err := LoadConfigFromFile("test_resources/test_full_conf.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	pb := config.Profiles[1].PipelineBlocks
+
+	if pb[0].Decode == nil || !pb[0].Decode.AutoOrient {
+		t.Fatalf("Expected decode block to enable auto orientation")
+	}
+
+	if pb[1].Operation != OperationAutoOrient {
+		t.Fatalf("Expected operation to be 'auto_orient', got '%s'", pb[1].Operation)
+	}
+
+	if pb[2].Rotate.Angle != 30 || pb[2].Rotate.Background != "#ffffff" {
+		t.Fatalf("Expected rotate 30 degrees on white, got %v", pb[2].Rotate)
+	}
+
+	if pb[3].Flip.Direction != "horizontal" {
+		t.Fatalf("Expected flip direction to be 'horizontal', got '%s'", pb[3].Flip.Direction)
+	}
+
+	if pb[4].Trim.Tolerance != 2 || pb[4].Trim.Padding != 4 || pb[4].Trim.Background != "#ffffff" {
+		t.Fatalf("Expected trim with tolerance 2 and padding 4 on white, got %v", pb[4].Trim)
+	}
+
+	// Watermark path is relative to config file.
+	if pb[5].Watermark.File != filepath.Join("test_resources", "../../test_resources/test_ayaya.png") {
+		t.Fatalf("Expected watermark path relative to config file, got '%s'", pb[5].Watermark.File)
+	}
+	if *pb[5].Watermark.Opacity != 0.5 || pb[5].Watermark.Blend != "multiply" || pb[5].Watermark.OffsetX != "-2%" {
+		t.Fatalf("Expected watermark at 50%% opacity in multiply mode, got %v", pb[5].Watermark)
+	}
+
+	// Invalid flip direction.
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationFlip, Flip: &FlipConfig{Direction: "diagonal"}})
+	if !errors.Is(err, ErrInvalidFlipDirection) {
+		t.Fatalf("Expected invalid flip direction error, got %v", err)
+	}
+
+	// Invalid trim tolerance.
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationTrim, Trim: &TrimConfig{Tolerance: 120}})
+	if !errors.Is(err, ErrInvalidTrimValue) {
+		t.Fatalf("Expected invalid trim value error, got %v", err)
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+
+	state := ImageState{Width: 4800, Height: 2400, HasAlpha: true, Format: "png"}
+
+	cases := map[string]bool{
+		"width > 4000":                       true,
+		"height >= 2400":                     true,
+		"has_alpha":                          true,
+		"!has_alpha":                         false,
+		"format == 'png'":                    true,
+		"format == \"JPG\"":                  false,
+		"aspect > 1.5":                       true,
+		"aspect > 1.5 && not has_alpha":      false,
+		"(width < 100 or height < 100)":      false,
+		"width > 1000 and (format != 'gif')": true,
+	}
+
+	for expr, expected := range cases {
+		result, err := EvaluateCondition(expr, state)
+		if err != nil {
+			t.Fatalf("Failed to evaluate '%s': %v", expr, err)
+		}
+		if result != expected {
+			t.Fatalf("Expected '%s' to be %v, got %v", expr, expected, result)
+		}
+	}
+
+	// Malformed conditions.
+	for _, expr := range []string{"width >", "depth > 8", "format > 'png'", "width", "'png' == 'png", "(has_alpha"} {
+		_, err := EvaluateCondition(expr, state)
+		if !errors.Is(err, ErrInvalidCondition) {
+			t.Fatalf("Expected '%s' to be rejected, got %v", expr, err)
+		}
+	}
+}
+
+func TestProfilePlan(t *testing.T) {
+
+	config, err := LoadConfigFromFile("test_resources/test_full_conf.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	config.AssignInputFile("dummy.jpg")
+
+	// Wide image, so the resize condition is met.
+	steps, err := config.Profiles[0].Plan(metadata.Header{Format: "jpeg", Width: 4000, Height: 2000})
+	if err != nil {
+		t.Fatalf("Failed to plan profile: %v", err)
+	}
+
+	if len(steps) != 6 {
+		t.Fatalf("Expected 6 steps, got %d", len(steps))
+	}
+
+	// Crop to 50x60, then resize by factor 0.9.
+	if steps[1].Width != 50 || steps[1].Height != 60 {
+		t.Fatalf("Expected 50x60 after crop, got %dx%d", steps[1].Width, steps[1].Height)
+	}
+
+	// Cropped image is narrow, condition `width > 100` is not met.
+	if !steps[2].Skipped {
+		t.Fatalf("Expected resize to be skipped")
+	}
+
+	if steps[5].OutputPath != "prefix1_dummy_suffix1.jpg" {
+		t.Fatalf("Expected output path to be 'prefix1_dummy_suffix1.jpg', got '%s'", steps[5].OutputPath)
+	}
+}
+
+func TestResizeModes(t *testing.T) {
+
+	// Target box 1920x1080 for a 4000x3000 image.
+	cases := []struct {
+		config ResizeConfig
+		resize [2]int // Resample size.
+		final  [2]int // Output size.
+	}{
+		{ResizeConfig{Mode: ResizeModeContain, Width: 1920, Height: 1080}, [2]int{1440, 1080}, [2]int{1440, 1080}},
+		{ResizeConfig{Mode: ResizeModeCover, Width: 1920, Height: 1080}, [2]int{1920, 1440}, [2]int{1920, 1080}},
+		{ResizeConfig{Mode: ResizeModeStretch, Width: 1920, Height: 1080}, [2]int{1920, 1080}, [2]int{1920, 1080}},
+		{ResizeConfig{Mode: ResizeModeOutside, Width: 1920, Height: 1080}, [2]int{1920, 1440}, [2]int{1920, 1440}},
+		{ResizeConfig{Mode: ResizeModeInside, Width: 8000, Height: 8000}, [2]int{4000, 3000}, [2]int{4000, 3000}},
+		{ResizeConfig{MaxWidth: 2000}, [2]int{2000, 1500}, [2]int{2000, 1500}},
+		{ResizeConfig{MinHeight: 6000}, [2]int{8000, 6000}, [2]int{8000, 6000}},
+		{ResizeConfig{Width: 1000, MaxHeight: 500}, [2]int{667, 500}, [2]int{667, 500}},
+	}
+
+	for _, c := range cases {
+		resize_w, resize_h, final_w, final_h := c.config.targetSize(4000, 3000)
+		if [2]int{resize_w, resize_h} != c.resize || [2]int{final_w, final_h} != c.final {
+			t.Fatalf("Config %+v: expected %v -> %v, got %dx%d -> %dx%d", c.config, c.resize, c.final, resize_w, resize_h, final_w, final_h)
+		}
+	}
+
+	// Modes require both dimensions.
+	if err := (ResizeConfig{Mode: ResizeModeCover, Width: 100}).check(); !errors.Is(err, ErrInvalidResizeTarget) {
+		t.Fatalf("Expected missing height to be rejected, got %v", err)
+	}
+	if err := (ResizeConfig{Mode: "fill", Width: 100, Height: 100}).check(); !errors.Is(err, ErrInvalidResizeMode) {
+		t.Fatalf("Expected unknown mode to be rejected, got %v", err)
+	}
+}
+
+func TestPadTargetSize(t *testing.T) {
+
+	cases := []struct {
+		config PadConfig
+		size   [2]int
+	}{
+		{PadConfig{Aspect: "1:1"}, [2]int{4000, 4000}},
+		{PadConfig{Aspect: "4:5"}, [2]int{4000, 5000}},
+		{PadConfig{Aspect: "16:9"}, [2]int{5333, 3000}},
+		{PadConfig{Width: 5000, Height: 2000}, [2]int{5000, 3000}}, // Never shrinks below image.
+	}
+
+	for _, c := range cases {
+		w, h := c.config.targetSize(4000, 3000)
+		if [2]int{w, h} != c.size {
+			t.Fatalf("Config %+v: expected %v, got %dx%d", c.config, c.size, w, h)
+		}
+	}
+
+	for _, aspect := range []string{"16:", "a:b", "0:1", "-1.5"} {
+		if _, err := parseAspect(aspect); !errors.Is(err, ErrInvalidAspect) {
+			t.Fatalf("Expected aspect '%s' to be rejected, got %v", aspect, err)
+		}
+	}
+
+	if err := (PadConfig{Aspect: "1:1", Alignment: "middle"}).check(); !errors.Is(err, ErrInvalidAlignment) {
+		t.Fatalf("Expected unknown alignment to be rejected, got %v", err)
+	}
+}
+
+func TestCropRect(t *testing.T) {
+
+	cases := []struct {
+		config CropConfig
+		rect   image.Rectangle
+	}{
+		{CropConfig{Width: 1000, Height: 1000, Alignment: "bottomright"}, image.Rect(3000, 2000, 4000, 3000)},
+		{CropConfig{WidthLength: "50%", HeightLength: "50%", Alignment: "topright"}, image.Rect(2000, 0, 4000, 1500)},
+		{CropConfig{Aspect: "1:1"}, image.Rect(500, 0, 3500, 3000)},
+		{CropConfig{Aspect: "16:9", Alignment: "top"}, image.Rect(0, 0, 4000, 2250)},
+		{CropConfig{Width: 2000, Aspect: "2:1", Alignment: "left"}, image.Rect(0, 1000, 2000, 2000)},
+		{CropConfig{X: "100", Y: "200", Width: 300, Height: 400}, image.Rect(100, 200, 400, 600)},
+		{CropConfig{Width: 1000, Height: 1000, OffsetX: "-100", OffsetY: "10%"}, image.Rect(1400, 1300, 2400, 2300)},
+		{CropConfig{Width: 1000, Height: 1000, Alignment: "right", OffsetX: "500"}, image.Rect(3000, 1000, 4000, 2000)}, // Clamped.
+		{CropConfig{Trim: &CropMargins{Top: "10%", Bottom: "10%", Left: "100", Right: "5%"}}, image.Rect(100, 300, 3800, 2700)},
+		{CropConfig{Trim: &CropMargins{Left: "60%", Right: "50%"}}, image.Rectangle{}}, // Nothing left.
+	}
+
+	for _, c := range cases {
+		if err := c.config.check(); err != nil {
+			t.Fatalf("Config %+v: unexpected error %v", c.config, err)
+		}
+		if rect := c.config.cropRect(4000, 3000); rect != c.rect {
+			t.Fatalf("Config %+v: expected %v, got %v", c.config, c.rect, rect)
+		}
+	}
+
+	invalid := []CropConfig{
+		{},
+		{Width: -10, Height: 10},
+		{WidthLength: "-10"},
+		{WidthLength: "abc"},
+		{Aspect: "16:"},
+		{Width: 10, Alignment: "middle"},
+	}
+	for _, config := range invalid {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected config %+v to be rejected", config)
+		}
+	}
+
+	// Pixel sizes are read into both forms, percentages only into lengths.
+	var parsed CropConfig
+	if err := yaml.Unmarshal([]byte("width: 50%\nheight: 60"), &parsed); err != nil {
+		t.Fatalf("Failed to parse crop config: %v", err)
+	}
+	if parsed.Width != 0 || parsed.WidthLength != "50%" || parsed.Height != 60 || parsed.HeightLength != "60" {
+		t.Fatalf("Expected percentage width and pixel height, got %+v", parsed)
+	}
+	raw, _ := yaml.Marshal(CropConfig{Width: 50, HeightLength: "10%"})
+	if !bytes.Contains(raw, []byte("width: 50\n")) || !bytes.Contains(raw, []byte("height: 10%\n")) {
+		t.Fatalf("Expected sizes to be serialized, got %s", raw)
+	}
+}
+
+func TestCheckPipelineBlock(t *testing.T) {
+
+	valid := []PipelineBlock{
+		{Operation: OperationEncode, Encode: &EncodeConfig{Format: "JPG"}},
+		{Operation: OperationEncode, Encode: &EncodeConfig{Format: "jpeg", Options: &OutputOptionConfig{}}},
+		{Operation: OperationEncode, Encode: &EncodeConfig{Format: "png", Options: &OutputOptionConfig{Quality: 100}}},
+		{Operation: OperationWrite, Write: &OutputConfig{}},
+		{Operation: OperationIccEmbed, ICCEmbedProfile: &IccEmbedConfig{ProfileName: "Display P3"}},
+	}
+	for _, pb := range valid {
+		if err := checkPipelineBlock(pb); err != nil {
+			t.Fatalf("Expected %s block to be valid, got %v", pb.Operation, err)
+		}
+	}
+
+	invalid := map[error]PipelineBlock{
+		ErrInvalidEncodeFormat: {Operation: OperationEncode, Encode: &EncodeConfig{Format: "webp"}},
+		ErrInvalidQuality:      {Operation: OperationEncode, Encode: &EncodeConfig{Format: "jpeg", Options: &OutputOptionConfig{Quality: 101}}},
+		ErrUnknownIccName:      {Operation: OperationIccEmbed, ICCEmbedProfile: &IccEmbedConfig{ProfileName: "srbg"}},
+	}
+	for expected, pb := range invalid {
+		if err := checkPipelineBlock(pb); !errors.Is(err, expected) {
+			t.Fatalf("Expected %v, got %v", expected, err)
+		}
+	}
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationWrite, Write: &OutputConfig{Format: "bmp"}}); !errors.Is(err, ErrInvalidEncodeFormat) {
+		t.Fatalf("Expected unsupported write format to be rejected, got %v", err)
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.png")
+	data, err := os.ReadFile("../test_resources/test_ayaya.png")
+	if err == nil {
+		err = os.WriteFile(input, data, 0644)
+	}
+	if err != nil {
+		t.Fatalf("Failed to copy test image: %v", err)
+	}
+
+	// Profile is embedded by encoding, even if `icc_embed` comes first.
+	root := ProfileRoot{Profiles: []ImageProcessingProfile{{PipelineBlocks: []PipelineBlock{
+		{Operation: OperationDecode},
+		{Operation: OperationCrop, Crop: &CropConfig{Width: 20, Height: 14, Alignment: "bottomright"}},
+		{Operation: OperationResize, Resize: &ResizeConfig{Width: 10}},
+		{Operation: OperationIccEmbed, ICCEmbedProfile: &IccEmbedConfig{ProfileName: "Adobe RGB"}},
+		{Operation: OperationEncode, Encode: &EncodeConfig{Format: "jpeg", Options: &OutputOptionConfig{Quality: 90}}},
+		{Operation: OperationWrite, Write: &OutputConfig{Format: "jpeg", NameSuffix: "_out"}},
+	}}}}
+	root.AssignInputFile(input)
+
+	working_image, err := root.Profiles[0].CreateImageFile()
+	if err != nil {
+		t.Fatalf("Failed to read input: %v", err)
+	}
+	for _, pb := range root.Profiles[0].PipelineBlocks {
+		working_image = working_image.Then(PipelineBlockToOperation(pb))
+	}
+	if err := working_image.LastError(); err != nil {
+		t.Fatalf("Unexpected pipeline error: %v", err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(dir, "input_out.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	header, format, err := image.DecodeConfig(bytes.NewReader(output))
+	if err != nil || format != "jpeg" || header.Width != 10 || header.Height != 7 {
+		t.Fatalf("Unexpected output %s %+v (%v)", format, header, err)
+	}
+	if !bytes.Contains(output, []byte("ICC_PROFILE")) || !bytes.Contains(output, []byte("Adobe RGB (1998)")) {
+		t.Fatalf("Expected Adobe RGB profile in output")
+	}
+
+	// Quality left out is the encoder default, not the lowest quality.
+	decoded := ProcessingImage{data: data}.Then(decodeImage())
+	omitted := decoded.Then(encodeImage("jpeg", &OutputOptionConfig{}))
+	if !bytes.Equal(omitted.data, decoded.Then(encodeImage("jpeg", nil)).data) {
+		t.Fatalf("Expected omitted quality to use encoder default")
+	}
+
+	// Errors stop the pipeline.
+	failed := working_image.Then(encodeImage("bmp", nil))
+	if !errors.Is(failed.LastError(), ErrInvalidEncodeFormat) || failed.Then(decodeImage()).LastError() != failed.LastError() {
+		t.Fatalf("Expected unsupported format to stop pipeline, got %v", failed.LastError())
+	}
+	if err := (ProcessingImage{}).Then(encodeImage("png", nil)).LastError(); err != filter.ErrNoImage {
+		t.Fatalf("Expected encoding without decode block to fail, got %v", err)
+	}
+}
+
+func TestAutoOrientOnce(t *testing.T) {
+
+	// 4x2 JPEG with EXIF orientation 6, upright image is 2x4.
+	encoded := &bytes.Buffer{}
+	jpeg.Encode(encoded, image.NewNRGBA(image.Rect(0, 0, 4, 2)), nil)
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("MM\x00\x2a")
+	for _, v := range []any{uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), uint16(6), uint16(0), uint32(0)} {
+		binary.Write(tiff, binary.BigEndian, v)
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	data := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	data = append(data, encoded.Bytes()[2:]...)
+
+	input := filepath.Join(t.TempDir(), "input.jpg")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatalf("Failed to write test image: %v", err)
+	}
+
+	// Orientation is applied once, then again after input is decoded a second time.
+	blocks := []PipelineBlock{
+		{Operation: OperationDecode, Decode: &DecodeConfig{AutoOrient: true}},
+		{Operation: OperationAutoOrient},
+		{Operation: OperationDecode, Decode: &DecodeConfig{AutoOrient: true}},
+		{Operation: OperationAutoOrient},
+	}
+	root := ProfileRoot{Profiles: []ImageProcessingProfile{{PipelineBlocks: blocks}}}
+	root.AssignInputFile(input)
+
+	working_image, err := root.Profiles[0].CreateImageFile()
+	if err != nil {
+		t.Fatalf("Failed to read input: %v", err)
+	}
+	for index, pb := range root.Profiles[0].PipelineBlocks {
+		for _, operation := range PipelineBlockToOperations(pb) {
+			working_image = working_image.Then(operation)
+		}
+		if err := working_image.LastError(); err != nil {
+			t.Fatalf("Unexpected pipeline error: %v", err)
+		}
+		if size := working_image.img.Bounds().Size(); size != image.Pt(2, 4) {
+			t.Fatalf("Expected 2x4 image after block %d, got %v", index, size)
+		}
+	}
+
+	steps, err := root.Profiles[0].Plan(metadata.Header{Format: "jpeg", Width: 4, Height: 2, Orientation: 6})
+	if err != nil {
+		t.Fatalf("Failed to plan profile: %v", err)
+	}
+	for index, step := range steps {
+		if step.Width != 2 || step.Height != 4 {
+			t.Fatalf("Expected 2x4 plan after block %d, got %dx%d", index, step.Width, step.Height)
+		}
+	}
+}
+
+func TestSmartCropFocus(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "image.png")
+
+	// No sidecar file.
+	if focus, err := readFocusSidecar(input); focus != nil || err != nil {
+		t.Fatalf("Expected no focus without sidecar, got %v, %v", focus, err)
+	}
+
+	err := os.WriteFile(filepath.Join(dir, "image"+focusSidecarSuffix), []byte("x: \"75%\"\ny: 100\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write sidecar: %v", err)
+	}
+	focus, err := readFocusSidecar(input)
+	if err != nil || focus == nil {
+		t.Fatalf("Failed to read sidecar: %v", err)
+	}
+	if p := focus.point(4000, 3000); p != image.Pt(3000, 100) {
+		t.Fatalf("Expected focus at (3000, 100), got %v", p)
+	}
+
+	config := CropConfig{Aspect: "1:1", Alignment: "smart", Focus: &CropFocus{X: "10%"}}
+	if err := config.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rect := config.cropRect(4000, 3000); rect != image.Rect(500, 0, 3500, 3000) {
+		t.Fatalf("Expected smart crop to be planned at center, got %v", rect)
+	}
+}
+
+func TestWatermarkPositions(t *testing.T) {
+
+	config := WatermarkConfig{Alignment: "bottomright", OffsetX: "-1%", OffsetY: "-10", Scale: 0.25}
+	size := config.markSize(1000, image.Pt(400, 100))
+	if size != image.Pt(250, 63) {
+		t.Fatalf("Expected 250x63 watermark, got %v", size)
+	}
+	if p := config.positions(1000, 500, size); len(p) != 1 || p[0] != image.Pt(740, 427) {
+		t.Fatalf("Expected watermark at (740, 427), got %v", p)
+	}
+
+	// Tiles cover the whole image through aligned position.
+	config = WatermarkConfig{Alignment: "center", Tile: true, Spacing: "20"}
+	positions := config.positions(100, 100, image.Pt(20, 20))
+	if len(positions) != 9 || positions[0] != image.Pt(0, 0) || positions[8] != image.Pt(80, 80) {
+		t.Fatalf("Expected 3x3 tiles from origin, got %v", positions)
+	}
+
+	// Pixel blocks must come before encode.
+	err := checkPipelineBlockList([]PipelineBlock{
+		{Operation: OperationDecode},
+		{Operation: OperationEncode, Encode: &EncodeConfig{Format: "png"}},
+		{Operation: OperationFlip, Flip: &FlipConfig{Direction: "both"}},
+	})
+	if !errors.Is(err, ErrPixelBlockAfterEncode) {
+		t.Fatalf("Expected block after encode to be rejected, got %v", err)
+	}
+}
+
+func TestSharedValues(t *testing.T) {
+
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return loads, nil
+	}
+
+	// Blocks created in code load on every use.
+	var unshared *sharedValues[int]
+	unshared.get("", load)
+	unshared.get("", load)
+	if loads != 2 {
+		t.Fatalf("Expected nil cache to load every time, got %d loads", loads)
+	}
+
+	shared := newSharedValues[int]()
+	for i := 0; i < 3; i++ {
+		if value, _ := shared.get("a", load); value != 3 {
+			t.Fatalf("Expected value to be loaded once, got %d", value)
+		}
+	}
+	if value, _ := shared.get("b", load); value != 4 {
+		t.Fatalf("Expected other key to be loaded, got %d", value)
+	}
+
+	// Watermark of loaded config is decoded once for all jobs.
+	config, err := LoadConfigFromFile("test_resources/test_full_conf.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var wc *WatermarkConfig
+	for _, pb := range config.Profiles[1].PipelineBlocks {
+		if pb.Watermark != nil {
+			wc = pb.Watermark
+		}
+	}
+	if wc == nil || wc.mark == nil {
+		t.Fatalf("Expected watermark block to share decoded watermark")
+	}
+	for i := 0; i < 2; i++ {
+		pi := ProcessingImage{img: image.NewNRGBA(image.Rect(0, 0, 40+i, 20))}.Then(watermarkOperation(*wc))
+		if pi.LastError() != nil {
+			t.Fatalf("Unexpected error of watermark: %v", pi.LastError())
+		}
+	}
+	if len(wc.mark.values) != 1 {
+		t.Fatalf("Expected one shared watermark, got %d", len(wc.mark.values))
+	}
+}
+
+func TestTextTemplate(t *testing.T) {
+
+	config := TextConfig{Text: "{name} ({filename}) {copyright}"}
+	if err := config.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text, err := config.resolveText("../test_resources/test_ayaya.png")
+	if err != nil {
+		t.Fatalf("Failed to resolve text: %v", err)
+	}
+	if text != "test_ayaya (test_ayaya.png) " { // No EXIF copyright.
+		t.Fatalf("Unexpected text '%s'", text)
+	}
+
+	invalid := []TextConfig{
+		{},
+		{Text: "WIP", Font: "missing.ttf"},
+		{Text: "WIP", Color: "#12"},
+		{Text: "WIP", Size: "-5%"},
+	}
+	for _, config := range invalid {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected config %+v to be rejected", config)
+		}
+	}
+
+	// Font file is read once per block and is part of the profile hash.
+	font := filepath.Join(t.TempDir(), "font.ttf")
+	if err := os.WriteFile(font, goregular.TTF, 0644); err != nil {
+		t.Fatalf("Failed to write font: %v", err)
+	}
+	root := ProfileRoot{Profiles: []ImageProcessingProfile{{PipelineBlocks: []PipelineBlock{
+		{Operation: OperationText, Text: &TextConfig{Text: "WIP", Font: font, Alignment: "center"}},
+	}}}}
+	root.shareFiles()
+	if files := root.Profiles[0].ReferencedFiles(); len(files) != 1 || files[0] != font {
+		t.Fatalf("Expected font in referenced files, got %v", files)
+	}
+	tc := root.Profiles[0].PipelineBlocks[0].Text
+	for i := 0; i < 2; i++ {
+		pi := ProcessingImage{img: image.NewNRGBA(image.Rect(0, 0, 40, 20))}.Then(textOperation(*tc, "image.png"))
+		if pi.LastError() != nil {
+			t.Fatalf("Unexpected error of text: %v", pi.LastError())
+		}
+		os.Remove(font) // Later jobs use the loaded font.
+	}
+	if len(tc.font.values) != 1 {
+		t.Fatalf("Expected one shared font, got %d", len(tc.font.values))
+	}
+}
+
+func TestColorBlocks(t *testing.T) {
+
+	// Lift shadows of all channels, white points default to 255.
+	levels := LevelsConfig{OutputBlack: 16}
+	if err := levels.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if in_white, out_white := levels.whitePoints(); in_white != 255 || out_white != 255 {
+		t.Fatalf("Expected default white points of 255, got %d and %d", in_white, out_white)
+	}
+
+	for _, config := range []LevelsConfig{{InputBlack: 200, InputWhite: 100}, {OutputBlack: 300}, {Channel: "alpha"}} {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected levels %+v to be rejected", config)
+		}
+	}
+
+	// Master curve is applied before channel curves.
+	curves, err := CurvesConfig{RGB: [][2]float64{{0, 0}, {255, 128}}, Red: [][2]float64{{0, 255}, {255, 0}}}.curves()
+	if err != nil {
+		t.Fatalf("Failed to build curves: %v", err)
+	}
+	if curves[0][255] != 127 || curves[1][255] != 128 {
+		t.Fatalf("Expected master curve before red curve, got %d and %d", curves[0][255], curves[1][255])
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationCurves, Curves: &CurvesConfig{Blue: [][2]float64{{10, 10}}}})
+	if err == nil {
+		t.Fatalf("Expected single point curve to be rejected")
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationAdjust, Adjust: &AdjustConfig{Saturation: -2}})
+	if !errors.Is(err, ErrInvalidAdjustValue) {
+		t.Fatalf("Expected invalid adjust error, got %v", err)
+	}
+}
+
+func TestLUTBlock(t *testing.T) {
+
+	lut := LUTConfig{File: "../test_resources/test_warm.cube"}
+	if err := lut.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	strength := 1.5
+	err := LUTConfig{File: lut.File, Strength: &strength}.check()
+	if !errors.Is(err, ErrInvalidLUTValue) {
+		t.Fatalf("Expected invalid strength error, got %v", err)
+	}
+
+	err = LUTConfig{File: lut.File, Interpolation: "nearest"}.check()
+	if !errors.Is(err, ErrInvalidLUTInterpolation) {
+		t.Fatalf("Expected invalid interpolation error, got %v", err)
+	}
+
+	// Watermark image is no Hald image.
+	err = LUTConfig{File: "../test_resources/test_ayaya.png"}.check()
+	if !errors.Is(err, filter.ErrInvalidLUT) {
+		t.Fatalf("Expected invalid LUT error, got %v", err)
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationLUT})
+	if !errors.Is(err, ErrInvalidLUTBlock) {
+		t.Fatalf("Expected missing LUT config error, got %v", err)
+	}
+
+	// Shared LUT is parsed while checking, and used by every operation.
+	lut.lut = newSharedValues[*filter.LUT3D]()
+	if err := lut.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 2; i++ {
+		if pi := (ProcessingImage{img: img}).Then(lutOperation(lut)); pi.LastError() != nil {
+			t.Fatalf("Unexpected error of LUT: %v", pi.LastError())
+		}
+	}
+	if len(lut.lut.values) != 1 {
+		t.Fatalf("Expected one shared LUT, got %d", len(lut.lut.values))
+	}
+
+	// Missing file fails the pipeline.
+	if pi := (ProcessingImage{img: img}).Then(lutOperation(LUTConfig{File: "missing.cube"})); pi.LastError() == nil {
+		t.Fatalf("Expected missing LUT file to fail")
+	}
+}
+
+func TestIccConvertBlock(t *testing.T) {
+
+	convert := IccConvertConfig{Target: "Display P3", Intent: "relative", BlackPointCompensation: true}
+	if err := convert.check(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Image without embedded profile falls back to configured source.
+	job := &JobMetadata{}
+	source, err := IccConvertConfig{Source: "Adobe RGB"}.sourceProfile(job)
+	if err != nil || source.Description != "Adobe RGB (1998)" {
+		t.Fatalf("Expected assumed Adobe RGB source, got %v (%v)", source, err)
+	}
+
+	// Converted image is described by target profile.
+	target, _ := icc.Load("Display P3")
+	job.converted("Display P3", target)
+	source, err = IccConvertConfig{Source: "Adobe RGB"}.sourceProfile(job)
+	if err != nil || source.Description != "Display P3" {
+		t.Fatalf("Expected Display P3 source after conversion, got %v (%v)", source, err)
+	}
+
+	// Jobs with the same source profile share the transform.
+	convert.profiles, convert.transforms = newSharedValues[*icc.Profile](), newSharedValues[*icc.Transform]()
+	adobe, _ := icc.Named("Adobe RGB")
+	for _, job := range []*JobMetadata{{}, {}, {ICC: adobe.Bytes()}} {
+		pi := ProcessingImage{img: image.NewNRGBA(image.Rect(0, 0, 2, 2))}.Then(iccConvertOperation(convert, job))
+		if pi.LastError() != nil || job.ICCName != "Display P3" {
+			t.Fatalf("Unexpected result of ICC conversion: %v (%+v)", pi.LastError(), job)
+		}
+	}
+	if len(convert.transforms.values) != 2 || len(convert.profiles.values) != 2 {
+		t.Fatalf("Expected transforms of two sources, got %d transforms of %d profiles", len(convert.transforms.values), len(convert.profiles.values))
+	}
+
+	for _, config := range []IccConvertConfig{{}, {Target: "sRGB", Intent: "vivid"}, {Target: "missing.icc"}, {Target: "sRGB", Source: "missing.icc"}} {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected ICC conversion %+v to be rejected", config)
+		}
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationIccConvert})
+	if !errors.Is(err, ErrInvalidIccConvertBlock) {
+		t.Fatalf("Expected missing ICC conversion config error, got %v", err)
+	}
+}
+
+func TestIccEmbedBlock(t *testing.T) {
+
+	for _, config := range []IccEmbedConfig{{ProfileName: "sRGB"}, {ProfileName: IccNameSource}, {File: "../test_resources/test_srgb.icc"}} {
+		if err := config.check(); err != nil {
+			t.Fatalf("Unexpected error of %+v: %v", config, err)
+		}
+	}
+
+	for _, config := range []IccEmbedConfig{{}, {ProfileName: "srbg"}, {ProfileName: "sRGB", File: "../test_resources/test_srgb.icc"}, {File: "../test_resources/test_ayaya.png"}} {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected ICC embedding %+v to be rejected", config)
+		}
+	}
+}
+
+func TestJobMetadata(t *testing.T) {
+
+	profile, err := os.ReadFile("../test_resources/test_srgb.icc")
+	if err != nil {
+		t.Fatalf("Failed to read profile: %v", err)
+	}
+	encoded := &bytes.Buffer{}
+	png.Encode(encoded, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	data, err := metadata.EmbedICCProfile(encoded.Bytes(), profile)
+	if err != nil {
+		t.Fatalf("Failed to embed profile: %v", err)
+	}
+
+	job := &JobMetadata{ICCName: "sRGB"}
+	if err := job.readSource(data); err != nil || !bytes.Equal(job.SourceICC, profile) || !bytes.Equal(job.ICC, profile) || job.ICCName != "" {
+		t.Fatalf("Expected embedded profile in job metadata, got %+v (%v)", job, err)
+	}
+
+	// Every job gets its own metadata, shared by its blocks.
+	root := ProfileRoot{Profiles: []ImageProcessingProfile{{PipelineBlocks: []PipelineBlock{{Operation: OperationDecode}, {Operation: OperationEncode}}}}}
+	root.AssignInputFile("a.png")
+	first := root.Profiles[0].PipelineBlocks[0].job
+	if first == nil || first != root.Profiles[0].PipelineBlocks[1].job {
+		t.Fatalf("Expected blocks to share job metadata")
+	}
+	root.AssignInputFile("b.png")
+	if root.Profiles[0].PipelineBlocks[0].job == first {
+		t.Fatalf("Expected fresh job metadata for next input")
+	}
+}
+
+func TestChannelBlocks(t *testing.T) {
+
+	// Grayscale, sepia and invert blocks need no configuration.
+	for _, operation := range []string{OperationGrayscale, OperationSepia, OperationInvert} {
+		if err := checkPipelineBlock(PipelineBlock{Operation: operation}); err != nil {
+			t.Fatalf("Unexpected error of %s block: %v", operation, err)
+		}
+	}
+	if strength := (*SepiaConfig)(nil).strength(); strength != 1 {
+		t.Fatalf("Expected full sepia strength by default, got %v", strength)
+	}
+
+	err := checkPipelineBlock(PipelineBlock{Operation: OperationGrayscale, Grayscale: &GrayscaleConfig{Weights: "average"}})
+	if !errors.Is(err, ErrInvalidGrayWeights) {
+		t.Fatalf("Expected invalid weights error, got %v", err)
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationDuotone, Duotone: &DuotoneConfig{Colors: []string{"#000000"}}})
+	if !errors.Is(err, ErrInvalidDuotoneColors) {
+		t.Fatalf("Expected invalid duotone colors error, got %v", err)
+	}
+
+	// Extraction fills color channels, alpha becomes opaque mask.
+	if sources := (ChannelsConfig{Extract: "alpha"}).sources(); sources != [4]string{"alpha", "alpha", "alpha", "white"} {
+		t.Fatalf("Unexpected channel sources %v", sources)
+	}
+	for _, config := range []ChannelsConfig{{Extract: "green", Red: "blue"}, {Extract: "black"}, {Red: "cyan"}} {
+		if err := config.check(); err == nil {
+			t.Fatalf("Expected channels %+v to be rejected", config)
+		}
+	}
+}
+
+func TestBlurBlocks(t *testing.T) {
+
+	// Sharpen block needs no configuration.
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationSharpen}); err != nil {
+		t.Fatalf("Unexpected error of sharpen block: %v", err)
+	}
+	err := checkPipelineBlock(PipelineBlock{Operation: OperationSharpen, Sharpen: &SharpenConfig{Threshold: 300}})
+	if !errors.Is(err, ErrInvalidSharpenValue) {
+		t.Fatalf("Expected invalid sharpen value error, got %v", err)
+	}
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationBlur}); !errors.Is(err, ErrInvalidBlurBlock) {
+		t.Fatalf("Expected invalid blur block error, got %v", err)
+	}
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationBlur, Blur: &BlurConfig{Type: "motion", Radius: 2}})
+	if !errors.Is(err, ErrInvalidBlurType) {
+		t.Fatalf("Expected invalid blur type error, got %v", err)
+	}
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationBlur, Blur: &BlurConfig{Type: "box"}})
+	if !errors.Is(err, ErrInvalidBlurRadius) {
+		t.Fatalf("Expected invalid blur radius error, got %v", err)
+	}
+}
+
+func TestConvolveBlock(t *testing.T) {
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationConvolve}); !errors.Is(err, ErrInvalidConvolveBlock) {
+		t.Fatalf("Expected invalid convolve block error, got %v", err)
+	}
+
+	emboss := ConvolveConfig{Kernel: [][]float64{{-2, -1, 0}, {-1, 1, 1}, {0, 1, 2}}, Edge: "mirror"}
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationConvolve, Convolve: &emboss}); err != nil {
+		t.Fatalf("Unexpected error of convolve block: %v", err)
+	}
+
+	for _, kernel := range [][][]float64{{}, {{1, 1}}, {{1, 1, 1}, {1, 1}, {1, 1, 1}}} {
+		if err := (ConvolveConfig{Kernel: kernel}).check(); !errors.Is(err, ErrInvalidKernel) {
+			t.Fatalf("Expected kernel %v to be rejected, got %v", kernel, err)
+		}
+	}
+	if err := (ConvolveConfig{Kernel: [][]float64{{1}}, Edge: "repeat"}).check(); !errors.Is(err, ErrInvalidEdgeMode) {
+		t.Fatalf("Expected invalid edge mode error, got %v", err)
+	}
+}
+
+func TestAlphaBlocks(t *testing.T) {
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationFlatten}); err != nil {
+		t.Fatalf("Unexpected error of flatten block: %v", err)
+	}
+	err := checkPipelineBlock(PipelineBlock{Operation: OperationFlatten, Flatten: &FlattenConfig{Checkerboard: -1}})
+	if !errors.Is(err, ErrInvalidCheckerboard) {
+		t.Fatalf("Expected invalid checkerboard error, got %v", err)
+	}
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationAlpha}); !errors.Is(err, ErrInvalidAlphaBlock) {
+		t.Fatalf("Expected invalid alpha block error, got %v", err)
+	}
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationAlpha, Alpha: &AlphaConfig{Mode: "erase"}})
+	if !errors.Is(err, ErrInvalidAlphaMode) {
+		t.Fatalf("Expected invalid alpha mode error, got %v", err)
+	}
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationAlpha, Alpha: &AlphaConfig{Mode: "color_key", Tolerance: 120}})
+	if !errors.Is(err, ErrInvalidAlphaValue) {
+		t.Fatalf("Expected invalid alpha value error, got %v", err)
+	}
+
+	err = checkPipelineBlock(PipelineBlock{Operation: OperationEncode, Encode: &EncodeConfig{Format: "jpeg", Background: "blue"}})
+	if !errors.Is(err, filter.ErrInvalidColor) {
+		t.Fatalf("Expected invalid background error, got %v", err)
+	}
+
+	// Formats without alpha channel are flattened before encoding.
+	keep := false
+	jpeg := PipelineBlockToOperations(PipelineBlock{Operation: OperationEncode, Encode: &EncodeConfig{Format: "jpeg", KeepICC: &keep}})
+	png := PipelineBlockToOperations(PipelineBlock{Operation: OperationEncode, Encode: &EncodeConfig{Format: "png", KeepICC: &keep}})
+	if len(jpeg) != 2 || len(png) != 1 {
+		t.Fatalf("Expected flatten before jpeg encoding only, got %d and %d operations", len(jpeg), len(png))
+	}
+}
+
+func TestBorderBlocks(t *testing.T) {
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationBorder, Border: &BorderConfig{Color: "red"}}); !errors.Is(err, ErrInvalidBorderWidth) {
+		t.Fatalf("Expected invalid border width error, got %v", err)
+	}
+	if err := (BorderConfig{Width: "-2"}).check(); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("Expected invalid length error, got %v", err)
+	}
+
+	// Sides override width, percentages are relative to the shorter side.
+	border := PipelineBlock{Operation: OperationBorder, Border: &BorderConfig{Width: "2%", Bottom: "60", Padding: "10"}}
+	if err := checkPipelineBlock(border); err != nil {
+		t.Fatalf("Unexpected error of border block: %v", err)
+	}
+	if w, h := OutputDimensions(border, 2000, 1000); w != 2000+20+20+20 || h != 1000+20+60+20 {
+		t.Fatalf("Unexpected size with border %dx%d", w, h)
+	}
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationRoundCorners}); !errors.Is(err, ErrInvalidRoundCornersBlock) {
+		t.Fatalf("Expected invalid round corners block error, got %v", err)
+	}
+	if err := (RoundCornersConfig{Radius: "0%"}).check(); !errors.Is(err, ErrInvalidCornerRadius) {
+		t.Fatalf("Expected invalid corner radius error, got %v", err)
+	}
+	if err := (RoundCornersConfig{Radius: "5%", Background: "#fff"}).check(); err != nil {
+		t.Fatalf("Unexpected error of round corners config: %v", err)
+	}
+}
+
+func TestQuantizeBlock(t *testing.T) {
+
+	if err := checkPipelineBlock(PipelineBlock{Operation: OperationQuantize}); !errors.Is(err, ErrInvalidQuantizeBlock) {
+		t.Fatalf("Expected invalid quantize block error, got %v", err)
+	}
+	for config, expected := range map[QuantizeConfig]error{
+		{Colors: 300}:                    ErrInvalidPaletteSize,
+		{Colors: 16, Method: "popular"}:  ErrInvalidQuantizeMethod,
+		{Colors: 16, Dither: "atkinson"}: ErrInvalidDitherMethod,
+		{Colors: 16, Method: "octree"}:   nil,
+		{Colors: 2, Dither: "ordered"}:   nil,
+	} {
+		if err := config.check(); !errors.Is(err, expected) {
+			t.Fatalf("Expected %v for %+v, got %v", expected, config, err)
+		}
+	}
+
+	// Quantized image is written as paletted PNG, later pixel blocks make it true color again.
+	quantize := PipelineBlock{Operation: OperationQuantize, Quantize: &QuantizeConfig{Colors: 4}}
+	blur := PipelineBlock{Operation: OperationBlur, Blur: &BlurConfig{Radius: 1}}
+	encode := PipelineBlock{Operation: OperationEncode, Encode: &EncodeConfig{Format: "png"}}
+	for _, c := range []struct {
+		blocks   []PipelineBlock
+		paletted bool
+	}{{[]PipelineBlock{quantize, encode}, true}, {[]PipelineBlock{quantize, blur, encode}, false}} {
+		pi := ProcessingImage{img: image.NewNRGBA(image.Rect(0, 0, 8, 8))}
+		for _, pb := range c.blocks {
+			for _, operation := range PipelineBlockToOperations(pb) {
+				pi = pi.Then(operation)
+			}
+		}
+		if pi.LastError() != nil {
+			t.Fatalf("Unexpected error: %v", pi.LastError())
+		}
+		decoded, err := png.Decode(bytes.NewReader(pi.data))
+		if _, paletted := decoded.(*image.Paletted); err != nil || paletted != c.paletted {
+			t.Fatalf("Expected paletted output to be %v, got %T (%v)", c.paletted, decoded, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"imagetools/filter"
+	"imagetools/metadata"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default text style.
+const (
+	defaultTextSize        = "5%"        // Font size relative to image height.
+	defaultTextColor       = "#ffffff"   // Text color.
+	defaultTextStrokeColor = "#000000"   // Outline color.
+	defaultTextShadowColor = "#00000080" // Shadow color.
+)
+
+var (
+	ErrInvalidTextBlock = errors.New("text block requires text")
+	ErrInvalidTextFont  = errors.New("text font file does not exist")
+)
+
+// Check the integrity of text configuration.
+func (tc TextConfig) check() error {
+
+	if tc.Text == "" {
+		return ErrInvalidTextBlock
+	}
+
+	if tc.Font != "" {
+		if _, err := os.Stat(tc.Font); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTextFont, err)
+		}
+	}
+
+	for _, length := range []Length{tc.Size, tc.StrokeWidth, tc.ShadowOffset, tc.Padding} {
+		err := length.check(false)
+		if err != nil {
+			return err
+		}
+	}
+	for _, length := range []Length{tc.OffsetX, tc.OffsetY} {
+		err := length.check(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range []string{tc.Color, tc.StrokeColor, tc.ShadowColor, tc.Background} {
+		_, err := filter.ParseColor(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, _, ok := filter.AnchorPosition(tc.Alignment); !ok {
+		return fmt.Errorf("%w: '%s'", ErrInvalidAlignment, tc.Alignment)
+	}
+
+	return nil
+}
+
+// Load font file, nil for bundled font.
+func (tc TextConfig) load() ([]byte, error) {
+	if tc.Font == "" {
+		return nil, nil
+	}
+	return tc.font.get("", func() ([]byte, error) { return os.ReadFile(tc.Font) })
+}
+
+// Fill placeholders of text template.
+//
+// input_file: Path to input image, source of file name and EXIF placeholders.
+func (tc TextConfig) resolveText(input_file string) (string, error) {
+
+	text := tc.Text
+
+	// EXIF is only read if needed.
+	if strings.Contains(text, "{artist}") || strings.Contains(text, "{copyright}") {
+		exif, err := metadata.ReadExif(input_file)
+		if err != nil {
+			return "", err
+		}
+		text = strings.ReplaceAll(text, "{artist}", exif.Artist)
+		text = strings.ReplaceAll(text, "{copyright}", exif.Copyright)
+	}
+
+	filename := filepath.Base(input_file)
+	replacer := strings.NewReplacer(
+		"{filename}", filename,
+		"{name}", strings.TrimSuffix(filename, filepath.Ext(filename)),
+		"{date}", time.Now().Format(time.DateOnly),
+	)
+
+	return replacer.Replace(text), nil
+}
+
+// Get value, or default value if omitted.
+func valueOrDefault(value string, default_value string) string {
+	if value == "" {
+		return default_value
+	}
+	return value
+}
+
+// Create text operation which sizes and places text by current image.
+//
+// input_file: Path to input image, used by placeholders.
+func textOperation(tc TextConfig, input_file string) Operation {
+
+	// Colors are checked while loading config.
+	text_color, _ := filter.ParseColor(valueOrDefault(tc.Color, defaultTextColor))
+	stroke_color, _ := filter.ParseColor(valueOrDefault(tc.StrokeColor, defaultTextStrokeColor))
+	shadow_color, _ := filter.ParseColor(valueOrDefault(tc.ShadowColor, defaultTextShadowColor))
+	background, _ := filter.ParseColor(tc.Background)
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+
+		text, err := tc.resolveText(input_file)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(text) == "" {
+			return img, nil // E.g. image without copyright, nothing to draw.
+		}
+
+		font_data, err := tc.load()
+		if err != nil {
+			return nil, err
+		}
+
+		// Font size is relative to image height, style lengths are relative to font size.
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		size := Length(valueOrDefault(string(tc.Size), defaultTextSize)).pixels(height)
+		face, err := filter.LoadFontFace(font_data, float64(max(1, size)))
+		if err != nil {
+			return nil, err
+		}
+		defer face.Close()
+
+		style := filter.TextStyle{
+			Color:       text_color,
+			StrokeWidth: tc.StrokeWidth.pixels(size),
+			StrokeColor: stroke_color,
+			Background:  background,
+			Padding:     tc.Padding.pixels(size),
+			FullWidth:   tc.FullWidth,
+		}
+		if shadow := tc.ShadowOffset.pixels(size); shadow != 0 {
+			style.ShadowOffset = image.Pt(shadow, shadow)
+			style.ShadowColor = shadow_color
+		}
+
+		offset := image.Pt(tc.OffsetX.pixels(width), tc.OffsetY.pixels(height))
+		return filter.DrawText(text, face, tc.Alignment, offset, style)(img)
+	})
+}
@@ -2,10 +2,16 @@ package config
 
 import (
 	"errors"
-	op "imagecore/operation"
+	"fmt"
+	"image"
+	"imagetools/filter"
+	"imagetools/icc"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 )
@@ -18,6 +24,31 @@ var (
 	ErrInvalidEncodeBlock       = errors.New("encode block provided but no additional configuration")
 	ErrInvalidWriteBlock        = errors.New("file output block provided but no additional configuration")
 	ErrInvalidIccBlock          = errors.New("icc embedding block provided but no additional configuration")
+	ErrInvalidQuality           = errors.New("jpeg quality must be between 1 and 100")
+	ErrInvalidRotateBlock       = errors.New("rotate block provided but no additional configuration")
+	ErrInvalidFlipBlock         = errors.New("flip block provided but no additional configuration")
+	ErrInvalidFlipDirection     = errors.New("unsupported flip direction")
+	ErrInvalidPadBlock          = errors.New("pad block provided but no additional configuration")
+	ErrInvalidAspect            = errors.New("malformed aspect ratio")
+	ErrInvalidLength            = errors.New("malformed length")
+	ErrInvalidTrimBlock         = errors.New("trim block provided but no additional configuration")
+	ErrInvalidTrimValue         = errors.New("trim tolerance must be within 0 to 100, and padding must not be negative")
+	ErrInvalidWatermarkBlock    = errors.New("watermark block provided but no additional configuration")
+	ErrPixelBlockAfterEncode    = errors.New("block works on decoded image and must come before encode")
+	ErrInvalidTextConfigBlock   = errors.New("text block provided but no additional configuration")
+	ErrInvalidAdjustBlock       = errors.New("adjust block provided but no additional configuration")
+	ErrInvalidLevelsBlock       = errors.New("levels block provided but no additional configuration")
+	ErrInvalidCurvesBlock       = errors.New("curves block provided but no additional configuration")
+	ErrInvalidLUTBlock          = errors.New("lut block provided but no additional configuration")
+	ErrInvalidIccConvertBlock   = errors.New("icc conversion block provided but no additional configuration")
+	ErrInvalidDuotoneBlock      = errors.New("duotone block provided but no additional configuration")
+	ErrInvalidChannelsBlock     = errors.New("channels block provided but no additional configuration")
+	ErrInvalidBlurBlock         = errors.New("blur block provided but no additional configuration")
+	ErrInvalidConvolveBlock     = errors.New("convolve block provided but no additional configuration")
+	ErrInvalidAlphaBlock        = errors.New("alpha block provided but no additional configuration")
+	ErrInvalidBorderBlock       = errors.New("border block provided but no additional configuration")
+	ErrInvalidRoundCornersBlock = errors.New("round corners block provided but no additional configuration")
+	ErrInvalidQuantizeBlock     = errors.New("quantize block provided but no additional configuration")
 )
 
 // Generate output file name.
@@ -45,6 +76,85 @@ func (ocf OutputConfig) GenerateFileName() string {
 	return filepath.Join(original_dir, full_file)
 }
 
+// Parse aspect ratio, either `W:H` (e.g. `16:9`) or a number (e.g. `1.5`).
+func parseAspect(aspect string) (float64, error) {
+
+	numerator, denominator, found := strings.Cut(aspect, ":")
+	if !found {
+		denominator = "1"
+	}
+
+	w, err_w := strconv.ParseFloat(strings.TrimSpace(numerator), 64)
+	h, err_h := strconv.ParseFloat(strings.TrimSpace(denominator), 64)
+	if err_w != nil || err_h != nil || w <= 0 || h <= 0 {
+		return 0, fmt.Errorf("%w: '%s'", ErrInvalidAspect, aspect)
+	}
+
+	return w / h, nil
+}
+
+// Parse length into value and whether it is a percentage.
+func (l Length) parse() (float64, bool, error) {
+
+	s := strings.TrimSpace(string(l))
+	if s == "" {
+		return 0, false, nil
+	}
+
+	number, percent := strings.CutSuffix(s, "%")
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: '%s'", ErrInvalidLength, l)
+	}
+
+	return value, percent, nil
+}
+
+// Check if length is valid.
+//
+// negative: Allow negative values, e.g. offsets.
+func (l Length) check(negative bool) error {
+	value, _, err := l.parse()
+	if err == nil && value < 0 && !negative {
+		err = fmt.Errorf("%w: '%s' must not be negative", ErrInvalidLength, l)
+	}
+	return err
+}
+
+// Resolve length to pixels.
+//
+// reference: Length percentages are relative to.
+func (l Length) pixels(reference int) int {
+	value, percent, _ := l.parse() // Checked while loading config.
+	if percent {
+		value = float64(reference) * value / 100
+	}
+	return int(math.Round(value))
+}
+
+// Check if length is set and not a percentage.
+func (l Length) isPixels() bool {
+	_, percent, err := l.parse()
+	return l != "" && !percent && err == nil
+}
+
+// Get length, or pixel count if length is not set.
+func lengthOrPixels(length Length, pixels int) Length {
+	if length == "" && pixels != 0 {
+		return Length(strconv.Itoa(pixels))
+	}
+	return length
+}
+
+// Serialize pixel lengths as numbers, so generated configs look like hand-written ones.
+func (l Length) MarshalYAML() (interface{}, error) {
+	value, percent, err := l.parse()
+	if err != nil || percent || value != math.Trunc(value) {
+		return string(l), nil
+	}
+	return int(value), nil
+}
+
 // Check the integrity of pipeline block.
 //
 // pb: Pipeline block to check.
@@ -54,30 +164,247 @@ func (ocf OutputConfig) GenerateFileName() string {
 // Returns error if pipeline block is invalid.
 func checkPipelineBlock(pb PipelineBlock) error {
 
+	// Check `when` clause syntax before running anything.
+	err := checkCondition(pb.When)
+	if err != nil {
+		return err
+	}
+
 	switch pb.Operation {
 	case OperationDecode: // Decode block.
 		// Decode operation does not require additional configuration.
 		break
+	case OperationAutoOrient: // EXIF orientation block.
+		// Orientation is read from input file, no additional configuration.
+		break
 	case OperationResize: // Resize block.
 		if pb.Resize == nil {
 			return ErrInvalidResizeBlock
 		}
+		err := pb.Resize.check()
+		if err != nil {
+			return err
+		}
 	case OperationEncode:
 		if pb.Encode == nil { // Encode block.
 			return ErrInvalidEncodeBlock
 		}
+		err := pb.Encode.check()
+		if err != nil {
+			return err
+		}
 	case OperationCrop: // Crop block.
 		if pb.Crop == nil {
 			return ErrInvalidCropBlock
 		}
+		err := pb.Crop.check()
+		if err != nil {
+			return err
+		}
 	case OperationWrite: // File output block.
 		if pb.Write == nil {
 			return ErrInvalidWriteBlock
 		}
+		if pb.Write.Format != "" && !isEncodeFormat(pb.Write.Format) { // Empty format keeps input extension.
+			return fmt.Errorf("%w: '%s'", ErrInvalidEncodeFormat, pb.Write.Format)
+		}
 	case OperationIccEmbed: // ICC embedding block.
 		if pb.ICCEmbedProfile == nil {
 			return ErrInvalidIccBlock
 		}
+		err := pb.ICCEmbedProfile.check()
+		if err != nil {
+			return err
+		}
+	case OperationRotate: // Rotate block.
+		if pb.Rotate == nil {
+			return ErrInvalidRotateBlock
+		}
+		_, err := filter.ParseColor(pb.Rotate.Background)
+		if err != nil {
+			return err
+		}
+	case OperationFlip: // Flip block.
+		if pb.Flip == nil {
+			return ErrInvalidFlipBlock
+		}
+		switch pb.Flip.Direction {
+		case filter.FlipHorizontal, filter.FlipVertical, filter.FlipBoth:
+		default:
+			return ErrInvalidFlipDirection
+		}
+	case OperationPad: // Pad block.
+		if pb.Pad == nil {
+			return ErrInvalidPadBlock
+		}
+		err := pb.Pad.check()
+		if err != nil {
+			return err
+		}
+	case OperationTrim: // Trim block.
+		if pb.Trim == nil {
+			return ErrInvalidTrimBlock
+		}
+		if pb.Trim.Tolerance < 0 || pb.Trim.Tolerance > 100 || pb.Trim.Padding < 0 {
+			return ErrInvalidTrimValue
+		}
+		if pb.Trim.Background != "" {
+			_, err := filter.ParseColor(pb.Trim.Background)
+			if err != nil {
+				return err
+			}
+		}
+	case OperationWatermark: // Watermark block.
+		if pb.Watermark == nil {
+			return ErrInvalidWatermarkBlock
+		}
+		err := pb.Watermark.check()
+		if err != nil {
+			return err
+		}
+	case OperationText: // Text block.
+		if pb.Text == nil {
+			return ErrInvalidTextConfigBlock
+		}
+		err := pb.Text.check()
+		if err != nil {
+			return err
+		}
+	case OperationAdjust: // Adjust block.
+		if pb.Adjust == nil {
+			return ErrInvalidAdjustBlock
+		}
+		err := pb.Adjust.check()
+		if err != nil {
+			return err
+		}
+	case OperationLevels: // Levels block.
+		if pb.Levels == nil {
+			return ErrInvalidLevelsBlock
+		}
+		err := pb.Levels.check()
+		if err != nil {
+			return err
+		}
+	case OperationCurves: // Curves block.
+		if pb.Curves == nil {
+			return ErrInvalidCurvesBlock
+		}
+		_, err := pb.Curves.curves()
+		if err != nil {
+			return err
+		}
+	case OperationLUT: // LUT block.
+		if pb.LUT == nil {
+			return ErrInvalidLUTBlock
+		}
+		err := pb.LUT.check()
+		if err != nil {
+			return err
+		}
+	case OperationIccConvert: // ICC conversion block.
+		if pb.ICCConvert == nil {
+			return ErrInvalidIccConvertBlock
+		}
+		err := pb.ICCConvert.check()
+		if err != nil {
+			return err
+		}
+	case OperationGrayscale: // Grayscale block, configuration is optional.
+		if pb.Grayscale != nil {
+			err := pb.Grayscale.check()
+			if err != nil {
+				return err
+			}
+		}
+	case OperationSepia: // Sepia block, configuration is optional.
+		if pb.Sepia != nil {
+			err := pb.Sepia.check()
+			if err != nil {
+				return err
+			}
+		}
+	case OperationDuotone: // Duotone block.
+		if pb.Duotone == nil {
+			return ErrInvalidDuotoneBlock
+		}
+		err := pb.Duotone.check()
+		if err != nil {
+			return err
+		}
+	case OperationChannels: // Channels block.
+		if pb.Channels == nil {
+			return ErrInvalidChannelsBlock
+		}
+		err := pb.Channels.check()
+		if err != nil {
+			return err
+		}
+	case OperationInvert: // Invert block.
+		// No additional configuration.
+		break
+	case OperationSharpen: // Sharpen block, configuration is optional.
+		if pb.Sharpen != nil {
+			err := pb.Sharpen.check()
+			if err != nil {
+				return err
+			}
+		}
+	case OperationBlur: // Blur block.
+		if pb.Blur == nil {
+			return ErrInvalidBlurBlock
+		}
+		err := pb.Blur.check()
+		if err != nil {
+			return err
+		}
+	case OperationConvolve: // Convolve block.
+		if pb.Convolve == nil {
+			return ErrInvalidConvolveBlock
+		}
+		err := pb.Convolve.check()
+		if err != nil {
+			return err
+		}
+	case OperationFlatten: // Flatten block, configuration is optional.
+		if pb.Flatten != nil {
+			err := pb.Flatten.check()
+			if err != nil {
+				return err
+			}
+		}
+	case OperationAlpha: // Alpha block.
+		if pb.Alpha == nil {
+			return ErrInvalidAlphaBlock
+		}
+		err := pb.Alpha.check()
+		if err != nil {
+			return err
+		}
+	case OperationBorder: // Border block.
+		if pb.Border == nil {
+			return ErrInvalidBorderBlock
+		}
+		err := pb.Border.check()
+		if err != nil {
+			return err
+		}
+	case OperationRoundCorners: // Round corners block.
+		if pb.RoundCorners == nil {
+			return ErrInvalidRoundCornersBlock
+		}
+		err := pb.RoundCorners.check()
+		if err != nil {
+			return err
+		}
+	case OperationQuantize: // Quantize block.
+		if pb.Quantize == nil {
+			return ErrInvalidQuantizeBlock
+		}
+		err := pb.Quantize.check()
+		if err != nil {
+			return err
+		}
 	default:
 		return ErrInvalidPipelineBlockType
 	}
@@ -88,18 +415,180 @@ func checkPipelineBlock(pb PipelineBlock) error {
 // Check the integrity of pipeline block list.
 //
 // pbs: List of pipeline blocks to check.
+// Blocks working on decoded image must come before `encode`.
 func checkPipelineBlockList(pbs []PipelineBlock) error {
 
+	encoded := false
 	for _, pb := range pbs {
 		err := checkPipelineBlock(pb)
 		if err != nil {
 			return err
 		}
+
+		if encoded && isPixelOperation(pb.Operation) {
+			return fmt.Errorf("%w: '%s'", ErrPixelBlockAfterEncode, pb.Operation)
+		}
+		encoded = encoded || pb.Operation == OperationEncode
 	}
 
 	return nil
 }
 
+// Check if operation works on decoded image.
+func isPixelOperation(operation string) bool {
+	switch operation {
+	case OperationCrop, OperationResize, OperationRotate, OperationFlip, OperationAutoOrient,
+		OperationPad, OperationTrim, OperationWatermark, OperationText, OperationAdjust, OperationLevels, OperationCurves,
+		OperationLUT, OperationIccConvert, OperationGrayscale, OperationSepia, OperationDuotone, OperationChannels,
+		OperationInvert, OperationSharpen, OperationBlur,
+		OperationConvolve, OperationFlatten, OperationAlpha, OperationBorder, OperationRoundCorners,
+		OperationQuantize:
+		return true
+	}
+	return false
+}
+
+// Resolve relative paths of files referenced by blocks, e.g. watermark images, fonts, LUTs and ICC profiles.
+//
+// base_dir: Directory relative paths are resolved against.
+func (profile_root *ProfileRoot) resolvePaths(base_dir string) {
+
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(base_dir, *path)
+		}
+	}
+
+	for _, profile := range profile_root.Profiles {
+		for _, pb := range profile.PipelineBlocks {
+			if pb.Watermark != nil {
+				resolve(&pb.Watermark.File)
+			}
+			if pb.Text != nil {
+				resolve(&pb.Text.Font)
+			}
+			if pb.LUT != nil {
+				resolve(&pb.LUT.File)
+			}
+			if pb.ICCEmbedProfile != nil {
+				resolve(&pb.ICCEmbedProfile.File)
+			}
+			if pb.ICCConvert != nil { // Built-in profile names are kept.
+				if !icc.IsNamed(pb.ICCConvert.Source) {
+					resolve(&pb.ICCConvert.Source)
+				}
+				if !icc.IsNamed(pb.ICCConvert.Target) {
+					resolve(&pb.ICCConvert.Target)
+				}
+			}
+		}
+	}
+}
+
+// Values loaded on first use and shared by all jobs of a block, e.g. decoded watermark image.
+//
+// Values are keyed, so values depending on the job are shared as well, e.g. transforms of embedded profiles.
+// A nil cache loads on every use, e.g. for blocks created in code instead of loaded from config file.
+type sharedValues[T any] struct {
+	mutex  sync.Mutex                 // Guards values.
+	values map[string]*sharedValue[T] // Loaded values, keyed by what they depend on.
+}
+
+// Value of `sharedValues`, loaded once.
+type sharedValue[T any] struct {
+	once  sync.Once // Loads value.
+	value T         // Loaded value.
+	err   error     // Error of loading, returned to every job.
+}
+
+// Create empty cache of shared values.
+func newSharedValues[T any]() *sharedValues[T] {
+	return &sharedValues[T]{values: map[string]*sharedValue[T]{}}
+}
+
+// Get value of key, loading it by first caller. Concurrent callers wait for the value.
+func (sv *sharedValues[T]) get(key string, load func() (T, error)) (T, error) {
+
+	if sv == nil {
+		return load()
+	}
+
+	sv.mutex.Lock()
+	entry, ok := sv.values[key]
+	if !ok {
+		entry = &sharedValue[T]{}
+		sv.values[key] = entry
+	}
+	sv.mutex.Unlock()
+
+	entry.once.Do(func() { entry.value, entry.err = load() })
+	return entry.value, entry.err
+}
+
+// Share files referenced by blocks between jobs, they are loaded once per block instead of once per job.
+//
+// Files are read when first used, editing them has no effect until the config is loaded again.
+func (profile_root *ProfileRoot) shareFiles() {
+	for _, profile := range profile_root.Profiles {
+		for _, pb := range profile.PipelineBlocks {
+			if pb.Watermark != nil {
+				pb.Watermark.mark = newSharedValues[image.Image]()
+			}
+			if pb.Text != nil {
+				pb.Text.font = newSharedValues[[]byte]()
+			}
+			if pb.LUT != nil {
+				pb.LUT.lut = newSharedValues[*filter.LUT3D]()
+			}
+			if pb.ICCEmbedProfile != nil {
+				pb.ICCEmbedProfile.data = newSharedValues[[]byte]()
+			}
+			if pb.ICCConvert != nil {
+				pb.ICCConvert.profiles = newSharedValues[*icc.Profile]()
+				pb.ICCConvert.transforms = newSharedValues[*icc.Transform]()
+			}
+		}
+	}
+}
+
+// Get files referenced by blocks of the profile, in block order.
+//
+// Outputs depend on their content as well, e.g. cache entries must be invalidated when a watermark image is edited.
+func (profile ImageProcessingProfile) ReferencedFiles() []string {
+
+	files := []string{}
+	add := func(path string) {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+
+	for _, pb := range profile.PipelineBlocks {
+		if pb.Watermark != nil {
+			add(pb.Watermark.File)
+		}
+		if pb.Text != nil {
+			add(pb.Text.Font)
+		}
+		if pb.LUT != nil {
+			add(pb.LUT.File)
+		}
+		if pb.ICCEmbedProfile != nil {
+			add(pb.ICCEmbedProfile.File)
+		}
+		if pb.ICCConvert != nil { // Built-in profiles are part of the tool.
+			if !icc.IsNamed(pb.ICCConvert.Source) {
+				add(pb.ICCConvert.Source)
+			}
+			if !icc.IsNamed(pb.ICCConvert.Target) {
+				add(pb.ICCConvert.Target)
+			}
+		}
+	}
+
+	return files
+}
+
 // Load config file from path.
 //
 // config_path: Path to config file.
@@ -116,6 +605,10 @@ func LoadConfigFromFile(config_path string) (ProfileRoot, error) {
 		return ProfileRoot{}, err
 	}
 
+	// Files referenced by blocks are relative to the config file.
+	conf.resolvePaths(filepath.Dir(config_path))
+	conf.shareFiles()
+
 	// Iterate through profiles.
 	for _, profile := range conf.Profiles {
 
@@ -141,6 +634,33 @@ func (profile_root ProfileRoot) ToYaml() string {
 	return string(yaml_str)
 }
 
+// Profile instance to yaml string.
+//
+// Since input file is not serialized, the result can be used as canonical form of the profile definition.
+func (profile ImageProcessingProfile) ToYaml() string {
+
+	// Convert to yaml.
+	yaml_str, err := yaml.Marshal(profile)
+	if err != nil {
+		return ""
+	}
+
+	return string(yaml_str)
+}
+
+// Get output file paths of all `write` blocks in the profile.
+func (profile ImageProcessingProfile) OutputFiles() []string {
+
+	outputs := []string{}
+	for _, pb := range profile.PipelineBlocks {
+		if pb.Operation == OperationWrite {
+			outputs = append(outputs, pb.Write.GenerateFileName())
+		}
+	}
+
+	return outputs
+}
+
 // Assign input file to current config file.
 //
 // This is a temporary solution to the issue which "write" block cannot get original input file name.
@@ -166,7 +686,10 @@ func (profile *ImageProcessingProfile) AssignInputFile(input_file string) {
 	profile.assignedFilePath = input_file
 
 	// Assign input file to all pipeline blocks.
-	for _, pb := range profile.PipelineBlocks {
+	job := &JobMetadata{} // Fresh metadata for every job.
+	for index, pb := range profile.PipelineBlocks {
+		profile.PipelineBlocks[index].assignedFilePath = input_file // Used by blocks reading input metadata.
+		profile.PipelineBlocks[index].job = job
 		if pb.Operation == OperationWrite {
 			pb.Write.assignedFilePath = input_file
 		}
@@ -175,9 +698,9 @@ func (profile *ImageProcessingProfile) AssignInputFile(input_file string) {
 }
 
 // Create image file from assigned file path.
-func (pf ImageProcessingProfile) CreateImageFile() (op.CurrentProcessingImage, error) {
+func (pf ImageProcessingProfile) CreateImageFile() (ProcessingImage, error) {
 	// Create image file.
-	return op.CreateImageFromFile(pf.assignedFilePath)
+	return ReadImageFile(pf.assignedFilePath)
 }
 
 // Merge multiple config files.
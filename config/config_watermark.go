@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register decoders for watermark images.
+	_ "image/png"
+	"imagetools/filter"
+	"math"
+	"os"
+)
+
+var (
+	ErrInvalidWatermarkFile  = errors.New("watermark block requires an existing image file")
+	ErrInvalidWatermarkValue = errors.New("watermark scale must not be negative, and opacity must be within 0 to 1")
+	ErrInvalidBlendMode      = errors.New("unsupported blend mode")
+)
+
+// Check the integrity of watermark configuration.
+func (wc WatermarkConfig) check() error {
+
+	if wc.File == "" {
+		return ErrInvalidWatermarkFile
+	}
+	if _, err := os.Stat(wc.File); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWatermarkFile, err)
+	}
+
+	if wc.Scale < 0 || (wc.Opacity != nil && (*wc.Opacity < 0 || *wc.Opacity > 1)) {
+		return ErrInvalidWatermarkValue
+	}
+
+	if !filter.IsBlendMode(wc.Blend) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidBlendMode, wc.Blend)
+	}
+
+	if _, _, ok := filter.AnchorPosition(wc.Alignment); !ok {
+		return fmt.Errorf("%w: '%s'", ErrInvalidAlignment, wc.Alignment)
+	}
+
+	for _, length := range []Length{wc.OffsetX, wc.OffsetY} {
+		err := length.check(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return wc.Spacing.check(false)
+}
+
+// Compute watermark size for image of given size.
+//
+// width: Image width.
+// mark: Original watermark size.
+func (wc WatermarkConfig) markSize(width int, mark image.Point) image.Point {
+	if wc.Scale == 0 || mark.X == 0 {
+		return mark
+	}
+	scaled_w := float64(width) * wc.Scale
+	return image.Pt(roundDimension(scaled_w), roundDimension(scaled_w*float64(mark.Y)/float64(mark.X)))
+}
+
+// Compute top-left corners of watermark copies.
+//
+// width, height: Image size.
+// mark: Watermark size after scaling.
+func (wc WatermarkConfig) positions(width int, height int, mark image.Point) []image.Point {
+
+	position := filter.AnchorOffset(wc.Alignment, image.Pt(width, height), mark)
+	position.X += wc.OffsetX.pixels(width)
+	position.Y += wc.OffsetY.pixels(height)
+
+	if !wc.Tile {
+		return []image.Point{position}
+	}
+
+	// Grid through aligned position, covering the whole image.
+	step := mark.Add(image.Pt(wc.Spacing.pixels(width), wc.Spacing.pixels(height)))
+	start := image.Pt(
+		position.X-int(math.Ceil(float64(position.X)/float64(step.X)))*step.X,
+		position.Y-int(math.Ceil(float64(position.Y)/float64(step.Y)))*step.Y,
+	)
+
+	positions := []image.Point{}
+	for y := start.Y; y < height; y += step.Y {
+		for x := start.X; x < width; x += step.X {
+			positions = append(positions, image.Pt(x, y))
+		}
+	}
+
+	return positions
+}
+
+// Load watermark image.
+func loadWatermark(file_path string) (image.Image, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mark, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode watermark '%s': %w", file_path, err)
+	}
+
+	return mark, nil
+}
+
+// Create watermark operation which scales and places watermark by current image.
+//
+// Watermark image is decoded once per block, and shared by all jobs.
+func watermarkOperation(wc WatermarkConfig) Operation {
+
+	opacity := 1.0
+	if wc.Opacity != nil {
+		opacity = *wc.Opacity
+	}
+
+	return applyImageFilter(func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, filter.ErrNoImage
+		}
+
+		mark, err := wc.mark.get("", func() (image.Image, error) { return loadWatermark(wc.File) })
+		if err != nil {
+			return nil, err
+		}
+
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		size := wc.markSize(width, mark.Bounds().Size())
+		if size != mark.Bounds().Size() {
+			mark, err = filter.Resize(size.X, size.Y, filter.AlgorithmLanczos3, true)(mark)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return filter.Composite(mark, wc.positions(width, height, size), opacity, wc.Blend)(img)
+	})
+}
@@ -0,0 +1,166 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Alpha channel modes.
+const (
+	AlphaRemove      = "remove"      // Make image opaque, colors of transparent pixels show up as stored.
+	AlphaAdd         = "add"         // Scale alpha channel by opacity, e.g. translucent overlays.
+	AlphaColorKey    = "color_key"   // Make pixels close to key color transparent.
+	AlphaPremultiply = "premultiply" // Multiply colors by alpha, for consumers expecting premultiplied data.
+	AlphaUnmatte     = "unmatte"     // Remove matte color blended into semi-transparent pixels, e.g. white halo.
+)
+
+// Check if the alpha mode is supported.
+func IsAlphaMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case AlphaRemove, AlphaAdd, AlphaColorKey, AlphaPremultiply, AlphaUnmatte:
+		return true
+	}
+	return false
+}
+
+// Create filter converting every pixel, with its position.
+func mapPixels(convert func(x int, y int, c color.NRGBA) color.NRGBA) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		dst := image.NewNRGBA(src.Rect)
+		for y := 0; y < src.Rect.Dy(); y++ {
+			si, di := src.PixOffset(0, y), dst.PixOffset(0, y)
+			for x := 0; x < src.Rect.Dx(); x++ {
+				p := src.Pix[si+x*4 : si+x*4+4]
+				c := convert(x, y, color.NRGBA{p[0], p[1], p[2], p[3]})
+				copy(dst.Pix[di+x*4:], []uint8{c.R, c.G, c.B, c.A})
+			}
+		}
+
+		return dst, nil
+	})
+}
+
+// Composite image onto opaque background, result is opaque. Opaque images are returned as is.
+//
+// background: Background color, its alpha is ignored.
+// checker: Cell size of checkerboard in pixels, alternating background and checker color. 0 fills with background only.
+// checker_color: Second color of checkerboard.
+func Flatten(background color.NRGBA, checker int, checker_color color.NRGBA) Filter {
+
+	flatten := mapPixels(func(x int, y int, c color.NRGBA) color.NRGBA {
+		bg := background
+		if checker > 0 && (x/checker+y/checker)%2 == 1 {
+			bg = checker_color
+		}
+		a := float64(c.A) / 255
+		return color.NRGBA{
+			clampUint8(float64(c.R)*a + float64(bg.R)*(1-a)),
+			clampUint8(float64(c.G)*a + float64(bg.G)*(1-a)),
+			clampUint8(float64(c.B)*a + float64(bg.B)*(1-a)),
+			255,
+		}
+	})
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		if src.Opaque() {
+			return src, nil
+		}
+		result, err := flatten(src)
+		if err != nil {
+			return nil, err
+		}
+		return result.(*image.NRGBA), nil
+	})
+}
+
+// Make image opaque, colors are kept as stored. Use `Flatten` for a defined background.
+func RemoveAlpha() Filter {
+	return mapPixels(func(_ int, _ int, c color.NRGBA) color.NRGBA {
+		c.A = 255
+		return c
+	})
+}
+
+// Scale alpha channel, e.g. 0.5 for half transparent image.
+//
+// opacity: Alpha multiplier in [0, 1].
+func ScaleAlpha(opacity float64) Filter {
+	return mapPixels(func(_ int, _ int, c color.NRGBA) color.NRGBA {
+		c.A = clampUint8(float64(c.A) * opacity)
+		return c
+	})
+}
+
+// Make pixels close to key color transparent, e.g. removing flat background.
+//
+// key: Key color, its alpha is ignored.
+// tolerance: Maximum channel difference to key color of fully transparent pixels, in percent.
+// feather: Range in percent above tolerance where alpha ramps up to the original value, softens edges.
+func ColorKey(key color.NRGBA, tolerance float64, feather float64) Filter {
+
+	limit, ramp := tolerance*255/100, feather*255/100
+
+	return mapPixels(func(_ int, _ int, c color.NRGBA) color.NRGBA {
+		diff := math.Max(math.Abs(float64(c.R)-float64(key.R)),
+			math.Max(math.Abs(float64(c.G)-float64(key.G)), math.Abs(float64(c.B)-float64(key.B))))
+		switch {
+		case diff <= limit:
+			c.A = 0
+		case diff < limit+ramp:
+			c.A = clampUint8(float64(c.A) * (diff - limit) / ramp)
+		}
+		return c
+	})
+}
+
+// Multiply colors by alpha, alpha channel is kept.
+func Premultiply() Filter {
+	return mapPixels(func(_ int, _ int, c color.NRGBA) color.NRGBA {
+		a := float64(c.A) / 255
+		return color.NRGBA{clampUint8(float64(c.R) * a), clampUint8(float64(c.G) * a), clampUint8(float64(c.B) * a), c.A}
+	})
+}
+
+// Recover colors of semi-transparent pixels which were blended onto matte color, alpha channel is kept.
+//
+// matte: Matte color, its alpha is ignored.
+func Unmatte(matte color.NRGBA) Filter {
+	return mapPixels(func(_ int, _ int, c color.NRGBA) color.NRGBA {
+		if c.A == 0 || c.A == 255 {
+			return c
+		}
+		a := float64(c.A) / 255
+		unblend := func(v uint8, m uint8) uint8 {
+			return clampUint8((float64(v) - float64(m)*(1-a)) / a)
+		}
+		return color.NRGBA{unblend(c.R, matte.R), unblend(c.G, matte.G), unblend(c.B, matte.B), c.A}
+	})
+}
+
+// Create alpha filter by mode.
+//
+// mode: Alpha mode, see `IsAlphaMode`.
+// opacity: Alpha multiplier of `add` mode.
+// key: Key color of `color_key` mode, or matte color of `unmatte` mode.
+// tolerance, feather: Key color range of `color_key` mode, in percent.
+func Alpha(mode string, opacity float64, key color.NRGBA, tolerance float64, feather float64) Filter {
+
+	switch strings.ToLower(mode) {
+	case AlphaRemove:
+		return RemoveAlpha()
+	case AlphaAdd:
+		return ScaleAlpha(opacity)
+	case AlphaColorKey:
+		return ColorKey(key, tolerance, feather)
+	case AlphaPremultiply:
+		return Premultiply()
+	case AlphaUnmatte:
+		return Unmatte(key)
+	}
+
+	return func(image.Image) (image.Image, error) { return nil, fmt.Errorf("unknown alpha mode '%s'", mode) }
+}
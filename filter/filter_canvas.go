@@ -0,0 +1,160 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Canvas fill modes.
+const (
+	FillColor = "color" // Fill with background color.
+	FillBlur  = "blur"  // Fill with blurred and enlarged copy of the image.
+)
+
+// Place image on a larger canvas.
+//
+// If canvas is smaller than image in any dimension, the canvas grows to fit the image, nothing is cropped.
+//
+// width, height: Canvas size.
+// anchor: Position of the image on canvas, see `AnchorPosition`.
+// background: Background color, used by `color` fill.
+// fill: Fill mode, `color` or `blur`.
+func Pad(width int, height int, anchor string, background color.NRGBA, fill string) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		size := src.Rect.Size()
+		canvas_size := image.Pt(max(width, size.X), max(height, size.Y))
+
+		var canvas *image.NRGBA
+		switch fill {
+		case "", FillColor:
+			canvas = image.NewNRGBA(image.Rectangle{Max: canvas_size})
+			draw.Draw(canvas, canvas.Rect, &image.Uniform{background}, image.Point{}, draw.Src)
+		case FillBlur:
+			canvas = blurredBackdrop(src, canvas_size)
+		default:
+			return nil, fmt.Errorf("unknown canvas fill '%s'", fill)
+		}
+
+		// Composite image over canvas.
+		offset := AnchorOffset(anchor, canvas_size, size)
+		draw.Draw(canvas, image.Rectangle{offset, offset.Add(size)}, src, image.Point{}, draw.Over)
+
+		return canvas, nil
+	})
+}
+
+// Create blurred backdrop covering the canvas from the image itself.
+//
+// The image is blurred at reduced resolution and scaled up afterwards, which is cheap and smooth.
+func blurredBackdrop(src *image.NRGBA, canvas_size image.Point) *image.NRGBA {
+
+	const reduction = 8 // Downscale ratio before blurring.
+
+	// Cover canvas at reduced resolution, preserving aspect ratio.
+	size := src.Rect.Size()
+	scale := max(float64(canvas_size.X)/float64(size.X), float64(canvas_size.Y)/float64(size.Y)) / reduction
+	small_w := max(1, int(float64(size.X)*scale+0.5))
+	small_h := max(1, int(float64(size.Y)*scale+0.5))
+
+	small := toPremultipliedBuffer(src, false)
+	small = resampleBuffer(small, small_w, small_h, kernels[AlgorithmBox])
+	box := boxKernel(2)
+	for pass := 0; pass < 3; pass++ { // Three box passes approximate gaussian.
+		small = convolveBuffer(small, box, box, EdgeClamp)
+	}
+
+	// Scale up to cover canvas, then crop overflow at center.
+	cover_w := max(canvas_size.X, int(float64(small_w)*reduction))
+	cover_h := max(canvas_size.Y, int(float64(small_h)*reduction))
+	cover := resampleBuffer(small, cover_w, cover_h, kernels[AlgorithmApproxBiLinear]).toNRGBA(false)
+
+	offset := AnchorOffset(AnchorCenter, image.Pt(cover_w, cover_h), canvas_size)
+	backdrop := image.NewNRGBA(image.Rectangle{Max: canvas_size})
+	draw.Draw(backdrop, backdrop.Rect, cover, offset, draw.Src)
+
+	return backdrop
+}
+
+// Surround image with border, and optional padding between image and border.
+//
+// top, right, bottom, left: Border width of each side in pixels.
+// padding: Width of padding on each side in pixels.
+// border, padding_color: Colors of border and padding.
+func Border(top int, right int, bottom int, left int, padding int, border color.NRGBA, padding_color color.NRGBA) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		if min(top, right, bottom, left, padding) < 0 {
+			return nil, fmt.Errorf("border and padding widths must not be negative")
+		}
+
+		size := src.Rect.Size()
+		inner := image.Rectangle{Max: size}.Add(image.Pt(left+padding, top+padding))
+		canvas := image.NewNRGBA(image.Rect(0, 0, left+right+size.X+padding*2, top+bottom+size.Y+padding*2))
+
+		draw.Draw(canvas, canvas.Rect, &image.Uniform{border}, image.Point{}, draw.Src)
+		draw.Draw(canvas, inner.Inset(-padding), &image.Uniform{padding_color}, image.Point{}, draw.Src)
+		draw.Draw(canvas, inner, src, image.Point{}, draw.Src)
+
+		return canvas, nil
+	})
+}
+
+// Round corners of image, edges are anti-aliased.
+//
+// radius: Corner radius in pixels, limited to half of the shorter side.
+// background: Color outside of rounded corners, transparent keeps the corners see-through.
+func RoundCorners(radius int, background color.NRGBA) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		size := src.Rect.Size()
+		r := float64(min(radius, size.X/2, size.Y/2))
+		if r <= 0 {
+			return src, nil
+		}
+
+		dst := image.NewNRGBA(src.Rect)
+		copy(dst.Pix, src.Pix)
+
+		// Background premultiplied, so that transparent background only scales alpha.
+		bg_a := float64(background.A) / 255
+		bg := [4]float64{float64(background.R) * bg_a, float64(background.G) * bg_a, float64(background.B) * bg_a, float64(background.A)}
+
+		corner := int(math.Ceil(r))
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				if (x >= corner && x < size.X-corner) || (y >= corner && y < size.Y-corner) {
+					continue // Outside of corner areas.
+				}
+
+				// Distance of pixel center to center of corner circle.
+				cx := math.Max(r-float64(x)-0.5, float64(x)+0.5-(float64(size.X)-r))
+				cy := math.Max(r-float64(y)-0.5, float64(y)+0.5-(float64(size.Y)-r))
+				if cx <= 0 || cy <= 0 {
+					continue
+				}
+				coverage := math.Min(1, math.Max(0, r-math.Hypot(cx, cy)+0.5))
+				if coverage == 1 {
+					continue
+				}
+
+				i := dst.PixOffset(x, y)
+				a := float64(dst.Pix[i+3]) / 255
+				alpha := float64(dst.Pix[i+3])*coverage + bg[3]*(1-coverage)
+				if alpha <= 0 {
+					copy(dst.Pix[i:i+4], []uint8{0, 0, 0, 0})
+					continue
+				}
+				for ch := 0; ch < 3; ch++ {
+					dst.Pix[i+ch] = clampUint8((float64(dst.Pix[i+ch])*a*coverage + bg[ch]*(1-coverage)) * 255 / alpha)
+				}
+				dst.Pix[i+3] = clampUint8(alpha)
+			}
+		}
+
+		return dst, nil
+	})
+}
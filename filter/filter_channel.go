@@ -0,0 +1,214 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Weights of grayscale conversion.
+const (
+	GrayRec601    = "rec601"    // Rec. 601 luma of encoded values, as in JPEG and most image editors.
+	GrayRec709    = "rec709"    // Rec. 709 luma of encoded values, default.
+	GrayLuminance = "luminance" // Rec. 709 weights in linear light, matches perceived lightness.
+)
+
+// Rec. 601 luma coefficients.
+const (
+	luma601R = 0.299
+	luma601G = 0.587
+	luma601B = 0.114
+)
+
+// Channel sources of channel mapping.
+const (
+	ChannelSourceRed   = "red"   // Red channel of input.
+	ChannelSourceGreen = "green" // Green channel of input.
+	ChannelSourceBlue  = "blue"  // Blue channel of input.
+	ChannelSourceAlpha = "alpha" // Alpha channel of input.
+	ChannelSourceBlack = "black" // Constant 0.
+	ChannelSourceWhite = "white" // Constant 255.
+)
+
+// Check if the grayscale weights are supported, empty weights are `rec709`.
+func IsGrayWeights(weights string) bool {
+	switch strings.ToLower(weights) {
+	case "", GrayRec601, GrayRec709, GrayLuminance:
+		return true
+	}
+	return false
+}
+
+// Check if the channel source is supported, empty source keeps the channel.
+func IsChannelSource(source string) bool {
+	switch strings.ToLower(source) {
+	case "", ChannelSourceRed, ChannelSourceGreen, ChannelSourceBlue, ChannelSourceAlpha, ChannelSourceBlack, ChannelSourceWhite:
+		return true
+	}
+	return false
+}
+
+// Create function computing gray value of color.
+//
+// weights: Grayscale weights, see `IsGrayWeights`.
+func grayFunction(weights string) (func(r uint8, g uint8, b uint8) uint8, error) {
+
+	switch strings.ToLower(weights) {
+	case "", GrayRec709:
+		return func(r uint8, g uint8, b uint8) uint8 {
+			return clampUint8(lumaR*float64(r) + lumaG*float64(g) + lumaB*float64(b))
+		}, nil
+
+	case GrayRec601:
+		return func(r uint8, g uint8, b uint8) uint8 {
+			return clampUint8(luma601R*float64(r) + luma601G*float64(g) + luma601B*float64(b))
+		}, nil
+
+	case GrayLuminance:
+		var linear [256]float64
+		for i := range linear {
+			linear[i] = srgbToLinear(float64(i) / 255)
+		}
+		return func(r uint8, g uint8, b uint8) uint8 {
+			y := lumaR*linear[r] + lumaG*linear[g] + lumaB*linear[b]
+			return clampUint8(linearToSRGB(math.Min(1, y)) * 255)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown grayscale weights '%s'", weights)
+}
+
+// Convert image to grayscale, alpha channel is kept.
+//
+// weights: Grayscale weights, see `IsGrayWeights`.
+func Grayscale(weights string) Filter {
+
+	gray, err := grayFunction(weights)
+	if err != nil {
+		return func(image.Image) (image.Image, error) { return nil, err }
+	}
+
+	return ConvertColors(func(r uint8, g uint8, b uint8) (uint8, uint8, uint8) {
+		v := gray(r, g, b)
+		return v, v, v
+	})
+}
+
+// Apply sepia tone, alpha channel is kept.
+//
+// strength: Mix of toned and original image in [0, 1].
+func Sepia(strength float64) Filter {
+
+	// Classic sepia matrix, blended with identity by strength.
+	sepia := [9]float64{
+		0.393, 0.769, 0.189,
+		0.349, 0.686, 0.168,
+		0.272, 0.534, 0.131,
+	}
+	identity := [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	var m [9]float64
+	for i := range m {
+		m[i] = identity[i] + (sepia[i]-identity[i])*strength
+	}
+
+	return ConvertColors(func(r uint8, g uint8, b uint8) (uint8, uint8, uint8) {
+		fr, fg, fb := float64(r), float64(g), float64(b)
+		return clampUint8(m[0]*fr + m[1]*fg + m[2]*fb),
+			clampUint8(m[3]*fr + m[4]*fg + m[5]*fb),
+			clampUint8(m[6]*fr + m[7]*fg + m[8]*fb)
+	})
+}
+
+// Map gray value of image onto color gradient, e.g. duotone with two colors. Alpha channel is kept.
+//
+// colors: Gradient stops from shadows to highlights, evenly spaced, at least two.
+// weights: Grayscale weights, see `IsGrayWeights`.
+func GradientMap(colors []color.NRGBA, weights string) Filter {
+
+	gray, err := grayFunction(weights)
+	if err == nil && len(colors) < 2 {
+		err = fmt.Errorf("gradient map requires at least two colors, got %d", len(colors))
+	}
+	if err != nil {
+		return func(image.Image) (image.Image, error) { return nil, err }
+	}
+
+	// Gradient is precomputed for every gray value.
+	var gradient [256][3]uint8
+	for i := range gradient {
+		position := float64(i) / 255 * float64(len(colors)-1)
+		stop := min(int(position), len(colors)-2)
+		t := position - float64(stop)
+		from, to := colors[stop], colors[stop+1]
+		gradient[i] = [3]uint8{
+			clampUint8(float64(from.R) + (float64(to.R)-float64(from.R))*t),
+			clampUint8(float64(from.G) + (float64(to.G)-float64(from.G))*t),
+			clampUint8(float64(from.B) + (float64(to.B)-float64(from.B))*t),
+		}
+	}
+
+	return ConvertColors(func(r uint8, g uint8, b uint8) (uint8, uint8, uint8) {
+		c := gradient[gray(r, g, b)]
+		return c[0], c[1], c[2]
+	})
+}
+
+// Invert color channels, alpha channel is kept.
+func Invert() Filter {
+	return ConvertColors(func(r uint8, g uint8, b uint8) (uint8, uint8, uint8) {
+		return 255 - r, 255 - g, 255 - b
+	})
+}
+
+// Rebuild channels from input channels, e.g. swap red and blue, or extract one channel as gray.
+//
+// sources: Source of red, green, blue and alpha channel, see `IsChannelSource`. Empty source keeps the channel.
+func MapChannels(sources [4]string) Filter {
+
+	// Source channel index, 4 and 5 are constant black and white.
+	var indices [4]int
+	for ch, source := range sources {
+		switch strings.ToLower(source) {
+		case "":
+			indices[ch] = ch
+		case ChannelSourceRed:
+			indices[ch] = 0
+		case ChannelSourceGreen:
+			indices[ch] = 1
+		case ChannelSourceBlue:
+			indices[ch] = 2
+		case ChannelSourceAlpha:
+			indices[ch] = 3
+		case ChannelSourceBlack:
+			indices[ch] = 4
+		case ChannelSourceWhite:
+			indices[ch] = 5
+		default:
+			return func(image.Image) (image.Image, error) {
+				return nil, fmt.Errorf("unknown channel source '%s'", source)
+			}
+		}
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		dst := image.NewNRGBA(src.Rect)
+		width := src.Rect.Dx()
+
+		var values [6]uint8
+		values[5] = 255
+		for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+			si, di := src.PixOffset(src.Rect.Min.X, y), dst.PixOffset(dst.Rect.Min.X, y)
+			for x := 0; x < width*4; x += 4 {
+				copy(values[:4], src.Pix[si+x:si+x+4])
+				for ch, index := range indices {
+					dst.Pix[di+x+ch] = values[index]
+				}
+			}
+		}
+
+		return dst, nil
+	})
+}
@@ -0,0 +1,292 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Errors
+var ErrInvalidCurve = errors.New("invalid tone curve")
+
+// Tonal and color adjustments, zero value changes nothing.
+//
+// Exposure: Exposure change in stops, applied in linear light.
+//
+// Gamma: Gamma correction, values above 1 brighten midtones. 0 is treated as 1.
+//
+// Brightness: Offset added to channels, in [-1, 1].
+//
+// Contrast: Contrast change around midtone, -1 is flat gray.
+//
+// Saturation: Saturation change, -1 is grayscale.
+//
+// Hue: Hue rotation in degrees.
+type Adjustment struct {
+	Exposure   float64 // Exposure in stops.
+	Gamma      float64 // Gamma correction.
+	Brightness float64 // Brightness offset.
+	Contrast   float64 // Contrast change.
+	Saturation float64 // Saturation change.
+	Hue        float64 // Hue rotation.
+}
+
+// Apply tonal and color adjustments.
+//
+// Tonal adjustments are applied first, in the order of `Adjustment` fields, then saturation and hue.
+// Alpha channel is kept.
+func Adjust(adjustment Adjustment) Filter {
+
+	// Tonal part is the same for every channel, precompute it as tone curve.
+	var tone ToneCurve
+	for i := range tone {
+		v := float64(i) / 255
+		if adjustment.Exposure != 0 {
+			v = linearToSRGB(math.Min(1, srgbToLinear(v)*math.Pow(2, adjustment.Exposure)))
+		}
+		if adjustment.Gamma > 0 && adjustment.Gamma != 1 {
+			v = math.Pow(v, 1/adjustment.Gamma)
+		}
+		v += adjustment.Brightness
+		v = (v-0.5)*(1+adjustment.Contrast) + 0.5
+		tone[i] = clampUint8(v * 255)
+	}
+
+	// Saturation and hue rotation as one color matrix around the gray axis.
+	matrix := hueMatrix(adjustment.Hue)
+	matrix = multiplyMatrix(saturationMatrix(1+adjustment.Saturation), matrix)
+	identity := adjustment.Saturation == 0 && math.Mod(adjustment.Hue, 360) == 0
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		dst := applyToneCurves(src, &tone, &tone, &tone)
+		if identity {
+			return dst, nil
+		}
+
+		for i := 0; i < len(dst.Pix); i += 4 { // Fresh image, rows are contiguous.
+			r, g, b := float64(dst.Pix[i]), float64(dst.Pix[i+1]), float64(dst.Pix[i+2])
+			dst.Pix[i] = clampUint8(matrix[0]*r + matrix[1]*g + matrix[2]*b)
+			dst.Pix[i+1] = clampUint8(matrix[3]*r + matrix[4]*g + matrix[5]*b)
+			dst.Pix[i+2] = clampUint8(matrix[6]*r + matrix[7]*g + matrix[8]*b)
+		}
+
+		return dst, nil
+	})
+}
+
+// Rec. 709 luma coefficients.
+const (
+	lumaR = 0.2126
+	lumaG = 0.7152
+	lumaB = 0.0722
+)
+
+// Color matrix scaling saturation while keeping luma.
+func saturationMatrix(s float64) [9]float64 {
+	return [9]float64{
+		lumaR*(1-s) + s, lumaG * (1 - s), lumaB * (1 - s),
+		lumaR * (1 - s), lumaG*(1-s) + s, lumaB * (1 - s),
+		lumaR * (1 - s), lumaG * (1 - s), lumaB*(1-s) + s,
+	}
+}
+
+// Color matrix rotating hue while keeping luma, same as CSS `hue-rotate`.
+func hueMatrix(degrees float64) [9]float64 {
+	c, s := math.Cos(degrees*math.Pi/180), math.Sin(degrees*math.Pi/180)
+	return [9]float64{
+		lumaR + c*(1-lumaR) - s*lumaR, lumaG - c*lumaG - s*lumaG, lumaB - c*lumaB + s*(1-lumaB),
+		lumaR - c*lumaR + s*0.143, lumaG + c*(1-lumaG) + s*0.140, lumaB - c*lumaB - s*0.283,
+		lumaR - c*lumaR - s*(1-lumaR), lumaG - c*lumaG + s*lumaG, lumaB + c*(1-lumaB) + s*lumaB,
+	}
+}
+
+// Multiply 3x3 matrices.
+func multiplyMatrix(a [9]float64, b [9]float64) [9]float64 {
+	var m [9]float64
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				m[row*3+col] += a[row*3+k] * b[k*3+col]
+			}
+		}
+	}
+	return m
+}
+
+// Tone curve, maps each 8-bit channel value to a new value.
+type ToneCurve [256]uint8
+
+// Tone curve changing nothing.
+func IdentityCurve() ToneCurve {
+	var curve ToneCurve
+	for i := range curve {
+		curve[i] = uint8(i)
+	}
+	return curve
+}
+
+// Apply `next` after this curve.
+func (curve ToneCurve) Then(next ToneCurve) ToneCurve {
+	var combined ToneCurve
+	for i, v := range curve {
+		combined[i] = next[v]
+	}
+	return combined
+}
+
+// Create levels tone curve.
+//
+// Input range is stretched to output range, with gamma applied in between.
+//
+// in_black, in_white: Input range, values outside are clipped.
+// gamma: Midtone gamma, values above 1 brighten midtones. 0 is treated as 1.
+// out_black, out_white: Output range.
+func LevelsCurve(in_black uint8, in_white uint8, gamma float64, out_black uint8, out_white uint8) ToneCurve {
+
+	if gamma <= 0 {
+		gamma = 1
+	}
+
+	var curve ToneCurve
+	for i := range curve {
+		v := 0.0
+		if in_white > in_black {
+			v = math.Min(1, math.Max(0, float64(i-int(in_black))/float64(int(in_white)-int(in_black))))
+		} else if i >= int(in_white) {
+			v = 1
+		}
+		v = math.Pow(v, 1/gamma)
+		curve[i] = clampUint8(float64(out_black) + v*(float64(out_white)-float64(out_black)))
+	}
+
+	return curve
+}
+
+// Create tone curve through control points, with monotone cubic interpolation.
+//
+// Monotone interpolation never overshoots, so a rising curve never inverts tones.
+// Outside of first and last point the curve is flat.
+//
+// points: Control points as (input, output) in [0, 255], at least two with distinct inputs.
+func PointCurve(points [][2]float64) (ToneCurve, error) {
+
+	sorted := append([][2]float64{}, points...)
+	sort.Slice(sorted, func(i int, j int) bool { return sorted[i][0] < sorted[j][0] })
+
+	if len(sorted) < 2 {
+		return ToneCurve{}, fmt.Errorf("%w: at least two points are required", ErrInvalidCurve)
+	}
+	for i, p := range sorted {
+		if p[0] < 0 || p[0] > 255 || p[1] < 0 || p[1] > 255 {
+			return ToneCurve{}, fmt.Errorf("%w: point %v is out of range [0, 255]", ErrInvalidCurve, p)
+		}
+		if i > 0 && p[0] == sorted[i-1][0] {
+			return ToneCurve{}, fmt.Errorf("%w: duplicate input %v", ErrInvalidCurve, p[0])
+		}
+	}
+
+	// Secant slopes, then tangents by Fritsch-Carlson.
+	n := len(sorted)
+	secants := make([]float64, n-1)
+	for i := range secants {
+		secants[i] = (sorted[i+1][1] - sorted[i][1]) / (sorted[i+1][0] - sorted[i][0])
+	}
+	tangents := make([]float64, n)
+	tangents[0], tangents[n-1] = secants[0], secants[n-2]
+	for i := 1; i < n-1; i++ {
+		if secants[i-1]*secants[i] <= 0 {
+			tangents[i] = 0 // Local extremum.
+		} else {
+			tangents[i] = (secants[i-1] + secants[i]) / 2
+		}
+	}
+	for i, secant := range secants {
+		if secant == 0 {
+			tangents[i], tangents[i+1] = 0, 0
+			continue
+		}
+		a, b := tangents[i]/secant, tangents[i+1]/secant
+		if h := a*a + b*b; h > 9 { // Limit tangents to keep monotonicity.
+			t := 3 / math.Sqrt(h)
+			tangents[i], tangents[i+1] = t*a*secant, t*b*secant
+		}
+	}
+
+	var curve ToneCurve
+	segment := 0
+	for i := range curve {
+		x := float64(i)
+		switch {
+		case x <= sorted[0][0]:
+			curve[i] = clampUint8(sorted[0][1])
+		case x >= sorted[n-1][0]:
+			curve[i] = clampUint8(sorted[n-1][1])
+		default:
+			for x > sorted[segment+1][0] {
+				segment++
+			}
+			// Cubic Hermite spline on the segment.
+			x0, x1 := sorted[segment][0], sorted[segment+1][0]
+			y0, y1 := sorted[segment][1], sorted[segment+1][1]
+			h := x1 - x0
+			t := (x - x0) / h
+			h00 := 2*t*t*t - 3*t*t + 1
+			h10 := t*t*t - 2*t*t + t
+			h01 := -2*t*t*t + 3*t*t
+			h11 := t*t*t - t*t
+			curve[i] = clampUint8(h00*y0 + h10*h*tangents[segment] + h01*y1 + h11*h*tangents[segment+1])
+		}
+	}
+
+	return curve, nil
+}
+
+// Apply tone curves to color channels, alpha channel is kept.
+func ApplyToneCurves(red ToneCurve, green ToneCurve, blue ToneCurve) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		return applyToneCurves(src, &red, &green, &blue), nil
+	})
+}
+
+// Apply tone curves to a copy of NRGBA image.
+func applyToneCurves(src *image.NRGBA, red *ToneCurve, green *ToneCurve, blue *ToneCurve) *image.NRGBA {
+
+	dst := image.NewNRGBA(src.Rect)
+	width := src.Rect.Dx()
+
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		si, di := src.PixOffset(src.Rect.Min.X, y), dst.PixOffset(dst.Rect.Min.X, y)
+		for x := 0; x < width*4; x += 4 {
+			dst.Pix[di+x] = red[src.Pix[si+x]]
+			dst.Pix[di+x+1] = green[src.Pix[si+x+1]]
+			dst.Pix[di+x+2] = blue[src.Pix[si+x+2]]
+			dst.Pix[di+x+3] = src.Pix[si+x+3]
+		}
+	}
+
+	return dst
+}
+
+// Map color channels of every pixel, alpha channel is kept.
+//
+// convert: Color mapping, e.g. color profile conversion.
+func ConvertColors(convert func(r uint8, g uint8, b uint8) (uint8, uint8, uint8)) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		dst := image.NewNRGBA(src.Rect)
+		width := src.Rect.Dx()
+
+		for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+			si, di := src.PixOffset(src.Rect.Min.X, y), dst.PixOffset(dst.Rect.Min.X, y)
+			for x := 0; x < width*4; x += 4 {
+				dst.Pix[di+x], dst.Pix[di+x+1], dst.Pix[di+x+2] = convert(src.Pix[si+x], src.Pix[si+x+1], src.Pix[si+x+2])
+				dst.Pix[di+x+3] = src.Pix[si+x+3]
+			}
+		}
+
+		return dst, nil
+	})
+}
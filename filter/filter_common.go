@@ -0,0 +1,98 @@
+// Description: Pixel filters applied on decoded images, and helpers shared by all filters.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrNoImage      = errors.New("image not decoded, the pipeline needs a decode block first")
+	ErrInvalidColor = errors.New("invalid color")
+)
+
+// Pixel filter, takes decoded image and returns processed image.
+//
+// Filters never modify input image in place.
+type Filter func(image.Image) (image.Image, error)
+
+// Create filter working on NRGBA image, the working format of all filters.
+//
+// fn: Filter body, receives input image converted to NRGBA with origin at (0, 0).
+func newFilter(fn func(*image.NRGBA) (*image.NRGBA, error)) Filter {
+	return func(img image.Image) (image.Image, error) {
+		if img == nil {
+			return nil, ErrNoImage
+		}
+		return fn(toNRGBA(img))
+	}
+}
+
+// Convert image to NRGBA with origin at (0, 0).
+//
+// If the image is already in that form, it is returned as is.
+func toNRGBA(img image.Image) *image.NRGBA {
+
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Rect, img, bounds.Min, draw.Src)
+
+	return nrgba
+}
+
+// Parse color string.
+//
+// Supported forms: `#RGB`, `#RRGGBB`, `#RRGGBBAA`, `white`, `black` and `transparent`.
+// Empty string is treated as transparent.
+func ParseColor(s string) (color.NRGBA, error) {
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "", "transparent":
+		return color.NRGBA{}, nil
+	case "white":
+		return color.NRGBA{255, 255, 255, 255}, nil
+	case "black":
+		return color.NRGBA{0, 0, 0, 255}, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 { // Short form, each digit is repeated.
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff" // Opaque.
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("%w: '%s'", ErrInvalidColor, s)
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("%w: '%s'", ErrInvalidColor, s)
+	}
+
+	return color.NRGBA{uint8(value >> 24), uint8(value >> 16), uint8(value >> 8), uint8(value)}, nil
+}
+
+// Clamp float value to 8-bit channel.
+func clampUint8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
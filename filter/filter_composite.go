@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Blend modes of composited layers.
+const (
+	BlendNormal     = "normal"     // Layer covers the image.
+	BlendMultiply   = "multiply"   // Darkens, white is neutral.
+	BlendScreen     = "screen"     // Lightens, black is neutral.
+	BlendOverlay    = "overlay"    // Multiply on dark areas, screen on light areas.
+	BlendDarken     = "darken"     // Darker of both.
+	BlendLighten    = "lighten"    // Lighter of both.
+	BlendDifference = "difference" // Absolute difference.
+)
+
+// Blend functions, keyed by blend mode.
+//
+// b: Backdrop (image) channel, s: Source (layer) channel, both in [0, 1].
+var blendFunctions = map[string]func(b float64, s float64) float64{
+	BlendNormal:   func(b float64, s float64) float64 { return s },
+	BlendMultiply: func(b float64, s float64) float64 { return b * s },
+	BlendScreen:   func(b float64, s float64) float64 { return b + s - b*s },
+	BlendOverlay: func(b float64, s float64) float64 {
+		if b <= 0.5 {
+			return 2 * b * s
+		}
+		return 1 - 2*(1-b)*(1-s)
+	},
+	BlendDarken:     math.Min,
+	BlendLighten:    math.Max,
+	BlendDifference: func(b float64, s float64) float64 { return math.Abs(b - s) },
+}
+
+// Check if the blend mode is supported, empty mode is `normal`.
+func IsBlendMode(mode string) bool {
+	_, ok := blendFunctions[strings.ToLower(mode)]
+	return ok || mode == ""
+}
+
+// Composite layer onto image at given positions.
+//
+// Layer pixels outside of image are ignored, the image keeps its size.
+//
+// layer: Layer to composite, e.g. watermark.
+// positions: Top-left corners of layer copies, may be negative.
+// opacity: Layer opacity in [0, 1].
+// mode: Blend mode, see `IsBlendMode`.
+func Composite(layer image.Image, positions []image.Point, opacity float64, mode string) Filter {
+
+	blend, ok := blendFunctions[strings.ToLower(mode)]
+	if mode == "" {
+		blend, ok = blendFunctions[BlendNormal], true
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		if !ok {
+			return nil, fmt.Errorf("unknown blend mode '%s'", mode)
+		}
+
+		top := toNRGBA(layer)
+		dst := image.NewNRGBA(src.Rect)
+		copy(dst.Pix, src.Pix)
+
+		for _, position := range positions {
+			area := top.Rect.Add(position).Intersect(dst.Rect)
+			for y := area.Min.Y; y < area.Max.Y; y++ {
+				for x := area.Min.X; x < area.Max.X; x++ {
+					si := top.PixOffset(x-position.X, y-position.Y)
+					source_alpha := float64(top.Pix[si+3]) / 255 * opacity
+					if source_alpha <= 0 {
+						continue
+					}
+
+					di := dst.PixOffset(x, y)
+					backdrop_alpha := float64(dst.Pix[di+3]) / 255
+					alpha := source_alpha + backdrop_alpha*(1-source_alpha)
+
+					// W3C compositing: blend where both exist, then source-over.
+					for ch := 0; ch < 3; ch++ {
+						b := float64(dst.Pix[di+ch]) / 255
+						s := float64(top.Pix[si+ch]) / 255
+						mixed := (1-backdrop_alpha)*s + backdrop_alpha*blend(b, s)
+						premultiplied := source_alpha*mixed + backdrop_alpha*b*(1-source_alpha)
+						dst.Pix[di+ch] = clampUint8(premultiplied / alpha * 255)
+					}
+					dst.Pix[di+3] = clampUint8(alpha * 255)
+				}
+			}
+		}
+
+		return dst, nil
+	})
+}
@@ -0,0 +1,395 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Blur types.
+const (
+	BlurGaussian = "gaussian" // Gaussian blur, radius is the standard deviation. Default.
+	BlurBox      = "box"      // Box blur, averages pixels within radius. Cheaper, with harder edges.
+)
+
+// Edge modes, how pixels outside of the image are sampled.
+const (
+	EdgeClamp  = "clamp"  // Repeat edge pixels. Default.
+	EdgeWrap   = "wrap"   // Continue from the opposite edge, for tiles.
+	EdgeMirror = "mirror" // Reflect the image at its edges.
+)
+
+// Check if the blur type is supported, empty type is `gaussian`.
+func IsBlurType(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "", BlurGaussian, BlurBox:
+		return true
+	}
+	return false
+}
+
+// Check if the edge mode is supported, empty mode is `clamp`.
+func IsEdgeMode(edge string) bool {
+	switch strings.ToLower(edge) {
+	case "", EdgeClamp, EdgeWrap, EdgeMirror:
+		return true
+	}
+	return false
+}
+
+// Create normalized 1D blur kernel, centered, with odd length.
+//
+// kind: Blur type, see `IsBlurType`.
+// radius: Blur radius in pixels, must be positive.
+func blurKernel(kind string, radius float64) ([]float64, error) {
+
+	if radius <= 0 || math.IsNaN(radius) || math.IsInf(radius, 0) {
+		return nil, fmt.Errorf("blur radius must be positive, got %v", radius)
+	}
+
+	switch strings.ToLower(kind) {
+	case "", BlurGaussian:
+		return gaussianKernel(radius), nil
+	case BlurBox:
+		return boxKernel(max(1, int(math.Round(radius)))), nil
+	}
+
+	return nil, fmt.Errorf("unknown blur type '%s'", kind)
+}
+
+// Create normalized gaussian kernel, truncated at three standard deviations.
+func gaussianKernel(sigma float64) []float64 {
+
+	extent := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, extent*2+1)
+
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - extent)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return kernel
+}
+
+// Create normalized box kernel covering `radius` pixels on each side.
+func boxKernel(radius int) []float64 {
+	kernel := make([]float64, radius*2+1)
+	for i := range kernel {
+		kernel[i] = 1 / float64(len(kernel))
+	}
+	return kernel
+}
+
+// Run function over row ranges of given height in parallel, one range per CPU.
+func parallelRows(height int, fn func(start int, end int)) {
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), height))
+	rows := (height + workers - 1) / workers
+
+	wg := sync.WaitGroup{}
+	for start := 0; start < height; start += rows {
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, min(height, start+rows))
+	}
+	wg.Wait()
+}
+
+// Map positions from `-extent` to `size + extent - 1` onto pixel indices, by edge mode.
+//
+// Index of position `p` is stored at `p + extent`.
+func edgeIndices(size int, extent int, edge string) []int {
+
+	indices := make([]int, size+extent*2)
+	for i := range indices {
+		p := i - extent
+		switch strings.ToLower(edge) {
+		case EdgeWrap:
+			p = ((p % size) + size) % size
+		case EdgeMirror:
+			if size == 1 {
+				p = 0
+				break
+			}
+			period := (size - 1) * 2 // Reflection without repeating edge pixel.
+			p = ((p % period) + period) % period
+			if p >= size {
+				p = period - p
+			}
+		default:
+			p = min(max(p, 0), size-1)
+		}
+		indices[i] = p
+	}
+
+	return indices
+}
+
+// Convolve premultiplied buffer with separable kernel, horizontal pass first.
+//
+// Both passes are parallelized over rows.
+//
+// horizontal, vertical: Centered 1D kernels with odd length.
+// edge: Edge mode, see `IsEdgeMode`.
+func convolveBuffer(src *premultipliedBuffer, horizontal []float64, vertical []float64, edge string) *premultipliedBuffer {
+
+	width, height := src.width, src.height
+
+	// Horizontal pass.
+	temp := newPremultipliedBuffer(width, height)
+	columns := edgeIndices(width, len(horizontal)/2, edge)
+	parallelRows(height, func(start int, end int) {
+		for y := start; y < end; y++ {
+			row := src.pix[y*width*4 : (y+1)*width*4]
+			for x := 0; x < width; x++ {
+				var sum [4]float64
+				for k, w := range horizontal {
+					si := columns[x+k] * 4
+					sum[0] += row[si] * w
+					sum[1] += row[si+1] * w
+					sum[2] += row[si+2] * w
+					sum[3] += row[si+3] * w
+				}
+				copy(temp.pix[(y*width+x)*4:], sum[:])
+			}
+		}
+	})
+
+	// Vertical pass, accumulated row by row to stay cache friendly.
+	dst := newPremultipliedBuffer(width, height)
+	rows := edgeIndices(height, len(vertical)/2, edge)
+	parallelRows(height, func(start int, end int) {
+		for y := start; y < end; y++ {
+			row := dst.pix[y*width*4 : (y+1)*width*4]
+			for k, w := range vertical {
+				sy := rows[y+k]
+				source := temp.pix[sy*width*4 : (sy+1)*width*4]
+				for i, v := range source {
+					row[i] += v * w
+				}
+			}
+		}
+	})
+
+	return dst
+}
+
+// Convolve premultiplied buffer with 2D kernel, parallelized over rows.
+//
+// Separable kernels, e.g. gaussian or box, take the faster path of `convolveBuffer`.
+//
+// kernel: Kernel rows, centered, with odd width and height.
+// edge: Edge mode, see `IsEdgeMode`.
+func convolveMatrix(src *premultipliedBuffer, kernel [][]float64, edge string) *premultipliedBuffer {
+
+	if horizontal, vertical, ok := separateKernel(kernel); ok {
+		return convolveBuffer(src, horizontal, vertical, edge)
+	}
+
+	width, height := src.width, src.height
+	columns := edgeIndices(width, len(kernel[0])/2, edge)
+	rows := edgeIndices(height, len(kernel)/2, edge)
+
+	dst := newPremultipliedBuffer(width, height)
+	parallelRows(height, func(start int, end int) {
+		for y := start; y < end; y++ {
+			row := dst.pix[y*width*4 : (y+1)*width*4]
+			for ky, weights := range kernel {
+				sy := rows[y+ky]
+				source := src.pix[sy*width*4 : (sy+1)*width*4]
+				for kx, w := range weights {
+					if w == 0 {
+						continue
+					}
+					for x := 0; x < width; x++ {
+						si, di := columns[x+kx]*4, x*4
+						row[di] += source[si] * w
+						row[di+1] += source[si+1] * w
+						row[di+2] += source[si+2] * w
+						row[di+3] += source[si+3] * w
+					}
+				}
+			}
+		}
+	})
+
+	return dst
+}
+
+// Split 2D kernel into horizontal and vertical 1D kernels, false if the kernel is not separable.
+func separateKernel(kernel [][]float64) ([]float64, []float64, bool) {
+
+	// Largest weight as pivot, its row and column span the kernel if separable.
+	pivot_y, pivot_x := 0, 0
+	for y, weights := range kernel {
+		for x, w := range weights {
+			if math.Abs(w) > math.Abs(kernel[pivot_y][pivot_x]) {
+				pivot_y, pivot_x = y, x
+			}
+		}
+	}
+	pivot := kernel[pivot_y][pivot_x]
+	if pivot == 0 {
+		return nil, nil, false
+	}
+
+	horizontal := make([]float64, len(kernel[0]))
+	vertical := make([]float64, len(kernel))
+	for x := range horizontal {
+		horizontal[x] = kernel[pivot_y][x] / pivot
+	}
+	for y := range vertical {
+		vertical[y] = kernel[y][pivot_x]
+	}
+
+	for y, weights := range kernel {
+		for x, w := range weights {
+			if math.Abs(vertical[y]*horizontal[x]-w) > 1e-9*math.Abs(pivot) {
+				return nil, nil, false
+			}
+		}
+	}
+
+	return horizontal, vertical, true
+}
+
+// Convolve image with arbitrary kernel, e.g. emboss or edge detection. Alpha channel is kept.
+//
+// Colors are weighted by alpha, so transparent pixels do not bleed into their neighbours.
+//
+// kernel: Kernel rows, centered, with odd width and height.
+// divisor: Weighted sum is divided by it. Zero uses sum of kernel, or 1 if the kernel sums up to zero.
+// bias: Added to result in channel levels, e.g. 128 for mid gray base of emboss.
+// edge: Edge mode, see `IsEdgeMode`.
+func Convolve(kernel [][]float64, divisor float64, bias float64, edge string) Filter {
+
+	err := checkKernel(kernel)
+	if err == nil && !IsEdgeMode(edge) {
+		err = fmt.Errorf("unknown edge mode '%s'", edge)
+	}
+	if err != nil {
+		return func(image.Image) (image.Image, error) { return nil, err }
+	}
+
+	if divisor == 0 {
+		for _, weights := range kernel {
+			for _, w := range weights {
+				divisor += w
+			}
+		}
+		if math.Abs(divisor) < 1e-9 {
+			divisor = 1
+		}
+	}
+
+	// Divisor is folded into the kernel.
+	scaled := make([][]float64, len(kernel))
+	for y, weights := range kernel {
+		scaled[y] = make([]float64, len(weights))
+		for x, w := range weights {
+			scaled[y][x] = w / divisor
+		}
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		buffer := toPremultipliedBuffer(src, false)
+		dst := convolveMatrix(buffer, scaled, edge)
+		for i := 0; i < len(dst.pix); i += 4 {
+			a := buffer.pix[i+3]
+			for ch := 0; ch < 3; ch++ {
+				dst.pix[i+ch] += bias / 255 * a
+			}
+			dst.pix[i+3] = a
+		}
+
+		return dst.toNRGBA(false), nil
+	})
+}
+
+// Check if kernel has odd width and height, with rows of equal length.
+func checkKernel(kernel [][]float64) error {
+
+	if len(kernel)%2 == 0 || len(kernel[0])%2 == 0 {
+		return fmt.Errorf("kernel size must be odd, got %d rows", len(kernel))
+	}
+	for _, weights := range kernel {
+		if len(weights) != len(kernel[0]) {
+			return fmt.Errorf("kernel rows must have equal length, got %d and %d", len(kernel[0]), len(weights))
+		}
+		for _, w := range weights {
+			if math.IsNaN(w) || math.IsInf(w, 0) {
+				return fmt.Errorf("kernel weight must be finite, got %v", w)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Blur image, transparent pixels do not bleed into their neighbours.
+//
+// kind: Blur type, see `IsBlurType`.
+// radius: Blur radius in pixels. Standard deviation of gaussian blur, or half width of box blur.
+func Blur(kind string, radius float64) Filter {
+
+	kernel, err := blurKernel(kind, radius)
+	if err != nil {
+		return func(image.Image) (image.Image, error) { return nil, err }
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		return convolveBuffer(toPremultipliedBuffer(src, false), kernel, kernel, EdgeClamp).toNRGBA(false), nil
+	})
+}
+
+// Sharpen image with unsharp mask, alpha channel is kept.
+//
+// Difference between image and its gaussian blurred copy is amplified, which raises local contrast on edges.
+//
+// radius: Gaussian blur radius in pixels, about the width of enhanced edges.
+// amount: Strength of sharpening, 1 adds the full difference.
+// threshold: Smallest channel difference in [0, 255] to sharpen, higher values leave smooth areas and noise alone.
+func Sharpen(radius float64, amount float64, threshold int) Filter {
+
+	kernel, err := blurKernel(BlurGaussian, radius)
+	if err != nil {
+		return func(image.Image) (image.Image, error) { return nil, err }
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		blurred := convolveBuffer(toPremultipliedBuffer(src, false), kernel, kernel, EdgeClamp).toNRGBA(false)
+		dst := image.NewNRGBA(src.Rect)
+		width := src.Rect.Dx()
+
+		parallelRows(src.Rect.Dy(), func(start int, end int) {
+			for y := start; y < end; y++ {
+				si, bi, di := src.PixOffset(0, y), blurred.PixOffset(0, y), dst.PixOffset(0, y)
+				for x := 0; x < width*4; x += 4 {
+					for ch := 0; ch < 3; ch++ {
+						v := float64(src.Pix[si+x+ch])
+						diff := v - float64(blurred.Pix[bi+x+ch])
+						if math.Abs(diff) >= float64(threshold) {
+							v += diff * amount
+						}
+						dst.Pix[di+x+ch] = clampUint8(v)
+					}
+					dst.Pix[di+x+3] = src.Pix[si+x+3]
+				}
+			}
+		})
+
+		return dst, nil
+	})
+}
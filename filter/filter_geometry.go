@@ -0,0 +1,397 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Flip directions.
+const (
+	FlipHorizontal = "horizontal" // Mirror left and right.
+	FlipVertical   = "vertical"   // Mirror top and bottom.
+	FlipBoth       = "both"       // Mirror both, same as rotating 180 degrees.
+)
+
+// Anchor positions, shared by blocks placing an image relative to another.
+const (
+	AnchorCenter      = "center"
+	AnchorTop         = "top"
+	AnchorBottom      = "bottom"
+	AnchorLeft        = "left"
+	AnchorRight       = "right"
+	AnchorTopLeft     = "topleft"
+	AnchorTopRight    = "topright"
+	AnchorBottomLeft  = "bottomleft"
+	AnchorBottomRight = "bottomright"
+)
+
+// Get relative position of anchor, (0, 0) is top-left and (1, 1) is bottom-right.
+//
+// Empty anchor is treated as `center`. Returns false if anchor is unknown.
+func AnchorPosition(anchor string) (float64, float64, bool) {
+	switch strings.ToLower(anchor) {
+	case "", AnchorCenter:
+		return 0.5, 0.5, true
+	case AnchorTop:
+		return 0.5, 0, true
+	case AnchorBottom:
+		return 0.5, 1, true
+	case AnchorLeft:
+		return 0, 0.5, true
+	case AnchorRight:
+		return 1, 0.5, true
+	case AnchorTopLeft:
+		return 0, 0, true
+	case AnchorTopRight:
+		return 1, 0, true
+	case AnchorBottomLeft:
+		return 0, 1, true
+	case AnchorBottomRight:
+		return 1, 1, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// Compute top-left corner of an item placed inside a container at anchor.
+//
+// Coordinates are negative if the item is larger than container.
+//
+// anchor: Anchor position, see `AnchorPosition`.
+// container, item: Sizes of container and item.
+func AnchorOffset(anchor string, container image.Point, item image.Point) image.Point {
+	fx, fy, _ := AnchorPosition(anchor)
+	return image.Pt(
+		int(math.Round(float64(container.X-item.X)*fx)),
+		int(math.Round(float64(container.Y-item.Y)*fy)),
+	)
+}
+
+// Rotate image clockwise.
+//
+// Multiples of 90 degrees are lossless. Other angles expand the canvas to fit the rotated image,
+// and uncovered area is filled with background color.
+//
+// angle: Rotation angle in degrees, clockwise.
+// background: Background color for uncovered area.
+func Rotate(angle float64, background color.NRGBA) Filter {
+
+	// Normalize angle into [0, 360).
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		switch angle {
+		case 0:
+			return src, nil
+		case 90:
+			return rotate90(src), nil
+		case 180:
+			return rotate180(src), nil
+		case 270:
+			return rotate270(src), nil
+		default:
+			return rotateArbitrary(src, angle, background), nil
+		}
+	})
+}
+
+// Compute canvas size of image rotated by `Rotate`.
+//
+// width, height: Image size before rotation.
+// angle: Rotation angle in degrees, clockwise.
+func RotatedSize(width int, height int, angle float64) (int, int) {
+
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	w, h := float64(width), float64(height)
+
+	// Bounding box of rotated image, tolerance avoids an extra pixel from rounding error.
+	rotated_w := int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - 1e-9))
+	rotated_h := int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - 1e-9))
+
+	return rotated_w, rotated_h
+}
+
+// Crop image to rectangle.
+//
+// The rectangle is clipped to image bounds.
+//
+// x, y: Top-left corner of crop rectangle.
+// width, height: Size of crop rectangle.
+func Crop(x int, y int, width int, height int) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		rect := image.Rect(x, y, x+width, y+height).Intersect(src.Rect)
+		if rect.Empty() {
+			return nil, fmt.Errorf("crop rectangle %v is outside of image %v", image.Rect(x, y, x+width, y+height), src.Rect)
+		}
+		return remapPixels(src, rect.Dx(), rect.Dy(), func(dx int, dy int) (int, int) {
+			return rect.Min.X + dx, rect.Min.Y + dy
+		}), nil
+	})
+}
+
+// Remove uniform borders.
+//
+// Image without any content, i.e. entirely border, is returned unchanged.
+//
+// tolerance: Maximum channel difference to border color, in percent.
+// padding: Pixels of the border kept around content, limited by image bounds.
+// background: Border color, nil to use the top-left pixel.
+func Trim(tolerance float64, padding int, background *color.NRGBA) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		border := src.NRGBAAt(0, 0)
+		if background != nil {
+			border = *background
+		}
+
+		content := TrimBounds(src, border, tolerance)
+		if content.Empty() {
+			return src, nil
+		}
+
+		rect := content.Inset(-padding).Intersect(src.Rect)
+		return remapPixels(src, rect.Dx(), rect.Dy(), func(x int, y int) (int, int) {
+			return rect.Min.X + x, rect.Min.Y + y
+		}), nil
+	})
+}
+
+// Find bounds of content differing from border color.
+//
+// Returns empty rectangle if every pixel matches the border.
+//
+// tolerance: Maximum channel difference to border color, in percent.
+func TrimBounds(src *image.NRGBA, border color.NRGBA, tolerance float64) image.Rectangle {
+
+	limit := int(math.Round(tolerance * 255 / 100))
+	matches := func(x int, y int) bool {
+		c := src.NRGBAAt(x, y)
+		if int(c.A) <= limit && int(border.A) <= limit {
+			return true // Both (nearly) transparent, color channels don't matter.
+		}
+		for _, d := range [4]int{int(c.R) - int(border.R), int(c.G) - int(border.G), int(c.B) - int(border.B), int(c.A) - int(border.A)} {
+			if d > limit || -d > limit {
+				return false
+			}
+		}
+		return true
+	}
+
+	row_has_content := func(y int) bool {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			if !matches(x, y) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Scan inwards from each edge, content is usually found long before the center.
+	top, bottom := src.Rect.Min.Y, src.Rect.Max.Y
+	for top < bottom && !row_has_content(top) {
+		top++
+	}
+	if top == bottom {
+		return image.Rectangle{}
+	}
+	for !row_has_content(bottom - 1) {
+		bottom--
+	}
+
+	left, right := src.Rect.Max.X, src.Rect.Min.X
+	for y := top; y < bottom; y++ {
+		for x := src.Rect.Min.X; x < left; x++ {
+			if !matches(x, y) {
+				left = x
+				break
+			}
+		}
+		for x := src.Rect.Max.X - 1; x >= right; x-- {
+			if !matches(x, y) {
+				right = x + 1
+				break
+			}
+		}
+	}
+
+	content := image.Rect(left, top, right, bottom)
+	return content
+}
+
+// Flip image.
+//
+// direction: One of `horizontal`, `vertical` or `both`.
+func Flip(direction string) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		switch direction {
+		case FlipHorizontal:
+			return flipHorizontal(src), nil
+		case FlipVertical:
+			return flipVertical(src), nil
+		case FlipBoth:
+			return rotate180(src), nil
+		default:
+			return src, nil
+		}
+	})
+}
+
+// Transform image according to EXIF orientation, so it displays upright.
+//
+// orientation: EXIF orientation value, 1 to 8. Other values leave the image untouched.
+func Orient(orientation int) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+		switch orientation {
+		case 2:
+			return flipHorizontal(src), nil
+		case 3:
+			return rotate180(src), nil
+		case 4:
+			return flipVertical(src), nil
+		case 5: // Transpose.
+			return flipHorizontal(rotate90(src)), nil
+		case 6:
+			return rotate90(src), nil
+		case 7: // Transverse.
+			return flipVertical(rotate90(src)), nil
+		case 8:
+			return rotate270(src), nil
+		default:
+			return src, nil
+		}
+	})
+}
+
+// Remap every pixel of source image, `mapping` returns source coordinate of destination pixel.
+func remapPixels(src *image.NRGBA, width int, height int, mapping func(x int, y int) (int, int)) *image.NRGBA {
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := mapping(x, y)
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+
+	return dst
+}
+
+// Rotate 90 degrees clockwise.
+func rotate90(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remapPixels(src, h, w, func(x int, y int) (int, int) { return y, h - 1 - x })
+}
+
+// Rotate 180 degrees.
+func rotate180(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remapPixels(src, w, h, func(x int, y int) (int, int) { return w - 1 - x, h - 1 - y })
+}
+
+// Rotate 270 degrees clockwise.
+func rotate270(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remapPixels(src, h, w, func(x int, y int) (int, int) { return w - 1 - y, x })
+}
+
+// Mirror left and right.
+func flipHorizontal(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remapPixels(src, w, h, func(x int, y int) (int, int) { return w - 1 - x, y })
+}
+
+// Mirror top and bottom.
+func flipVertical(src *image.NRGBA) *image.NRGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	return remapPixels(src, w, h, func(x int, y int) (int, int) { return x, h - 1 - y })
+}
+
+// Rotate by arbitrary angle with bilinear sampling, canvas is expanded to fit.
+func rotateArbitrary(src *image.NRGBA, angle float64, background color.NRGBA) *image.NRGBA {
+
+	w, h := float64(src.Rect.Dx()), float64(src.Rect.Dy())
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+
+	dst_w, dst_h := RotatedSize(src.Rect.Dx(), src.Rect.Dy(), angle)
+	dst := image.NewNRGBA(image.Rect(0, 0, dst_w, dst_h))
+
+	// Background is premultiplied, so edges blend correctly.
+	bg := premultiply(background)
+
+	src_cx, src_cy := w/2, h/2
+	dst_cx, dst_cy := float64(dst_w)/2, float64(dst_h)/2
+
+	for y := 0; y < dst_h; y++ {
+		for x := 0; x < dst_w; x++ {
+			// Inverse rotation from destination pixel center to source.
+			dx, dy := float64(x)+0.5-dst_cx, float64(y)+0.5-dst_cy
+			sx := dx*cos + dy*sin + src_cx - 0.5
+			sy := -dx*sin + dy*cos + src_cy - 0.5
+
+			setPremultiplied(dst, x, y, sampleBilinear(src, sx, sy, bg))
+		}
+	}
+
+	return dst
+}
+
+// Premultiplied color in float, channels are in [0, 255].
+type premultipliedColor [4]float64
+
+// Premultiply NRGBA color.
+func premultiply(c color.NRGBA) premultipliedColor {
+	a := float64(c.A) / 255
+	return premultipliedColor{float64(c.R) * a, float64(c.G) * a, float64(c.B) * a, float64(c.A)}
+}
+
+// Get premultiplied pixel, coordinates outside of image return `outside`.
+func premultipliedAt(src *image.NRGBA, x int, y int, outside premultipliedColor) premultipliedColor {
+	if x < 0 || y < 0 || x >= src.Rect.Dx() || y >= src.Rect.Dy() {
+		return outside
+	}
+	i := src.PixOffset(x, y)
+	return premultiply(color.NRGBA{src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3]})
+}
+
+// Write premultiplied color into NRGBA image.
+func setPremultiplied(dst *image.NRGBA, x int, y int, c premultipliedColor) {
+	i := dst.PixOffset(x, y)
+	if c[3] <= 0 {
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = 0, 0, 0, 0
+		return
+	}
+	a := c[3] / 255
+	dst.Pix[i] = clampUint8(c[0] / a)
+	dst.Pix[i+1] = clampUint8(c[1] / a)
+	dst.Pix[i+2] = clampUint8(c[2] / a)
+	dst.Pix[i+3] = clampUint8(c[3])
+}
+
+// Sample image at fractional coordinate with bilinear interpolation in premultiplied space.
+func sampleBilinear(src *image.NRGBA, x float64, y float64, outside premultipliedColor) premultipliedColor {
+
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	c00 := premultipliedAt(src, x0, y0, outside)
+	c10 := premultipliedAt(src, x0+1, y0, outside)
+	c01 := premultipliedAt(src, x0, y0+1, outside)
+	c11 := premultipliedAt(src, x0+1, y0+1, outside)
+
+	var result premultipliedColor
+	for i := 0; i < 4; i++ {
+		top := c00[i]*(1-fx) + c10[i]*fx
+		bottom := c01[i]*(1-fx) + c11[i]*fx
+		result[i] = top*(1-fy) + bottom*fy
+	}
+
+	return result
+}
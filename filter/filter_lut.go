@@ -0,0 +1,269 @@
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var ErrInvalidLUT = errors.New("invalid 3D LUT")
+
+// LUT interpolation methods.
+const (
+	InterpolationTrilinear   = "trilinear"   // Blend of 8 surrounding lattice points.
+	InterpolationTetrahedral = "tetrahedral" // Blend of 4 lattice points, keeps neutral axis exact, default.
+)
+
+// Largest supported LUT size, a 256 point lattice would take half a gigabyte.
+const maxLUTSize = 256
+
+// 3D color lookup table.
+//
+// Size: Lattice points per axis.
+//
+// Data: Output colors in [0, 1], 3 channels per point, red index changing fastest.
+//
+// DomainMin, DomainMax: Input range mapped to the lattice, [0, 1] for most LUTs.
+type LUT3D struct {
+	Size      int        // Lattice points per axis.
+	Data      []float64  // Output colors.
+	DomainMin [3]float64 // Input value at first lattice point.
+	DomainMax [3]float64 // Input value at last lattice point.
+}
+
+// Check if the interpolation method is supported, empty method is `tetrahedral`.
+func IsLUTInterpolation(method string) bool {
+	switch strings.ToLower(method) {
+	case "", InterpolationTrilinear, InterpolationTetrahedral:
+		return true
+	}
+	return false
+}
+
+// Parse LUT in Adobe / Resolve `.cube` format.
+func ParseCubeLUT(r io.Reader) (*LUT3D, error) {
+
+	lut := &LUT3D{DomainMax: [3]float64{1, 1, 1}}
+	scanner := bufio.NewScanner(r)
+
+	for line_number := 1; scanner.Scan(); line_number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "TITLE":
+			// Ignored.
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("%w: 1D LUT is not supported", ErrInvalidLUT)
+		case "LUT_3D_SIZE":
+			if lut.Size != 0 {
+				return nil, fmt.Errorf("%w: repeated LUT_3D_SIZE on line %d", ErrInvalidLUT, line_number)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 2 || size > maxLUTSize {
+				return nil, fmt.Errorf("%w: invalid size on line %d", ErrInvalidLUT, line_number)
+			}
+			lut.Size = size
+			lut.Data = make([]float64, 0, size*size*size*3)
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			values, err := parseFloats(fields[1:])
+			if err != nil || len(values) != 3 {
+				return nil, fmt.Errorf("%w: invalid domain on line %d", ErrInvalidLUT, line_number)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				copy(lut.DomainMin[:], values)
+			} else {
+				copy(lut.DomainMax[:], values)
+			}
+		case "LUT_3D_INPUT_RANGE", "LUT_1D_INPUT_RANGE": // Resolve form of domain, same range for all channels.
+			values, err := parseFloats(fields[1:])
+			if err != nil || len(values) != 2 {
+				return nil, fmt.Errorf("%w: invalid input range on line %d", ErrInvalidLUT, line_number)
+			}
+			if fields[0] == "LUT_3D_INPUT_RANGE" { // 1D tables are rejected, their range only needs to be valid.
+				lut.DomainMin = [3]float64{values[0], values[0], values[0]}
+				lut.DomainMax = [3]float64{values[1], values[1], values[1]}
+			}
+		default:
+			values, err := parseFloats(fields)
+			if err != nil || len(values) != 3 {
+				return nil, fmt.Errorf("%w: unexpected content on line %d", ErrInvalidLUT, line_number)
+			}
+			if lut.Size == 0 {
+				return nil, fmt.Errorf("%w: data before LUT_3D_SIZE on line %d", ErrInvalidLUT, line_number)
+			}
+			lut.Data = append(lut.Data, values...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.Size == 0 || len(lut.Data) != lut.Size*lut.Size*lut.Size*3 {
+		return nil, fmt.Errorf("%w: expected %d entries, got %d", ErrInvalidLUT, lut.Size*lut.Size*lut.Size, len(lut.Data)/3)
+	}
+	for ch := 0; ch < 3; ch++ {
+		if lut.DomainMax[ch] <= lut.DomainMin[ch] {
+			return nil, fmt.Errorf("%w: empty domain", ErrInvalidLUT)
+		}
+	}
+
+	return lut, nil
+}
+
+// Parse list of float values.
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Create LUT from Hald CLUT image.
+//
+// Hald image of level L is L^3 pixels square and holds a lattice of L^2 points per axis,
+// red changing fastest, in reading order.
+func HaldLUT(img image.Image) (*LUT3D, error) {
+
+	src := toNRGBA(img)
+	width := src.Rect.Dx()
+
+	level := int(math.Round(math.Cbrt(float64(width))))
+	if width != src.Rect.Dy() || level*level*level != width || level < 2 {
+		return nil, fmt.Errorf("%w: Hald image must be square with side of a cube number, got %v", ErrInvalidLUT, src.Rect.Size())
+	}
+
+	size := level * level
+	if size > maxLUTSize {
+		return nil, fmt.Errorf("%w: Hald level %d is too large", ErrInvalidLUT, level)
+	}
+
+	lut := &LUT3D{Size: size, Data: make([]float64, size*size*size*3), DomainMax: [3]float64{1, 1, 1}}
+	for i := 0; i < size*size*size; i++ {
+		si := src.PixOffset(i%width, i/width)
+		for ch := 0; ch < 3; ch++ {
+			lut.Data[i*3+ch] = float64(src.Pix[si+ch]) / 255
+		}
+	}
+
+	return lut, nil
+}
+
+// Look up lattice point.
+func (lut *LUT3D) at(r int, g int, b int) [3]float64 {
+	i := ((b*lut.Size+g)*lut.Size + r) * 3
+	return [3]float64{lut.Data[i], lut.Data[i+1], lut.Data[i+2]}
+}
+
+// Look up color with interpolation between lattice points.
+//
+// c: Input color, in the LUT domain.
+// tetrahedral: Use tetrahedral instead of trilinear interpolation.
+func (lut *LUT3D) lookup(c [3]float64, tetrahedral bool) [3]float64 {
+
+	// Lattice cell and position inside it.
+	var base [3]int
+	var frac [3]float64
+	for ch := 0; ch < 3; ch++ {
+		position := (c[ch] - lut.DomainMin[ch]) / (lut.DomainMax[ch] - lut.DomainMin[ch]) * float64(lut.Size-1)
+		position = math.Min(float64(lut.Size-1), math.Max(0, position))
+		base[ch] = min(int(position), lut.Size-2)
+		frac[ch] = position - float64(base[ch])
+	}
+
+	r, g, b := base[0], base[1], base[2]
+	fr, fg, fb := frac[0], frac[1], frac[2]
+	c000 := lut.at(r, g, b)
+	c111 := lut.at(r+1, g+1, b+1)
+
+	var result [3]float64
+	if tetrahedral {
+		// Pick the tetrahedron containing the point, by ordering of fractions.
+		var w0, w1, w2, w3 float64
+		var c1, c2 [3]float64
+		switch {
+		case fr >= fg && fg >= fb:
+			c1, c2 = lut.at(r+1, g, b), lut.at(r+1, g+1, b)
+			w0, w1, w2, w3 = 1-fr, fr-fg, fg-fb, fb
+		case fr >= fb && fb >= fg:
+			c1, c2 = lut.at(r+1, g, b), lut.at(r+1, g, b+1)
+			w0, w1, w2, w3 = 1-fr, fr-fb, fb-fg, fg
+		case fb >= fr && fr >= fg:
+			c1, c2 = lut.at(r, g, b+1), lut.at(r+1, g, b+1)
+			w0, w1, w2, w3 = 1-fb, fb-fr, fr-fg, fg
+		case fg >= fr && fr >= fb:
+			c1, c2 = lut.at(r, g+1, b), lut.at(r+1, g+1, b)
+			w0, w1, w2, w3 = 1-fg, fg-fr, fr-fb, fb
+		case fg >= fb && fb >= fr:
+			c1, c2 = lut.at(r, g+1, b), lut.at(r, g+1, b+1)
+			w0, w1, w2, w3 = 1-fg, fg-fb, fb-fr, fr
+		default: // fb >= fg >= fr
+			c1, c2 = lut.at(r, g, b+1), lut.at(r, g+1, b+1)
+			w0, w1, w2, w3 = 1-fb, fb-fg, fg-fr, fr
+		}
+		for ch := 0; ch < 3; ch++ {
+			result[ch] = w0*c000[ch] + w1*c1[ch] + w2*c2[ch] + w3*c111[ch]
+		}
+		return result
+	}
+
+	// Trilinear, along red, then green, then blue.
+	c100, c010, c110 := lut.at(r+1, g, b), lut.at(r, g+1, b), lut.at(r+1, g+1, b)
+	c001, c101, c011 := lut.at(r, g, b+1), lut.at(r+1, g, b+1), lut.at(r, g+1, b+1)
+	for ch := 0; ch < 3; ch++ {
+		c00 := c000[ch] + (c100[ch]-c000[ch])*fr
+		c10 := c010[ch] + (c110[ch]-c010[ch])*fr
+		c01 := c001[ch] + (c101[ch]-c001[ch])*fr
+		c11 := c011[ch] + (c111[ch]-c011[ch])*fr
+		c0 := c00 + (c10-c00)*fg
+		c1 := c01 + (c11-c01)*fg
+		result[ch] = c0 + (c1-c0)*fb
+	}
+	return result
+}
+
+// Apply 3D LUT to image, alpha channel is kept.
+//
+// lut: Lookup table, see `ParseCubeLUT` and `HaldLUT`.
+// interpolation: Interpolation method, see `IsLUTInterpolation`.
+// strength: Mix of graded and original color, 1 is the full LUT effect.
+func ApplyLUT(lut *LUT3D, interpolation string, strength float64) Filter {
+
+	tetrahedral := strings.ToLower(interpolation) != InterpolationTrilinear
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		if lut == nil || lut.Size < 2 {
+			return nil, ErrInvalidLUT
+		}
+
+		dst := image.NewNRGBA(src.Rect)
+		for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+			for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+				si, di := src.PixOffset(x, y), dst.PixOffset(x, y)
+				original := [3]float64{float64(src.Pix[si]) / 255, float64(src.Pix[si+1]) / 255, float64(src.Pix[si+2]) / 255}
+				graded := lut.lookup(original, tetrahedral)
+				for ch := 0; ch < 3; ch++ {
+					dst.Pix[di+ch] = clampUint8((original[ch] + (graded[ch]-original[ch])*strength) * 255)
+				}
+				dst.Pix[di+3] = src.Pix[si+3]
+			}
+		}
+
+		return dst, nil
+	})
+}
@@ -0,0 +1,502 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Quantization methods, how the palette is chosen.
+const (
+	QuantizeMedianCut = "median_cut" // Split color space at weighted medians. Default, fast and balanced.
+	QuantizeKMeans    = "kmeans"     // Refine median cut palette by k-means clustering. Slower, closest colors.
+	QuantizeOctree    = "octree"     // Merge rare colors in octree. Fast, keeps frequent colors exact.
+)
+
+// Dithering methods.
+const (
+	DitherNone           = "none"            // Map each pixel to the closest palette color. Default.
+	DitherFloydSteinberg = "floyd_steinberg" // Diffuse error to neighbouring pixels, smooth gradients.
+	DitherOrdered        = "ordered"         // Bayer matrix pattern, stable across frames and compresses well.
+)
+
+const kMeansIterations = 8 // Rounds of k-means refinement.
+
+// Check if the quantization method is supported, empty method is `median_cut`.
+func IsQuantizeMethod(method string) bool {
+	switch strings.ToLower(method) {
+	case "", QuantizeMedianCut, QuantizeKMeans, QuantizeOctree:
+		return true
+	}
+	return false
+}
+
+// Check if the dithering method is supported, empty method is `none`.
+func IsDitherMethod(dither string) bool {
+	switch strings.ToLower(dither) {
+	case "", DitherNone, DitherFloydSteinberg, DitherOrdered:
+		return true
+	}
+	return false
+}
+
+// Distinct color with its pixel count.
+type weightedColor struct {
+	c      [4]float64 // Red, green, blue and alpha.
+	weight float64    // Pixel count.
+}
+
+// Reduce image to palette of at most given number of colors. Result is paletted image.
+//
+// Fully transparent pixels share one palette entry.
+//
+// colors: Palette size in [2, 256].
+// method: Quantization method, see `IsQuantizeMethod`.
+// dither: Dithering method, see `IsDitherMethod`.
+func Quantize(colors int, method string, dither string) Filter {
+
+	var err error
+	switch {
+	case colors < 2 || colors > 256:
+		err = fmt.Errorf("palette size must be within 2 to 256, got %d", colors)
+	case !IsQuantizeMethod(method):
+		err = fmt.Errorf("unknown quantization method '%s'", method)
+	case !IsDitherMethod(dither):
+		err = fmt.Errorf("unknown dithering method '%s'", dither)
+	}
+
+	return func(img image.Image) (image.Image, error) {
+		if err != nil {
+			return nil, err
+		}
+		if img == nil {
+			return nil, ErrNoImage
+		}
+
+		src := toNRGBA(img)
+		histogram := colorHistogram(src)
+
+		var palette [][4]float64
+		switch {
+		case len(histogram) <= colors: // Nothing to reduce.
+			for _, wc := range histogram {
+				palette = append(palette, wc.c)
+			}
+		case strings.ToLower(method) == QuantizeKMeans:
+			palette = kMeans(histogram, medianCut(histogram, colors))
+		case strings.ToLower(method) == QuantizeOctree:
+			palette = octree(histogram, colors)
+		default:
+			palette = medianCut(histogram, colors)
+		}
+
+		return mapToPalette(src, palette, dither), nil
+	}
+}
+
+// Collect distinct colors of image, fully transparent pixels count as one color.
+//
+// Colors are sorted for deterministic results.
+func colorHistogram(src *image.NRGBA) []weightedColor {
+
+	counts := map[uint32]float64{}
+	for y := 0; y < src.Rect.Dy(); y++ {
+		row := src.Pix[src.PixOffset(0, y):]
+		for x := 0; x < src.Rect.Dx()*4; x += 4 {
+			key := uint32(0)
+			if row[x+3] != 0 {
+				key = uint32(row[x])<<24 | uint32(row[x+1])<<16 | uint32(row[x+2])<<8 | uint32(row[x+3])
+			}
+			counts[key]++
+		}
+	}
+
+	keys := make([]uint32, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i int, j int) bool { return keys[i] < keys[j] })
+
+	histogram := make([]weightedColor, len(keys))
+	for i, key := range keys {
+		histogram[i] = weightedColor{[4]float64{float64(key >> 24), float64(key >> 16 & 0xFF), float64(key >> 8 & 0xFF), float64(key & 0xFF)}, counts[key]}
+	}
+	return histogram
+}
+
+// Weighted mean of colors.
+func meanColor(colors []weightedColor) [4]float64 {
+	var sum [4]float64
+	total := 0.0
+	for _, wc := range colors {
+		for ch := range sum {
+			sum[ch] += wc.c[ch] * wc.weight
+		}
+		total += wc.weight
+	}
+	for ch := range sum {
+		sum[ch] /= total
+	}
+	return sum
+}
+
+// Choose palette by median cut.
+//
+// The box with the largest weighted channel range is split at the weighted median of that channel,
+// until there are enough boxes. Each box contributes its mean color.
+func medianCut(histogram []weightedColor, colors int) [][4]float64 {
+
+	type box struct {
+		colors  []weightedColor
+		channel int     // Channel with largest range.
+		score   float64 // Range scaled by pixel count, larger boxes are split first.
+	}
+	newBox := func(colors []weightedColor) box {
+		b := box{colors: colors}
+		weight := 0.0
+		for _, wc := range colors {
+			weight += wc.weight
+		}
+		for ch := 0; ch < 4; ch++ {
+			low, high := 255.0, 0.0
+			for _, wc := range colors {
+				low, high = math.Min(low, wc.c[ch]), math.Max(high, wc.c[ch])
+			}
+			if score := (high - low) * math.Sqrt(weight); score > b.score {
+				b.channel, b.score = ch, score
+			}
+		}
+		return b
+	}
+
+	boxes := []box{newBox(append([]weightedColor{}, histogram...))}
+	for len(boxes) < colors {
+
+		// Box worth splitting most, boxes of a single color can not be split.
+		index := -1
+		for i, b := range boxes {
+			if len(b.colors) > 1 && (index < 0 || b.score > boxes[index].score) {
+				index = i
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		// Weighted median of channel from histogram of channel values, colors are partitioned around it.
+		b := boxes[index]
+		var bins [256]float64
+		total := 0.0
+		for _, wc := range b.colors {
+			bins[int(wc.c[b.channel])] += wc.weight
+			total += wc.weight
+		}
+		low, high := 255, 0
+		for _, wc := range b.colors {
+			low, high = min(low, int(wc.c[b.channel])), max(high, int(wc.c[b.channel]))
+		}
+		median, sum := low, 0.0
+		for v := low; v < high; v++ { // Upper half keeps at least the highest value.
+			median, sum = v, sum+bins[v]
+			if sum >= total/2 {
+				break
+			}
+		}
+
+		split := 0
+		for i, wc := range b.colors {
+			if int(wc.c[b.channel]) <= median {
+				b.colors[split], b.colors[i] = b.colors[i], b.colors[split]
+				split++
+			}
+		}
+
+		boxes[index] = newBox(b.colors[:split])
+		boxes = append(boxes, newBox(b.colors[split:]))
+	}
+
+	palette := make([][4]float64, len(boxes))
+	for i, b := range boxes {
+		palette[i] = meanColor(b.colors)
+	}
+	return palette
+}
+
+// Refine palette by weighted k-means clustering of the histogram.
+//
+// Histogram is reduced to 5 bits per channel first, which bounds the cost on photos.
+func kMeans(histogram []weightedColor, palette [][4]float64) [][4]float64 {
+
+	buckets := map[[4]int][]weightedColor{}
+	keys := [][4]int{}
+	for _, wc := range histogram {
+		key := [4]int{int(wc.c[0]) >> 3, int(wc.c[1]) >> 3, int(wc.c[2]) >> 3, int(wc.c[3]) >> 3}
+		if _, ok := buckets[key]; !ok {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], wc)
+	}
+	points := make([]weightedColor, len(keys))
+	for i, key := range keys {
+		weight := 0.0
+		for _, wc := range buckets[key] {
+			weight += wc.weight
+		}
+		points[i] = weightedColor{meanColor(buckets[key]), weight}
+	}
+
+	palette = append([][4]float64{}, palette...)
+	for round := 0; round < kMeansIterations; round++ {
+		search := newPaletteSearch(palette)
+		clusters := make([][]weightedColor, len(palette))
+		for _, p := range points {
+			i := search.closest(p.c)
+			clusters[i] = append(clusters[i], p)
+		}
+		for i, cluster := range clusters {
+			if len(cluster) > 0 { // Empty clusters keep their color.
+				palette[i] = meanColor(cluster)
+			}
+		}
+	}
+
+	return palette
+}
+
+// Node of color tree, children are indexed by one bit of each channel.
+type octreeNode struct {
+	children [16]*octreeNode
+	sum      [4]float64 // Weighted color sum of pixels below.
+	weight   float64    // Pixel count below.
+	leaf     bool       // Node has no children, either at full depth or merged.
+}
+
+// Choose palette by octree, extended by alpha channel to 16 children per node.
+//
+// Deepest nodes with fewest pixels are merged into their parent until the leaves fit the palette.
+func octree(histogram []weightedColor, colors int) [][4]float64 {
+
+	const depth = 8
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, depth) // Inner nodes by depth.
+	levels[0] = []*octreeNode{root}
+	leaves := 0
+
+	for _, wc := range histogram {
+		node := root
+		for level := 0; level < depth; level++ {
+			node.sum, node.weight = addColor(node.sum, node.weight, wc)
+			shift := 7 - level
+			index := 0
+			for ch := 0; ch < 4; ch++ {
+				index |= (int(wc.c[ch]) >> shift & 1) << ch
+			}
+			if node.children[index] == nil {
+				child := &octreeNode{leaf: level == depth-1}
+				node.children[index] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[level+1] = append(levels[level+1], child)
+				}
+			}
+			node = node.children[index]
+		}
+		node.sum, node.weight = addColor(node.sum, node.weight, wc)
+	}
+
+	// Merge deepest level first, rarest nodes first.
+	for level := depth - 1; level >= 0 && leaves > colors; level-- {
+		nodes := levels[level]
+		sort.SliceStable(nodes, func(i int, j int) bool { return nodes[i].weight < nodes[j].weight })
+		for _, node := range nodes {
+			if leaves <= colors {
+				break
+			}
+			children := 0
+			for i, child := range node.children {
+				if child != nil {
+					children++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= children - 1
+		}
+	}
+
+	palette := [][4]float64{}
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			palette = append(palette, [4]float64{node.sum[0] / node.weight, node.sum[1] / node.weight, node.sum[2] / node.weight, node.sum[3] / node.weight})
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+
+	return palette
+}
+
+// Add weighted color to color sum.
+func addColor(sum [4]float64, weight float64, wc weightedColor) ([4]float64, float64) {
+	for ch := range sum {
+		sum[ch] += wc.c[ch] * wc.weight
+	}
+	return sum, weight + wc.weight
+}
+
+// Palette sorted by red channel, for nearest color search.
+type paletteSearch struct {
+	colors  [][4]float64 // Palette colors sorted by red.
+	indices []int        // Palette index of sorted colors.
+}
+
+// Create nearest color search of palette.
+func newPaletteSearch(palette [][4]float64) *paletteSearch {
+	search := &paletteSearch{indices: make([]int, len(palette))}
+	for i := range palette {
+		search.indices[i] = i
+	}
+	sort.SliceStable(search.indices, func(i int, j int) bool { return palette[search.indices[i]][0] < palette[search.indices[j]][0] })
+	for _, index := range search.indices {
+		search.colors = append(search.colors, palette[index])
+	}
+	return search
+}
+
+// Index of palette color closest to color.
+//
+// Search goes outward from the closest red value, and stops once red alone is farther than the best match.
+func (search *paletteSearch) closest(c [4]float64) int {
+
+	start := sort.Search(len(search.colors), func(i int) bool { return search.colors[i][0] >= c[0] })
+	best, best_distance := 0, math.Inf(1)
+	check := func(i int) bool {
+		p := search.colors[i]
+		d := p[0] - c[0]
+		if d*d >= best_distance {
+			return false
+		}
+		distance := d * d
+		for ch := 1; ch < 4; ch++ {
+			d := p[ch] - c[ch]
+			distance += d * d
+		}
+		if distance < best_distance {
+			best, best_distance = search.indices[i], distance
+		}
+		return true
+	}
+
+	for up, down := start, start-1; up < len(search.colors) || down >= 0; {
+		if up < len(search.colors) {
+			if check(up) {
+				up++
+			} else {
+				up = len(search.colors)
+			}
+		}
+		if down >= 0 {
+			if check(down) {
+				down--
+			} else {
+				down = -1
+			}
+		}
+	}
+	return best
+}
+
+// Bayer matrix of ordered dithering, values in [0, 64).
+var bayerMatrix = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// Map image onto palette, with optional dithering of color channels.
+func mapToPalette(src *image.NRGBA, palette [][4]float64, dither string) *image.Paletted {
+
+	// Palette colors are rounded, so that dithering error is measured against stored colors.
+	colors := make(color.Palette, len(palette))
+	rounded := make([][4]float64, len(palette))
+	for i, p := range palette {
+		c := color.NRGBA{clampUint8(p[0]), clampUint8(p[1]), clampUint8(p[2]), clampUint8(p[3])}
+		colors[i], rounded[i] = c, [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}
+	}
+	palette = rounded
+	dst := image.NewPaletted(src.Rect, colors)
+
+	// Lookup is cached, images usually repeat colors.
+	search := newPaletteSearch(palette)
+	cache := map[[4]float64]uint8{}
+	lookup := func(c [4]float64) uint8 {
+		index, ok := cache[c]
+		if !ok {
+			index = uint8(search.closest(c))
+			cache[c] = index
+		}
+		return index
+	}
+
+	method := strings.ToLower(dither)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	spread := 255 / math.Max(1, math.Cbrt(float64(len(palette)))-1)                       // Distance between palette levels per channel.
+	diffusion := [2][]float64{make([]float64, (width+2)*4), make([]float64, (width+2)*4)} // Error of current and next row.
+
+	for y := 0; y < height; y++ {
+		row := src.Pix[src.PixOffset(0, y):]
+		current, next := diffusion[y%2], diffusion[(y+1)%2]
+		clear(next)
+
+		for x := 0; x < width; x++ {
+			var c [4]float64
+			for ch := range c {
+				c[ch] = float64(row[x*4+ch])
+			}
+			switch {
+			case c[3] == 0:
+				c = [4]float64{} // Transparent pixels share one color, and are not dithered.
+			case method == DitherOrdered:
+				offset := (bayerMatrix[y%8][x%8]+0.5)/64 - 0.5
+				for ch := 0; ch < 3; ch++ {
+					c[ch] = math.Round(math.Min(255, math.Max(0, c[ch]+offset*spread)))
+				}
+			case method == DitherFloydSteinberg:
+				for ch := 0; ch < 3; ch++ {
+					c[ch] = math.Round(math.Min(255, math.Max(0, c[ch]+current[(x+1)*4+ch])))
+				}
+			}
+
+			index := lookup(c)
+			dst.Pix[dst.PixOffset(x, y)] = index
+
+			// Error is spread right and below: 7/16, 3/16, 5/16, 1/16.
+			if method == DitherFloydSteinberg && c[3] != 0 {
+				for ch := 0; ch < 3; ch++ {
+					e := c[ch] - palette[index][ch]
+					current[(x+2)*4+ch] += e * 7 / 16
+					next[x*4+ch] += e * 3 / 16
+					next[(x+1)*4+ch] += e * 5 / 16
+					next[(x+2)*4+ch] += e * 1 / 16
+				}
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,306 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Errors
+var ErrUnknownAlgorithm = errors.New("unknown resize algorithm")
+
+// Resize algorithms.
+const (
+	AlgorithmNearestNeighbor = "nearestneighbor" // Nearest neighbor, no interpolation.
+	AlgorithmApproxBiLinear  = "approxbilinear"  // Bilinear (tent) interpolation.
+	AlgorithmCatmullRom      = "catmullrom"      // Catmull-Rom cubic interpolation.
+	AlgorithmMitchell        = "mitchell"        // Mitchell-Netravali cubic, less ringing than Catmull-Rom.
+	AlgorithmLanczos2        = "lanczos2"        // Lanczos windowed sinc, 2 lobes.
+	AlgorithmLanczos3        = "lanczos3"        // Lanczos windowed sinc, 3 lobes, sharpest.
+	AlgorithmBox             = "box"             // Box filter, area averaging for large reductions.
+)
+
+// Resampling kernel.
+//
+// Support: Radius of the kernel at scale 1.
+//
+// Weight: Kernel function, zero outside of [-Support, Support].
+type Kernel struct {
+	Support float64                 // Kernel radius.
+	Weight  func(x float64) float64 // Kernel function.
+}
+
+// Available resampling kernels, keyed by algorithm name.
+var kernels = map[string]Kernel{
+	AlgorithmApproxBiLinear: {1, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}},
+	AlgorithmCatmullRom: {2, func(x float64) float64 {
+		return cubicBC(x, 0, 0.5)
+	}},
+	AlgorithmMitchell: {2, func(x float64) float64 {
+		return cubicBC(x, 1.0/3, 1.0/3)
+	}},
+	AlgorithmLanczos2: {2, func(x float64) float64 {
+		return lanczos(x, 2)
+	}},
+	AlgorithmLanczos3: {3, func(x float64) float64 {
+		return lanczos(x, 3)
+	}},
+	AlgorithmBox: {0.5, func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}},
+}
+
+// Check if the resize algorithm is supported, algorithm names are case-insensitive.
+func IsResizeAlgorithm(algorithm string) bool {
+	algorithm = strings.ToLower(algorithm)
+	_, ok := kernels[algorithm]
+	return ok || algorithm == AlgorithmNearestNeighbor
+}
+
+// Resize image to exact size, aspect ratio is not preserved.
+//
+// Resampling is done with premultiplied alpha, so transparent pixels don't bleed into edges.
+//
+// width, height: Output size.
+// algorithm: Resize algorithm, see `IsResizeAlgorithm`.
+// linear: Resample in linear light instead of sRGB, avoids dark halos around thin dark lines.
+func Resize(width int, height int, algorithm string, linear bool) Filter {
+
+	algorithm = strings.ToLower(algorithm)
+
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		if width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("invalid resize target %dx%d", width, height)
+		}
+		if src.Rect.Dx() == width && src.Rect.Dy() == height {
+			return src, nil // Nothing to do.
+		}
+
+		if algorithm == AlgorithmNearestNeighbor {
+			sw, sh := src.Rect.Dx(), src.Rect.Dy()
+			return remapPixels(src, width, height, func(x int, y int) (int, int) {
+				return x * sw / width, y * sh / height
+			}), nil
+		}
+
+		kernel, ok := kernels[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s'", ErrUnknownAlgorithm, algorithm)
+		}
+
+		return resample(src, width, height, kernel, linear), nil
+	})
+}
+
+// Cubic filter with B and C parameters (Mitchell-Netravali family).
+func cubicBC(x float64, b float64, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	default:
+		return 0
+	}
+}
+
+// Lanczos windowed sinc with `a` lobes.
+func lanczos(x float64, a float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if math.Abs(x) >= a {
+		return 0
+	}
+	px := math.Pi * x
+	return a * math.Sin(px) * math.Sin(px/a) / (px * px)
+}
+
+// Contributions of source pixels to a single destination pixel.
+type contribution struct {
+	start   int       // First source pixel.
+	weights []float64 // Normalized weights, starting from `start`.
+}
+
+// Compute contributions for resampling one dimension.
+//
+// When downscaling, kernel is stretched so every source pixel contributes.
+// Source pixels outside of the image are clamped to the edge.
+func computeContributions(src_size int, dst_size int, kernel Kernel) []contribution {
+
+	scale := float64(dst_size) / float64(src_size)
+	filter_scale := math.Max(1, 1/scale)
+	support := kernel.Support * filter_scale
+
+	contributions := make([]contribution, dst_size)
+	for i := range contributions {
+		center := (float64(i) + 0.5) / scale // Destination pixel center in source space.
+		left := int(math.Floor(center - support))
+		right := int(math.Ceil(center + support))
+
+		start := max(0, left)
+		end := min(src_size-1, right)
+		weights := make([]float64, end-start+1)
+
+		sum := 0.0
+		for j := left; j <= right; j++ {
+			w := kernel.Weight((float64(j) + 0.5 - center) / filter_scale)
+			if w == 0 {
+				continue
+			}
+			index := min(max(j, start), end) - start // Clamp to edge.
+			weights[index] += w
+			sum += w
+		}
+
+		if sum != 0 {
+			for k := range weights {
+				weights[k] /= sum
+			}
+		}
+
+		contributions[i] = contribution{start, weights}
+	}
+
+	return contributions
+}
+
+// Resample image with separable kernel in premultiplied space.
+func resample(src *image.NRGBA, width int, height int, kernel Kernel, linear bool) *image.NRGBA {
+	return resampleBuffer(toPremultipliedBuffer(src, linear), width, height, kernel).toNRGBA(linear)
+}
+
+// Resample premultiplied buffer with separable kernel, horizontal pass first.
+func resampleBuffer(src *premultipliedBuffer, width int, height int, kernel Kernel) *premultipliedBuffer {
+
+	// Horizontal pass.
+	horizontal := newPremultipliedBuffer(width, src.height)
+	columns := computeContributions(src.width, width, kernel)
+	for y := 0; y < src.height; y++ {
+		for x, c := range columns {
+			var sum [4]float64
+			for k, w := range c.weights {
+				si := (y*src.width + c.start + k) * 4
+				for ch := 0; ch < 4; ch++ {
+					sum[ch] += src.pix[si+ch] * w
+				}
+			}
+			copy(horizontal.pix[(y*width+x)*4:], sum[:])
+		}
+	}
+
+	// Vertical pass.
+	dst := newPremultipliedBuffer(width, height)
+	rows := computeContributions(src.height, height, kernel)
+	for y, c := range rows {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for k, w := range c.weights {
+				si := ((c.start+k)*width + x) * 4
+				for ch := 0; ch < 4; ch++ {
+					sum[ch] += horizontal.pix[si+ch] * w
+				}
+			}
+			copy(dst.pix[(y*width+x)*4:], sum[:])
+		}
+	}
+
+	return dst
+}
+
+// Image buffer of premultiplied float channels in [0, 1].
+type premultipliedBuffer struct {
+	width  int       // Buffer width.
+	height int       // Buffer height.
+	pix    []float64 // Pixels, 4 channels per pixel.
+}
+
+// Create empty premultiplied buffer.
+func newPremultipliedBuffer(width int, height int) *premultipliedBuffer {
+	return &premultipliedBuffer{width, height, make([]float64, width*height*4)}
+}
+
+// Convert NRGBA image to premultiplied buffer.
+//
+// linear: Convert color channels from sRGB to linear light.
+func toPremultipliedBuffer(src *image.NRGBA, linear bool) *premultipliedBuffer {
+
+	// Channel value to float, 8-bit input allows a lookup table.
+	var table [256]float64
+	for i := range table {
+		if linear {
+			table[i] = srgbToLinear(float64(i) / 255)
+		} else {
+			table[i] = float64(i) / 255
+		}
+	}
+
+	buffer := newPremultipliedBuffer(src.Rect.Dx(), src.Rect.Dy())
+	for y := 0; y < buffer.height; y++ {
+		for x := 0; x < buffer.width; x++ {
+			si := src.PixOffset(x, y)
+			di := (y*buffer.width + x) * 4
+			a := float64(src.Pix[si+3]) / 255
+			buffer.pix[di] = table[src.Pix[si]] * a
+			buffer.pix[di+1] = table[src.Pix[si+1]] * a
+			buffer.pix[di+2] = table[src.Pix[si+2]] * a
+			buffer.pix[di+3] = a
+		}
+	}
+
+	return buffer
+}
+
+// Convert premultiplied buffer back to NRGBA image.
+//
+// linear: Buffer is in linear light, convert color channels back to sRGB.
+func (buffer *premultipliedBuffer) toNRGBA(linear bool) *image.NRGBA {
+
+	dst := image.NewNRGBA(image.Rect(0, 0, buffer.width, buffer.height))
+	for i := 0; i < buffer.width*buffer.height; i++ {
+		a := buffer.pix[i*4+3]
+		di := i * 4
+		if a <= 0 {
+			continue // Fully transparent.
+		}
+		for ch := 0; ch < 3; ch++ {
+			v := math.Min(1, math.Max(0, buffer.pix[i*4+ch]/a)) // Kernels with negative lobes may overshoot.
+			if linear {
+				v = linearToSRGB(v)
+			}
+			dst.Pix[di+ch] = clampUint8(v * 255)
+		}
+		dst.Pix[di+3] = clampUint8(a * 255)
+	}
+
+	return dst
+}
+
+// Convert sRGB encoded value in [0, 1] to linear light.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Convert linear light value in [0, 1] to sRGB encoding.
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
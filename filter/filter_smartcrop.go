@@ -0,0 +1,175 @@
+package filter
+
+import (
+	"image"
+	"math"
+)
+
+// Long side of the downscaled copy used for content analysis.
+const smartCropAnalysisSize = 256
+
+// Skin tone, normalized to unit length, used by skin heuristic.
+var smartCropSkin = func() [3]float64 {
+	r, g, b := normalizeRGB(0.78, 0.57, 0.44)
+	return [3]float64{r, g, b}
+}()
+
+// Find the most interesting position of a crop window.
+//
+// Every window position is scored by edge density, saturation and skin tone of its content,
+// with the inner part of the window weighted twice so subjects are kept away from the crop border.
+// Featureless images fall back to the center of the region.
+//
+// img: Image to analyse.
+// region: Part of the image the window must stay inside.
+// size: Crop window size.
+//
+// Returns top-left corner of the window.
+func SmartCropPosition(img image.Image, region image.Rectangle, size image.Point) image.Point {
+
+	region = region.Intersect(img.Bounds())
+	size = image.Pt(min(size.X, region.Dx()), min(size.Y, region.Dy()))
+	if region.Empty() || size == region.Size() {
+		return region.Min
+	}
+
+	// Analyse a downscaled copy, details finer than that don't matter for framing.
+	src := toNRGBA(img)
+	bounds := img.Bounds()
+	scale := math.Min(1, float64(smartCropAnalysisSize)/float64(max(bounds.Dx(), bounds.Dy())))
+	analysis := src
+	if scale < 1 {
+		analysis = resample(src, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale)), kernels[AlgorithmBox], false)
+	}
+	scale_x := float64(analysis.Rect.Dx()) / float64(bounds.Dx())
+	scale_y := float64(analysis.Rect.Dy()) / float64(bounds.Dy())
+
+	table := newSummedAreaTable(interestMap(analysis), analysis.Rect.Dx())
+
+	// Region and window in analysis space.
+	region_min := image.Pt(int(float64(region.Min.X-bounds.Min.X)*scale_x), int(float64(region.Min.Y-bounds.Min.Y)*scale_y))
+	region_max := image.Pt(
+		min(analysis.Rect.Dx(), int(math.Ceil(float64(region.Max.X-bounds.Min.X)*scale_x))),
+		min(analysis.Rect.Dy(), int(math.Ceil(float64(region.Max.Y-bounds.Min.Y)*scale_y))),
+	)
+	window := image.Pt(
+		min(region_max.X-region_min.X, max(1, int(math.Round(float64(size.X)*scale_x)))),
+		min(region_max.Y-region_min.Y, max(1, int(math.Round(float64(size.Y)*scale_y)))),
+	)
+	inner := image.Rect(window.X/6, window.Y/6, window.X-window.X/6, window.Y-window.Y/6)
+
+	// Exhaustive search, summed-area table makes each window O(1).
+	center_x := float64(region_min.X+region_max.X-window.X) / 2
+	center_y := float64(region_min.Y+region_max.Y-window.Y) / 2
+	best, best_score := region_min, math.Inf(-1)
+	for y := region_min.Y; y+window.Y <= region_max.Y; y++ {
+		for x := region_min.X; x+window.X <= region_max.X; x++ {
+			score := table.sum(image.Rect(x, y, x+window.X, y+window.Y)) + table.sum(inner.Add(image.Pt(x, y)))
+			score -= 1e-9 * ((float64(x)-center_x)*(float64(x)-center_x) + (float64(y)-center_y)*(float64(y)-center_y)) // Ties go to the center.
+			if score > best_score {
+				best, best_score = image.Pt(x, y), score
+			}
+		}
+	}
+
+	// Back to image space, mapping the range of free positions so edges and center are kept exact.
+	to_image := func(best int, low int, free int, region_min int, region_free int) int {
+		if free <= 0 {
+			return region_min + region_free/2
+		}
+		return region_min + int(math.Round(float64((best-low)*region_free)/float64(free)))
+	}
+	position := image.Pt(
+		to_image(best.X, region_min.X, region_max.X-region_min.X-window.X, region.Min.X, region.Dx()-size.X),
+		to_image(best.Y, region_min.Y, region_max.Y-region_min.Y-window.Y, region.Min.Y, region.Dy()-size.Y),
+	)
+
+	return position
+}
+
+// Compute interest of every pixel, weighted by its opacity.
+func interestMap(src *image.NRGBA) []float64 {
+
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	luminance := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := src.PixOffset(x, y)
+			luminance[y*width+x] = (0.299*float64(src.Pix[i]) + 0.587*float64(src.Pix[i+1]) + 0.114*float64(src.Pix[i+2])) / 255
+		}
+	}
+	at := func(x int, y int) float64 { // Clamp to edge.
+		return luminance[min(max(y, 0), height-1)*width+min(max(x, 0), width-1)]
+	}
+
+	interest := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := src.PixOffset(x, y)
+			r, g, b := float64(src.Pix[i])/255, float64(src.Pix[i+1])/255, float64(src.Pix[i+2])/255
+			lum := luminance[y*width+x]
+
+			// Edge density, central differences of luminance.
+			edge := math.Abs(at(x+1, y)-at(x-1, y)) + math.Abs(at(x, y+1)-at(x, y-1))
+
+			// Saturation, ignored in near black and near white areas.
+			saturation := 0.0
+			if high, low := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b)); high > 0 && lum > 0.05 && lum < 0.9 {
+				saturation = (high - low) / high
+			}
+
+			// Skin tone, cosine similarity of hue to reference skin color.
+			skin := 0.0
+			if lum > 0.2 {
+				nr, ng, nb := normalizeRGB(r, g, b)
+				similarity := nr*smartCropSkin[0] + ng*smartCropSkin[1] + nb*smartCropSkin[2]
+				skin = math.Max(0, (similarity-0.98)/0.02)
+			}
+
+			alpha := float64(src.Pix[i+3]) / 255
+			interest[y*width+x] = (edge + 0.2*saturation + 0.6*skin) * alpha
+		}
+	}
+
+	return interest
+}
+
+// Normalize color to unit length.
+func normalizeRGB(r float64, g float64, b float64) (float64, float64, float64) {
+	length := math.Sqrt(r*r + g*g + b*b)
+	if length == 0 {
+		return 0, 0, 0
+	}
+	return r / length, g / length, b / length
+}
+
+// Summed-area table, sum of any rectangle in constant time.
+type summedAreaTable struct {
+	width int       // Table width, one more than source width.
+	sums  []float64 // Sum of all values above and left of each position.
+}
+
+// Build summed-area table of values.
+//
+// values: Row-major values, one row is `width` values.
+func newSummedAreaTable(values []float64, width int) summedAreaTable {
+
+	height := len(values) / width
+	table := summedAreaTable{width + 1, make([]float64, (width+1)*(height+1))}
+	for y := 0; y < height; y++ {
+		row := 0.0
+		for x := 0; x < width; x++ {
+			row += values[y*width+x]
+			table.sums[(y+1)*table.width+x+1] = table.sums[y*table.width+x+1] + row
+		}
+	}
+
+	return table
+}
+
+// Sum values inside rectangle.
+func (table summedAreaTable) sum(rect image.Rectangle) float64 {
+	at := func(x int, y int) float64 { return table.sums[y*table.width+x] }
+	return at(rect.Max.X, rect.Max.Y) - at(rect.Min.X, rect.Max.Y) - at(rect.Max.X, rect.Min.Y) + at(rect.Min.X, rect.Min.Y)
+}
@@ -0,0 +1,847 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Create test image, each pixel encodes its own coordinate in red and green channels.
+func createCoordinateImage(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+// Run filter and fail the test on error.
+func applyFilter(t *testing.T, f Filter, img image.Image) *image.NRGBA {
+	result, err := f(img)
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	return toNRGBA(result)
+}
+
+func TestRotateAndFlip(t *testing.T) {
+
+	src := createCoordinateImage(3, 2)
+
+	// Rotating clockwise moves bottom-left pixel to top-left.
+	rotated := applyFilter(t, Rotate(90, color.NRGBA{}), src)
+	if rotated.Rect.Dx() != 2 || rotated.Rect.Dy() != 3 {
+		t.Fatalf("Expected 2x3 after rotation, got %v", rotated.Rect)
+	}
+	if c := rotated.NRGBAAt(0, 0); c.R != 0 || c.G != 1 {
+		t.Fatalf("Expected (0, 1) at top-left, got (%d, %d)", c.R, c.G)
+	}
+
+	// Rotating back restores the image.
+	restored := applyFilter(t, Rotate(-90, color.NRGBA{}), rotated)
+	if c := restored.NRGBAAt(2, 1); c.R != 2 || c.G != 1 {
+		t.Fatalf("Expected (2, 1) after restoring, got (%d, %d)", c.R, c.G)
+	}
+
+	flipped := applyFilter(t, Flip(FlipHorizontal), src)
+	if c := flipped.NRGBAAt(0, 0); c.R != 2 || c.G != 0 {
+		t.Fatalf("Expected (2, 0) at top-left after flipping, got (%d, %d)", c.R, c.G)
+	}
+
+	// Arbitrary angle expands canvas.
+	w, h := RotatedSize(100, 50, 45)
+	if w != 107 || h != 107 {
+		t.Fatalf("Expected 107x107 canvas, got %dx%d", w, h)
+	}
+	tilted := applyFilter(t, Rotate(45, color.NRGBA{255, 255, 255, 255}), createCoordinateImage(100, 50))
+	if tilted.Rect.Dx() != w || tilted.Rect.Dy() != h {
+		t.Fatalf("Expected %dx%d after rotation, got %v", w, h, tilted.Rect)
+	}
+	if c := tilted.NRGBAAt(0, 0); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected corner filled with background, got %v", c)
+	}
+}
+
+func TestOrient(t *testing.T) {
+
+	src := createCoordinateImage(3, 2)
+
+	// Every orientation must produce upright image of correct size.
+	for orientation := 1; orientation <= 8; orientation++ {
+		result := applyFilter(t, Orient(orientation), src)
+		if orientation >= 5 && (result.Rect.Dx() != 2 || result.Rect.Dy() != 3) {
+			t.Fatalf("Expected orientation %d to swap dimensions, got %v", orientation, result.Rect)
+		}
+	}
+
+	// Orientation 5 is transpose.
+	transposed := applyFilter(t, Orient(5), src)
+	if c := transposed.NRGBAAt(1, 2); c.R != 2 || c.G != 1 {
+		t.Fatalf("Expected (2, 1) at (1, 2) after transposing, got (%d, %d)", c.R, c.G)
+	}
+
+	// Filters require decoded image.
+	_, err := Orient(6)(nil)
+	if err != ErrNoImage {
+		t.Fatalf("Expected ErrNoImage, got %v", err)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+
+	cases := map[string]color.NRGBA{
+		"":            {},
+		"transparent": {},
+		"white":       {255, 255, 255, 255},
+		"#f00":        {255, 0, 0, 255},
+		"#00ff0080":   {0, 255, 0, 128},
+		"#123456":     {0x12, 0x34, 0x56, 255},
+	}
+
+	for s, expected := range cases {
+		c, err := ParseColor(s)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %v", s, err)
+		}
+		if c != expected {
+			t.Fatalf("Expected '%s' to be %v, got %v", s, expected, c)
+		}
+	}
+
+	if _, err := ParseColor("#12345"); err == nil {
+		t.Fatalf("Expected malformed color to be rejected")
+	}
+}
+
+func TestResize(t *testing.T) {
+
+	// Solid color must stay solid with every algorithm.
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3] = 200, 100, 50, 255
+	}
+
+	algorithms := []string{AlgorithmNearestNeighbor, AlgorithmApproxBiLinear, AlgorithmCatmullRom, AlgorithmMitchell, AlgorithmLanczos2, AlgorithmLanczos3, AlgorithmBox}
+	for index, algorithm := range algorithms {
+		linear := index%2 == 0 // Cover both color spaces.
+		for _, size := range [][2]int{{10, 7}, {97, 61}} {
+			resized := applyFilter(t, Resize(size[0], size[1], algorithm, linear), src)
+			if resized.Rect.Dx() != size[0] || resized.Rect.Dy() != size[1] {
+				t.Fatalf("%s: expected %v, got %v", algorithm, size, resized.Rect)
+			}
+			if c := resized.NRGBAAt(size[0]/2, size[1]/2); c != (color.NRGBA{200, 100, 50, 255}) {
+				t.Fatalf("%s: expected solid color to be preserved, got %v", algorithm, c)
+			}
+		}
+	}
+
+	_, err := Resize(10, 10, "unknown", false)(src)
+	if err == nil {
+		t.Fatalf("Expected unknown algorithm to be rejected")
+	}
+}
+
+func TestLinearResize(t *testing.T) {
+
+	// Black and white stripes, averaging in linear light is brighter than in sRGB.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+
+	gamma := applyFilter(t, Resize(1, 1, AlgorithmBox, false), src).NRGBAAt(0, 0)
+	linear := applyFilter(t, Resize(1, 1, AlgorithmBox, true), src).NRGBAAt(0, 0)
+
+	if gamma.R != 128 || linear.R != 188 {
+		t.Fatalf("Expected 128 in sRGB and 188 in linear light, got %d and %d", gamma.R, linear.R)
+	}
+
+	// Transparent pixels must not darken the result.
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 0, 0})
+	edge := applyFilter(t, Resize(1, 1, AlgorithmBox, true), src).NRGBAAt(0, 0)
+	if edge.R != 255 || edge.A != 128 {
+		t.Fatalf("Expected half transparent white, got %v", edge)
+	}
+}
+
+func TestPad(t *testing.T) {
+
+	src := createCoordinateImage(4, 2)
+	red := color.NRGBA{255, 0, 0, 255}
+
+	// Bottom-right alignment on red background.
+	padded := applyFilter(t, Pad(6, 6, AnchorBottomRight, red, FillColor), src)
+	if padded.Rect.Dx() != 6 || padded.Rect.Dy() != 6 {
+		t.Fatalf("Expected 6x6 canvas, got %v", padded.Rect)
+	}
+	if c := padded.NRGBAAt(0, 0); c != red {
+		t.Fatalf("Expected background at top-left, got %v", c)
+	}
+	if c := padded.NRGBAAt(2, 4); c.R != 0 || c.G != 0 || c.A != 255 {
+		t.Fatalf("Expected image origin at (2, 4), got %v", c)
+	}
+
+	// Blurred fill covers the whole canvas.
+	blurred := applyFilter(t, Pad(40, 40, AnchorCenter, color.NRGBA{}, FillBlur), createCoordinateImage(40, 10))
+	if c := blurred.NRGBAAt(20, 0); c.A != 255 {
+		t.Fatalf("Expected opaque backdrop, got %v", c)
+	}
+
+	for anchor, expected := range map[string]image.Point{AnchorTop: {1, 0}, AnchorRight: {2, 1}, "": {1, 1}} {
+		if p := AnchorOffset(anchor, image.Pt(6, 4), image.Pt(4, 2)); p != expected {
+			t.Fatalf("Expected anchor '%s' at %v, got %v", anchor, expected, p)
+		}
+	}
+}
+
+func TestSmartCropPosition(t *testing.T) {
+
+	// Flat gray image with a detailed patch near the right edge.
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 600; x++ {
+			v := uint8(128)
+			if x >= 450 && x < 550 && y >= 50 && y < 150 && (x/4+y/4)%2 == 0 {
+				v = 255
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+
+	p := SmartCropPosition(img, img.Rect, image.Pt(200, 200))
+	if p.Y != 0 || p.X < 350 || p.X > 450 {
+		t.Fatalf("Expected crop covering the patch, got %v", p)
+	}
+
+	// Limited to region.
+	p = SmartCropPosition(img, image.Rect(0, 0, 500, 200), image.Pt(200, 200))
+	if p.X != 300 {
+		t.Fatalf("Expected crop at right edge of region, got %v", p)
+	}
+
+	// Featureless image is cropped at center, within one analysis pixel.
+	flat := image.NewNRGBA(image.Rect(0, 0, 1000, 300))
+	if p := SmartCropPosition(flat, flat.Rect, image.Pt(300, 300)); p.Y != 0 || p.X < 346 || p.X > 354 {
+		t.Fatalf("Expected center crop of flat image, got %v", p)
+	}
+}
+
+func TestTrim(t *testing.T) {
+
+	// White canvas with slightly off-white noise and a red square at (3, 2)-(7, 5).
+	src := image.NewNRGBA(image.Rect(0, 0, 12, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 12; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+		}
+	}
+	src.SetNRGBA(10, 8, color.NRGBA{250, 250, 250, 255})
+	for y := 2; y < 5; y++ {
+		for x := 3; x < 7; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+
+	if r := applyFilter(t, Trim(0, 0, nil), src).Rect; r.Dx() != 8 || r.Dy() != 7 {
+		t.Fatalf("Expected exact trim to keep the noise pixel, got %v", r)
+	}
+	if r := applyFilter(t, Trim(5, 0, nil), src).Rect; r.Dx() != 4 || r.Dy() != 3 {
+		t.Fatalf("Expected 4x3 content, got %v", r)
+	}
+	trimmed := applyFilter(t, Trim(5, 1, nil), src)
+	if trimmed.Rect.Dx() != 6 || trimmed.Rect.Dy() != 5 {
+		t.Fatalf("Expected 6x5 content with padding, got %v", trimmed.Rect)
+	}
+	if c := trimmed.NRGBAAt(1, 1); c.G != 0 {
+		t.Fatalf("Expected content at (1, 1), got %v", c)
+	}
+
+	// Transparent margins match whatever their color channels are.
+	transparent := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	transparent.SetNRGBA(0, 0, color.NRGBA{10, 20, 30, 0})
+	transparent.SetNRGBA(5, 6, color.NRGBA{0, 0, 0, 255})
+	if r := applyFilter(t, Trim(0, 0, &color.NRGBA{}), transparent).Rect; r.Dx() != 1 || r.Dy() != 1 {
+		t.Fatalf("Expected single pixel content, got %v", r)
+	}
+
+	// Uniform image is kept.
+	if r := applyFilter(t, Trim(0, 0, nil), image.NewNRGBA(image.Rect(0, 0, 3, 3))).Rect; r.Dx() != 3 {
+		t.Fatalf("Expected uniform image to be kept, got %v", r)
+	}
+}
+
+func TestComposite(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{200, 100, 50, 255})
+		}
+	}
+	layer := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			layer.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+
+	// Normal blend at half opacity, second copy is partially outside.
+	dst := applyFilter(t, Composite(layer, []image.Point{{0, 0}, {3, 3}}, 0.5, BlendNormal), src)
+	if c := dst.NRGBAAt(1, 1); c != (color.NRGBA{100, 50, 153, 255}) {
+		t.Fatalf("Expected half blended pixel, got %v", c)
+	}
+	if c := dst.NRGBAAt(3, 3); c.B != 153 {
+		t.Fatalf("Expected clipped copy at (3, 3), got %v", c)
+	}
+	if c := dst.NRGBAAt(2, 2); c != src.NRGBAAt(2, 2) {
+		t.Fatalf("Expected untouched pixel, got %v", c)
+	}
+	if c := src.NRGBAAt(0, 0); c.B != 50 {
+		t.Fatalf("Expected input image to be untouched, got %v", c)
+	}
+
+	// Multiply with blue keeps only blue channel.
+	dst = applyFilter(t, Composite(layer, []image.Point{{0, 0}}, 1, BlendMultiply), src)
+	if c := dst.NRGBAAt(0, 0); c != (color.NRGBA{0, 0, 50, 255}) {
+		t.Fatalf("Expected multiplied pixel, got %v", c)
+	}
+
+	// Layer over transparent image keeps its own color.
+	dst = applyFilter(t, Composite(layer, []image.Point{{0, 0}}, 1, BlendScreen), image.NewNRGBA(image.Rect(0, 0, 2, 2)))
+	if c := dst.NRGBAAt(0, 0); c != (color.NRGBA{0, 0, 255, 255}) {
+		t.Fatalf("Expected layer color over transparent pixel, got %v", c)
+	}
+
+	if IsBlendMode("dodge") || !IsBlendMode("Overlay") || !IsBlendMode("") {
+		t.Fatalf("Unexpected blend mode support")
+	}
+}
+
+func TestDrawText(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 255 // Opaque black.
+	}
+
+	face, err := LoadFontFace(nil, 20)
+	if err != nil {
+		t.Fatalf("Failed to load bundled font: %v", err)
+	}
+
+	// Count pixels brighter than black inside rectangle.
+	lit := func(img *image.NRGBA, rect image.Rectangle) int {
+		count := 0
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if img.NRGBAAt(x, y).R > 0 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	white := color.NRGBA{255, 255, 255, 255}
+	dst := applyFilter(t, DrawText("WIP", face, AnchorBottomRight, image.Point{}, TextStyle{Color: white}), src)
+	if lit(dst, image.Rect(150, 70, 200, 100)) == 0 {
+		t.Fatalf("Expected text at bottom-right corner")
+	}
+	if lit(dst, image.Rect(0, 0, 150, 70)) != 0 {
+		t.Fatalf("Expected nothing drawn outside of bottom-right corner")
+	}
+
+	// Full width caption bar.
+	gray := color.NRGBA{128, 128, 128, 255}
+	dst = applyFilter(t, DrawText("v1\nproof", face, AnchorTop, image.Point{}, TextStyle{Color: white, Background: gray, Padding: 2, FullWidth: true}), src)
+	if c := dst.NRGBAAt(0, 0); c != gray {
+		t.Fatalf("Expected caption bar at top-left, got %v", c)
+	}
+	if c := dst.NRGBAAt(199, 0); c != gray {
+		t.Fatalf("Expected caption bar at top-right, got %v", c)
+	}
+	if c := dst.NRGBAAt(0, 99); c.R != 0 {
+		t.Fatalf("Expected caption bar to end above the bottom, got %v", c)
+	}
+
+	// Outline grows glyphs.
+	outlined := applyFilter(t, DrawText("WIP", face, AnchorCenter, image.Point{}, TextStyle{Color: white, StrokeWidth: 2, StrokeColor: gray}), src)
+	plain := applyFilter(t, DrawText("WIP", face, AnchorCenter, image.Point{}, TextStyle{Color: white}), src)
+	if lit(outlined, outlined.Rect) <= lit(plain, plain.Rect) {
+		t.Fatalf("Expected outline to cover more pixels than plain text")
+	}
+}
+
+func TestToneCurves(t *testing.T) {
+
+	// Lifting shadows.
+	levels := LevelsCurve(0, 255, 1, 32, 255)
+	if levels[0] != 32 || levels[255] != 255 || levels[128] <= 128 {
+		t.Fatalf("Unexpected levels curve: %d %d %d", levels[0], levels[128], levels[255])
+	}
+
+	curve, err := PointCurve([][2]float64{{255, 255}, {0, 0}, {64, 96}})
+	if err != nil {
+		t.Fatalf("Failed to create curve: %v", err)
+	}
+	if curve[0] != 0 || curve[64] != 96 || curve[255] != 255 {
+		t.Fatalf("Expected curve through control points, got %d %d %d", curve[0], curve[64], curve[255])
+	}
+	for i := 1; i < 256; i++ {
+		if curve[i] < curve[i-1] {
+			t.Fatalf("Expected monotone curve, %d at %d is below %d", curve[i], i, curve[i-1])
+		}
+	}
+
+	for _, points := range [][][2]float64{{{0, 0}}, {{0, 0}, {0, 255}}, {{0, 0}, {300, 255}}} {
+		if _, err := PointCurve(points); err == nil {
+			t.Fatalf("Expected points %v to be rejected", points)
+		}
+	}
+
+	if c := IdentityCurve().Then(levels); c != levels {
+		t.Fatalf("Expected identity to be neutral")
+	}
+
+	// Only red channel changes.
+	src := createCoordinateImage(4, 4)
+	dst := applyFilter(t, ApplyToneCurves(levels, IdentityCurve(), IdentityCurve()), src)
+	if c := dst.NRGBAAt(0, 3); c.R != 32 || c.G != 3 || c.A != 255 {
+		t.Fatalf("Expected red channel lifted, got %v", c)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 40, 40, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{60, 60, 60, 128})
+
+	if dst := applyFilter(t, Adjust(Adjustment{}), src); dst.NRGBAAt(0, 0) != src.NRGBAAt(0, 0) {
+		t.Fatalf("Expected zero adjustment to change nothing, got %v", dst.NRGBAAt(0, 0))
+	}
+
+	gray := applyFilter(t, Adjust(Adjustment{Saturation: -1}), src).NRGBAAt(0, 0)
+	if gray.R != gray.G || gray.G != gray.B {
+		t.Fatalf("Expected grayscale, got %v", gray)
+	}
+
+	brighter := applyFilter(t, Adjust(Adjustment{Exposure: 1}), src).NRGBAAt(1, 0)
+	if brighter.R <= 60 || brighter.A != 128 {
+		t.Fatalf("Expected brighter pixel with alpha kept, got %v", brighter)
+	}
+
+	rotated := applyFilter(t, Adjust(Adjustment{Hue: 120}), src).NRGBAAt(0, 0)
+	if rotated.G <= rotated.R || rotated.G <= rotated.B {
+		t.Fatalf("Expected red rotated towards green, got %v", rotated)
+	}
+
+	swap := func(r uint8, g uint8, b uint8) (uint8, uint8, uint8) { return b, g, r }
+	if c := applyFilter(t, ConvertColors(swap), src).NRGBAAt(1, 0); c != (color.NRGBA{60, 60, 60, 128}) {
+		t.Fatalf("Expected converted color with alpha kept, got %v", c)
+	}
+	if c := applyFilter(t, ConvertColors(swap), src).NRGBAAt(0, 0); c.R != 40 || c.B != 200 {
+		t.Fatalf("Expected channels swapped, got %v", c)
+	}
+}
+
+func TestLUT(t *testing.T) {
+
+	identity := "# Identity\nLUT_3D_SIZE 2\n0 0 0\n1 0 0\n0 1 0\n1 1 0\n0 0 1\n1 0 1\n0 1 1\n1 1 1\n"
+	lut, err := ParseCubeLUT(strings.NewReader(identity))
+	if err != nil {
+		t.Fatalf("Failed to parse LUT: %v", err)
+	}
+
+	src := createCoordinateImage(4, 4)
+	src.SetNRGBA(1, 1, color.NRGBA{200, 120, 40, 128})
+	for _, interpolation := range []string{InterpolationTrilinear, InterpolationTetrahedral} {
+		dst := applyFilter(t, ApplyLUT(lut, interpolation, 1), src)
+		if dst.NRGBAAt(1, 1) != src.NRGBAAt(1, 1) || dst.NRGBAAt(3, 2) != src.NRGBAAt(3, 2) {
+			t.Fatalf("Expected identity LUT to change nothing with %s, got %v", interpolation, dst.NRGBAAt(1, 1))
+		}
+	}
+
+	// Inverting LUT at half strength gives mid gray.
+	invert := "LUT_3D_SIZE 2\n1 1 1\n0 1 1\n1 0 1\n0 0 1\n1 1 0\n0 1 0\n1 0 0\n0 0 0\n"
+	lut, err = ParseCubeLUT(strings.NewReader(invert))
+	if err != nil {
+		t.Fatalf("Failed to parse LUT: %v", err)
+	}
+	if c := applyFilter(t, ApplyLUT(lut, "", 1), src).NRGBAAt(1, 1); c.R != 55 || c.G != 135 || c.B != 215 || c.A != 128 {
+		t.Fatalf("Expected inverted color with alpha kept, got %v", c)
+	}
+	if c := applyFilter(t, ApplyLUT(lut, "", 0.5), src).NRGBAAt(1, 1); c.R != 128 || c.G != 128 || c.B != 128 {
+		t.Fatalf("Expected mid gray at half strength, got %v", c)
+	}
+
+	// Identity Hald image of level 2, 8 pixels square.
+	hald := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 64; i++ {
+		r, g, b := i%4, i/4%4, i/16
+		hald.SetNRGBA(i%8, i/8, color.NRGBA{uint8(r * 85), uint8(g * 85), uint8(b * 85), 255})
+	}
+	lut, err = HaldLUT(hald)
+	if err != nil || lut.Size != 4 {
+		t.Fatalf("Failed to load Hald LUT: %v", err)
+	}
+	if c := applyFilter(t, ApplyLUT(lut, InterpolationTrilinear, 1), src).NRGBAAt(1, 1); c != src.NRGBAAt(1, 1) {
+		t.Fatalf("Expected identity Hald LUT to change nothing, got %v", c)
+	}
+
+	// Resolve input range sets the domain of all channels.
+	lut, err = ParseCubeLUT(strings.NewReader("LUT_1D_INPUT_RANGE 0 1\nLUT_3D_INPUT_RANGE -0.5 2\n" + identity))
+	if err != nil || lut.DomainMin != [3]float64{-0.5, -0.5, -0.5} || lut.DomainMax != [3]float64{2, 2, 2} {
+		t.Fatalf("Expected input range as domain, got %v, %v (%v)", lut.DomainMin, lut.DomainMax, err)
+	}
+	if !IsLUTInterpolation("Trilinear") || IsLUTInterpolation("cubic") {
+		t.Fatalf("Expected interpolation names to be case-insensitive")
+	}
+
+	for _, invalid := range []string{"LUT_1D_SIZE 2\n", "LUT_3D_SIZE 2\n0 0 0\n", "0 0 0\n", "LUT_3D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n",
+		"LUT_3D_SIZE 2\nLUT_3D_SIZE 2\n", "LUT_3D_INPUT_RANGE 0\n" + identity, "LUT_3D_INPUT_RANGE 1 0\n" + identity} {
+		if _, err := ParseCubeLUT(strings.NewReader(invalid)); !errors.Is(err, ErrInvalidLUT) {
+			t.Fatalf("Expected invalid LUT error for %q, got %v", invalid, err)
+		}
+	}
+	if _, err := HaldLUT(image.NewNRGBA(image.Rect(0, 0, 9, 8))); !errors.Is(err, ErrInvalidLUT) {
+		t.Fatalf("Expected non-square Hald image to be rejected, got %v", err)
+	}
+}
+
+func TestChannelOperations(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{0, 0, 255, 200})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 255})
+
+	// Blue is dark by luma, brighter by linear luminance.
+	rec709 := applyFilter(t, Grayscale(GrayRec709), src).NRGBAAt(0, 0)
+	rec601 := applyFilter(t, Grayscale(GrayRec601), src).NRGBAAt(0, 0)
+	luminance := applyFilter(t, Grayscale(GrayLuminance), src).NRGBAAt(0, 0)
+	if rec709 != (color.NRGBA{18, 18, 18, 200}) || rec601.R != 29 || luminance.R != 76 || luminance.R != luminance.B {
+		t.Fatalf("Unexpected gray values %v, %v and %v", rec709, rec601, luminance)
+	}
+	if c := applyFilter(t, Grayscale(GrayLuminance), src).NRGBAAt(1, 0); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected white to stay white, got %v", c)
+	}
+
+	if c := applyFilter(t, Sepia(1), src).NRGBAAt(1, 0); c.R != 255 || c.B != 239 {
+		t.Fatalf("Expected sepia toned white, got %v", c)
+	}
+	if c := applyFilter(t, Sepia(0), src).NRGBAAt(0, 0); c != src.NRGBAAt(0, 0) {
+		t.Fatalf("Expected zero strength sepia to change nothing, got %v", c)
+	}
+
+	duotone := GradientMap([]color.NRGBA{{20, 0, 60, 255}, {255, 240, 200, 255}}, GrayRec709)
+	if c := applyFilter(t, duotone, src).NRGBAAt(1, 0); c != (color.NRGBA{255, 240, 200, 255}) {
+		t.Fatalf("Expected white mapped to highlight color, got %v", c)
+	}
+	if _, err := GradientMap([]color.NRGBA{{}}, "")(src); err == nil {
+		t.Fatalf("Expected single color gradient to be rejected")
+	}
+
+	if c := applyFilter(t, Invert(), src).NRGBAAt(0, 0); c != (color.NRGBA{255, 255, 0, 200}) {
+		t.Fatalf("Expected inverted color with alpha kept, got %v", c)
+	}
+
+	swapped := applyFilter(t, MapChannels([4]string{ChannelSourceBlue, "", ChannelSourceRed, ChannelSourceWhite}), src)
+	if c := swapped.NRGBAAt(0, 0); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("Expected red and blue swapped, got %v", c)
+	}
+	if _, err := MapChannels([4]string{"cyan"})(src); err == nil {
+		t.Fatalf("Expected unknown channel source to be rejected")
+	}
+}
+
+func TestBlurAndSharpen(t *testing.T) {
+
+	// Single white pixel on black row, box blur spreads it evenly.
+	row := image.NewNRGBA(image.Rect(0, 0, 5, 1))
+	for x := 0; x < 5; x++ {
+		row.SetNRGBA(x, 0, color.NRGBA{0, 0, 0, 255})
+	}
+	row.SetNRGBA(2, 0, color.NRGBA{255, 255, 255, 255})
+	box := applyFilter(t, Blur(BlurBox, 1), row)
+	if box.NRGBAAt(1, 0).R != 85 || box.NRGBAAt(2, 0).R != 85 || box.NRGBAAt(0, 0).R != 0 {
+		t.Fatalf("Unexpected box blur %v", box.Pix)
+	}
+	gaussian := applyFilter(t, Blur(BlurGaussian, 1), row)
+	if c := gaussian.NRGBAAt(2, 0); c.R >= 255 || c.R <= gaussian.NRGBAAt(1, 0).R || gaussian.NRGBAAt(1, 0) != gaussian.NRGBAAt(3, 0) {
+		t.Fatalf("Unexpected gaussian blur %v", gaussian.Pix)
+	}
+
+	// Transparent pixels do not darken opaque neighbours.
+	edge := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	edge.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	if c := applyFilter(t, Blur("", 2), edge).NRGBAAt(1, 0); c.R != 255 || c.A == 0 || c.A == 255 {
+		t.Fatalf("Expected red bleeding into transparency, got %v", c)
+	}
+	if _, err := Blur(BlurGaussian, 0)(edge); err == nil {
+		t.Fatalf("Expected zero radius to be rejected")
+	}
+	if _, err := Blur("motion", 1)(edge); err == nil {
+		t.Fatalf("Expected unknown blur type to be rejected")
+	}
+
+	// Box convolution matches resampling to the same size with box kernel, also across parallel row ranges.
+	buffer := toPremultipliedBuffer(createCoordinateImage(37, 23), false)
+	expected := resampleBuffer(buffer, 37, 23, Kernel{2.5, func(x float64) float64 {
+		if x >= -2.5 && x < 2.5 {
+			return 1
+		}
+		return 0
+	}}).pix
+	for i, v := range convolveBuffer(buffer, boxKernel(2), boxKernel(2), EdgeClamp).pix {
+		if math.Abs(v-expected[i]) > 1e-9 {
+			t.Fatalf("Expected box convolution to match resampling, differs at %d", i)
+		}
+	}
+
+	// Sharpening pushes both sides of an edge apart, threshold keeps small differences.
+	step := image.NewNRGBA(image.Rect(0, 0, 6, 1))
+	for x := 0; x < 6; x++ {
+		v := uint8(100)
+		if x >= 3 {
+			v = 150
+		}
+		step.SetNRGBA(x, 0, color.NRGBA{v, v, v, 128})
+	}
+	sharpened := applyFilter(t, Sharpen(1, 1, 0), step)
+	if dark, bright := sharpened.NRGBAAt(2, 0), sharpened.NRGBAAt(3, 0); dark.R >= 100 || bright.R <= 150 || dark.A != 128 {
+		t.Fatalf("Expected sharpened edge, got %v and %v", dark, bright)
+	}
+	if c := sharpened.NRGBAAt(0, 0); c.R != 100 {
+		t.Fatalf("Expected flat area unchanged, got %v", c)
+	}
+	if c := applyFilter(t, Sharpen(1, 1, 255), step).NRGBAAt(2, 0); c.R != 100 {
+		t.Fatalf("Expected threshold to skip edge, got %v", c)
+	}
+}
+
+func TestConvolve(t *testing.T) {
+
+	if got := edgeIndices(3, 2, EdgeWrap); fmt.Sprint(got) != "[1 2 0 1 2 0 1]" {
+		t.Fatalf("Unexpected wrapped indices %v", got)
+	}
+	if got := edgeIndices(3, 2, EdgeMirror); fmt.Sprint(got) != "[2 1 0 1 2 1 0]" {
+		t.Fatalf("Unexpected mirrored indices %v", got)
+	}
+	if got := edgeIndices(3, 2, ""); fmt.Sprint(got) != "[0 0 0 1 2 2 2]" {
+		t.Fatalf("Unexpected clamped indices %v", got)
+	}
+
+	// Shift kernel samples left neighbour, outside pixels follow edge mode.
+	src := createCoordinateImage(6, 4)
+	shift := [][]float64{{0, 0, 0}, {1, 0, 0}, {0, 0, 0}}
+	for edge, expected := range map[string]uint8{EdgeClamp: 0, EdgeWrap: 5, EdgeMirror: 1} {
+		result := applyFilter(t, Convolve(shift, 0, 0, edge), src)
+		if c := result.NRGBAAt(0, 1); c.R != expected || c.G != 1 {
+			t.Fatalf("Expected column %d with %s edge, got %v", expected, edge, c)
+		}
+		if c := result.NRGBAAt(3, 2); c.R != 2 {
+			t.Fatalf("Expected shifted column, got %v", c)
+		}
+	}
+
+	// Non-separable kernel, divided by its sum.
+	diagonal := [][]float64{{0, 0, 0}, {1, 0, 0}, {0, 0, 1}}
+	if _, _, ok := separateKernel(diagonal); ok {
+		t.Fatalf("Expected diagonal kernel not to be separable")
+	}
+	if c := applyFilter(t, Convolve(diagonal, 0, 0, EdgeWrap), src).NRGBAAt(0, 0); c.R != 3 {
+		t.Fatalf("Expected average of wrapped neighbours, got %v", c)
+	}
+
+	// Edge detection of flat area is zero, bias lifts it to mid gray. Alpha is kept.
+	flat := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	draw.Draw(flat, flat.Rect, image.NewUniform(color.NRGBA{200, 100, 50, 160}), image.Point{}, draw.Src)
+	laplacian := [][]float64{{0, -1, 0}, {-1, 4, -1}, {0, -1, 0}}
+	if c := applyFilter(t, Convolve(laplacian, 0, 128, ""), flat).NRGBAAt(1, 1); c != (color.NRGBA{128, 128, 128, 160}) {
+		t.Fatalf("Expected mid gray, got %v", c)
+	}
+
+	if _, err := Convolve([][]float64{{1, 1}}, 0, 0, "")(flat); err == nil {
+		t.Fatalf("Expected even kernel to be rejected")
+	}
+	if _, err := Convolve(shift, 0, 0, "repeat")(flat); err == nil {
+		t.Fatalf("Expected unknown edge mode to be rejected")
+	}
+}
+
+func TestAlpha(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 0, 0, 128})
+	src.SetNRGBA(1, 0, color.NRGBA{10, 20, 30, 255})
+	src.SetNRGBA(0, 1, color.NRGBA{250, 250, 250, 255})
+
+	// Half transparent red over white, transparent pixels show background only.
+	flat := applyFilter(t, Flatten(color.NRGBA{255, 255, 255, 255}, 0, color.NRGBA{}), src)
+	if c := flat.NRGBAAt(0, 0); c != (color.NRGBA{227, 127, 127, 255}) {
+		t.Fatalf("Expected red blended onto white, got %v", c)
+	}
+	if c := flat.NRGBAAt(1, 1); c != (color.NRGBA{255, 255, 255, 255}) || !flat.Opaque() {
+		t.Fatalf("Expected opaque white background, got %v", c)
+	}
+	checker := applyFilter(t, Flatten(color.NRGBA{255, 255, 255, 255}, 2, color.NRGBA{0, 0, 0, 255}), image.NewNRGBA(image.Rect(0, 0, 4, 1)))
+	if checker.NRGBAAt(1, 0).R != 255 || checker.NRGBAAt(2, 0) != (color.NRGBA{0, 0, 0, 255}) {
+		t.Fatalf("Unexpected checkerboard %v", checker.Pix)
+	}
+	opaque := applyFilter(t, Invert(), flat)
+	if result := applyFilter(t, Flatten(color.NRGBA{}, 0, color.NRGBA{}), opaque); result != opaque {
+		t.Fatalf("Expected opaque image to be returned as is")
+	}
+
+	if c := applyFilter(t, RemoveAlpha(), src).NRGBAAt(0, 0); c != (color.NRGBA{200, 0, 0, 255}) {
+		t.Fatalf("Expected opaque red, got %v", c)
+	}
+	if c := applyFilter(t, ScaleAlpha(0.5), src).NRGBAAt(1, 0); c.A != 128 {
+		t.Fatalf("Expected half alpha, got %v", c)
+	}
+	if c := applyFilter(t, Premultiply(), src).NRGBAAt(0, 0); c != (color.NRGBA{100, 0, 0, 128}) {
+		t.Fatalf("Expected premultiplied red, got %v", c)
+	}
+	if c := applyFilter(t, Unmatte(color.NRGBA{255, 255, 255, 255}), flat).NRGBAAt(0, 0); c.A != 255 {
+		t.Fatalf("Expected opaque pixel unchanged, got %v", c)
+	}
+	matted := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	matted.SetNRGBA(0, 0, color.NRGBA{227, 127, 127, 128})
+	if c := applyFilter(t, Unmatte(color.NRGBA{255, 255, 255, 255}), matted).NRGBAAt(0, 0); c.R < 199 || c.R > 201 || c.G > 1 {
+		t.Fatalf("Expected white matte removed, got %v", c)
+	}
+
+	// Near white is keyed out, feather softens the rest.
+	keyed := applyFilter(t, ColorKey(color.NRGBA{255, 255, 255, 255}, 5, 0), src)
+	if keyed.NRGBAAt(0, 1).A != 0 || keyed.NRGBAAt(1, 0).A != 255 {
+		t.Fatalf("Unexpected color key %v", keyed.Pix)
+	}
+	if c := applyFilter(t, ColorKey(color.NRGBA{0, 0, 0, 255}, 0, 20), src).NRGBAAt(1, 0); c.A == 0 || c.A == 255 {
+		t.Fatalf("Expected feathered alpha, got %v", c)
+	}
+
+	if _, err := Alpha("erase", 1, color.NRGBA{}, 0, 0)(src); err == nil {
+		t.Fatalf("Expected unknown alpha mode to be rejected")
+	}
+}
+
+func TestBorderAndRoundCorners(t *testing.T) {
+
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(src, src.Rect, image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+
+	framed := applyFilter(t, Border(1, 2, 3, 4, 1, black, white), src)
+	if size := framed.Rect.Size(); size != image.Pt(4+2+4+2, 2+1+3+2) {
+		t.Fatalf("Unexpected framed size %v", size)
+	}
+	if framed.NRGBAAt(0, 0) != black || framed.NRGBAAt(4, 1) != white || framed.NRGBAAt(5, 2) != src.NRGBAAt(0, 0) {
+		t.Fatalf("Expected border, padding and image, got %v, %v and %v", framed.NRGBAAt(0, 0), framed.NRGBAAt(4, 1), framed.NRGBAAt(5, 2))
+	}
+	if framed.NRGBAAt(9, 4) != white || framed.NRGBAAt(10, 4) != black {
+		t.Fatalf("Expected padding next to right border, got %v and %v", framed.NRGBAAt(9, 4), framed.NRGBAAt(10, 4))
+	}
+
+	// Corner pixels outside of the circle are cleared, edge pixels are partially covered.
+	square := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(square, square.Rect, image.NewUniform(white), image.Point{}, draw.Src)
+	rounded := applyFilter(t, RoundCorners(8, color.NRGBA{}), square)
+	if rounded.NRGBAAt(0, 0).A != 0 || rounded.NRGBAAt(19, 19).A != 0 || rounded.NRGBAAt(10, 10) != white || rounded.NRGBAAt(0, 10) != white {
+		t.Fatalf("Unexpected rounded corners")
+	}
+	antialiased := false
+	for x := 0; x < 8; x++ {
+		if a := rounded.NRGBAAt(x, 1).A; a != 0 && a != 255 {
+			antialiased = antialiased || rounded.NRGBAAt(x, 1).R == 255
+		}
+	}
+	if !antialiased {
+		t.Fatalf("Expected anti-aliased edge with color kept")
+	}
+
+	// Colored background replaces corners, half radius of square makes a circle.
+	circle := applyFilter(t, RoundCorners(100, black), square)
+	if circle.NRGBAAt(0, 0) != black || circle.NRGBAAt(2, 2) != black || circle.NRGBAAt(10, 0).R < 128 || circle.NRGBAAt(10, 10) != white {
+		t.Fatalf("Unexpected circle %v, %v", circle.NRGBAAt(2, 2), circle.NRGBAAt(10, 0))
+	}
+}
+
+func TestQuantize(t *testing.T) {
+
+	// Few colors are kept exactly, transparent pixels share one entry.
+	few := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	few.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	few.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 255})
+	few.SetNRGBA(2, 0, color.NRGBA{0, 0, 255, 128})
+	few.SetNRGBA(0, 1, color.NRGBA{9, 9, 9, 0})
+	result, err := Quantize(8, "", "")(few)
+	paletted, ok := result.(*image.Paletted)
+	if err != nil || !ok || len(paletted.Palette) != 4 {
+		t.Fatalf("Expected paletted image with 4 colors, got %T (%v)", result, err)
+	}
+	if c := color.NRGBAModel.Convert(paletted.At(2, 0)); c != (color.NRGBA{0, 0, 255, 128}) {
+		t.Fatalf("Expected exact color, got %v", c)
+	}
+	if c := color.NRGBAModel.Convert(paletted.At(1, 1)).(color.NRGBA); c.A != 0 {
+		t.Fatalf("Expected transparent pixel, got %v", c)
+	}
+
+	// Gray gradient, mean brightness of each row.
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 256; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(x), uint8(x), 255})
+		}
+	}
+	meanError := func(img image.Image) float64 {
+		sum := 0.0
+		for x := 0; x < 256; x++ {
+			for y := 0; y < 8; y++ {
+				sum += math.Abs(float64(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R) - float64(x))
+			}
+		}
+		return sum / 256 / 8
+	}
+
+	for _, method := range []string{QuantizeMedianCut, QuantizeKMeans, QuantizeOctree} {
+		result := applyFilter(t, Quantize(8, method, DitherNone), gradient)
+		if e := meanError(result); e > 16 {
+			t.Fatalf("Expected close colors with %s, got mean error %v", method, e)
+		}
+		palette, _ := Quantize(8, method, DitherNone)(gradient)
+		if n := len(palette.(*image.Paletted).Palette); n > 8 {
+			t.Fatalf("Expected at most 8 colors with %s, got %d", method, n)
+		}
+	}
+
+	// Dithering keeps local brightness of two color gradient, plain mapping does not.
+	blockMean := func(img image.Image, x0 int) float64 {
+		sum := 0.0
+		for x := x0; x < x0+16; x++ {
+			for y := 0; y < 8; y++ {
+				sum += float64(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R)
+			}
+		}
+		return sum / 16 / 8
+	}
+	plain := applyFilter(t, Quantize(2, "", DitherNone), gradient)
+	for _, dither := range []string{DitherFloydSteinberg, DitherOrdered} {
+		dithered := applyFilter(t, Quantize(2, "", dither), gradient)
+		if m := blockMean(dithered, 112); math.Abs(m-blockMean(gradient, 112)) > 12 || math.Abs(blockMean(plain, 112)-blockMean(gradient, 112)) < 24 {
+			t.Fatalf("Expected %s dithering to keep brightness, got %v", dither, m)
+		}
+	}
+
+	if _, err := Quantize(1, "", "")(few); err == nil {
+		t.Fatalf("Expected palette of one color to be rejected")
+	}
+	if _, err := Quantize(16, "popularity", "")(few); err == nil {
+		t.Fatalf("Expected unknown method to be rejected")
+	}
+}
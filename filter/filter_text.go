@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Style of rendered text.
+//
+// Color: Text color.
+//
+// StrokeWidth, StrokeColor: Outline around glyphs, no outline if width is 0.
+//
+// ShadowOffset, ShadowColor: Drop shadow of text and outline, no shadow if color is transparent.
+//
+// Background, Padding: Box behind the text, no box if background is transparent.
+//
+// FullWidth: Stretch background box over the whole image width, as a caption bar.
+type TextStyle struct {
+	Color        color.NRGBA // Text color.
+	StrokeWidth  int         // Outline width.
+	StrokeColor  color.NRGBA // Outline color.
+	ShadowOffset image.Point // Shadow offset.
+	ShadowColor  color.NRGBA // Shadow color.
+	Background   color.NRGBA // Background box color.
+	Padding      int         // Space between text and box edge.
+	FullWidth    bool        // Background box spans image width.
+}
+
+// Load font face.
+//
+// data: TTF or OTF font data, nil for bundled Go Regular font.
+// size: Font size in pixels.
+func LoadFontFace(data []byte, size float64) (font.Face, error) {
+
+	if data == nil {
+		data = goregular.TTF
+	}
+
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %w", err)
+	}
+
+	// At 72 DPI, points are pixels.
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Draw text onto image.
+//
+// Lines are separated by `\n` and aligned like the block, e.g. right aligned at `bottomright`.
+//
+// text: Text to draw.
+// face: Font face, see `LoadFontFace`.
+// anchor: Text position, see `AnchorPosition`.
+// offset: Offset added to aligned position.
+// style: Text style.
+func DrawText(text string, face font.Face, anchor string, offset image.Point, style TextStyle) Filter {
+	return newFilter(func(src *image.NRGBA) (*image.NRGBA, error) {
+
+		if face == nil {
+			return nil, fmt.Errorf("no font face to draw text")
+		}
+
+		lines := strings.Split(text, "\n")
+		metrics := face.Metrics()
+		line_height := metrics.Height.Ceil()
+		ascent := metrics.Ascent.Ceil()
+
+		// Measure text block, the outline grows it on every side.
+		block := image.Pt(0, line_height*len(lines))
+		widths := make([]int, len(lines))
+		for i, line := range lines {
+			widths[i] = font.MeasureString(face, line).Ceil()
+			block.X = max(block.X, widths[i])
+		}
+		margin := style.StrokeWidth + style.Padding
+		box_size := block.Add(image.Pt(margin*2, margin*2))
+		if style.FullWidth {
+			box_size.X = src.Rect.Dx()
+		}
+
+		// Place box, then text block inside it.
+		fx, _, _ := AnchorPosition(anchor)
+		box := image.Rectangle{Max: box_size}.Add(AnchorOffset(anchor, src.Rect.Size(), box_size).Add(offset))
+		if style.FullWidth {
+			box.Min.X, box.Max.X = 0, src.Rect.Dx()
+		}
+		origin := box.Min.Add(image.Pt(margin+int(math.Round(float64(box.Dx()-2*margin-block.X)*fx)), margin))
+
+		// Render glyph coverage into mask.
+		mask := image.NewAlpha(src.Rect)
+		drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+		for i, line := range lines {
+			x := origin.X + int(math.Round(float64(block.X-widths[i])*fx)) // Align line inside block.
+			drawer.Dot = fixed.P(x, origin.Y+i*line_height+ascent)
+			drawer.DrawString(line)
+		}
+
+		outline := mask
+		if style.StrokeWidth > 0 {
+			outline = dilateAlpha(mask, style.StrokeWidth)
+		}
+
+		dst := image.NewNRGBA(src.Rect)
+		copy(dst.Pix, src.Pix)
+
+		// Back to front: box, shadow, outline, text.
+		if style.Background.A > 0 {
+			draw.Draw(dst, box, &image.Uniform{style.Background}, image.Point{}, draw.Over)
+		}
+		if style.ShadowColor.A > 0 {
+			shadow_rect := dst.Rect.Add(style.ShadowOffset).Intersect(dst.Rect)
+			draw.DrawMask(dst, shadow_rect, &image.Uniform{style.ShadowColor}, image.Point{}, outline, shadow_rect.Min.Sub(style.ShadowOffset), draw.Over)
+		}
+		if style.StrokeWidth > 0 {
+			draw.DrawMask(dst, dst.Rect, &image.Uniform{style.StrokeColor}, image.Point{}, outline, image.Point{}, draw.Over)
+		}
+		draw.DrawMask(dst, dst.Rect, &image.Uniform{style.Color}, image.Point{}, mask, image.Point{}, draw.Over)
+
+		return dst, nil
+	})
+}
+
+// Grow alpha mask by radius with a round brush.
+func dilateAlpha(src *image.Alpha, radius int) *image.Alpha {
+
+	// Round brush offsets.
+	brush := []image.Point{}
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy <= radius*radius {
+				brush = append(brush, image.Pt(dx, dy))
+			}
+		}
+	}
+
+	dst := image.NewAlpha(src.Rect)
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			a := src.AlphaAt(x, y).A
+			if a == 0 {
+				continue
+			}
+			for _, p := range brush {
+				q := image.Pt(x+p.X, y+p.Y)
+				if q.In(dst.Rect) && dst.AlphaAt(q.X, q.Y).A < a {
+					dst.SetAlpha(q.X, q.Y, color.Alpha{a})
+				}
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,110 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Number of samples of tone response curves which are not pure gamma.
+const curveTableSize = 1024
+
+// Get ICC data of profile, built-in profiles are encoded as version 2 matrix/TRC display profile.
+//
+// Version 2 is used since it is understood by all color managed applications.
+func (profile *Profile) Bytes() []byte {
+
+	if profile.Data != nil {
+		return profile.Data
+	}
+
+	// Tag data, matrix columns are the D50 adapted primaries.
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", encodeDescription(profile.Description)},
+		{"cprt", append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)},
+		{"wtpt", encodeXYZ(profile.White)},
+	}
+	for col, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tags = append(tags, struct {
+			sig  string
+			data []byte
+		}{sig, encodeXYZ(XYZ{profile.Matrix[col], profile.Matrix[3+col], profile.Matrix[6+col]})})
+	}
+	for ch, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags = append(tags, struct {
+			sig  string
+			data []byte
+		}{sig, encodeCurve(profile.Curves[ch])})
+	}
+
+	// Header, tag table, then tag data aligned to 4 bytes.
+	table, body := &bytes.Buffer{}, &bytes.Buffer{}
+	offset := headerLength + 4 + len(tags)*12
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		table.WriteString(tag.sig)
+		binary.Write(table, binary.BigEndian, uint32(offset+body.Len()))
+		binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+		body.Write(tag.data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	header := make([]byte, headerLength)
+	binary.BigEndian.PutUint32(header, uint32(headerLength+table.Len()+body.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // Version 2.1.
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	for i, v := range D50 { // Illuminant of PCS.
+		binary.BigEndian.PutUint32(header[68+i*4:], uint32(toFixed(v)))
+	}
+
+	return append(append(header, table.Bytes()...), body.Bytes()...)
+}
+
+// Convert to signed 15.16 fixed point number.
+func toFixed(v float64) int32 {
+	return int32(math.Round(v * 65536))
+}
+
+// Encode `XYZ ` tag.
+func encodeXYZ(v XYZ) []byte {
+	buf := bytes.NewBufferString("XYZ \x00\x00\x00\x00")
+	for _, c := range v {
+		binary.Write(buf, binary.BigEndian, toFixed(c))
+	}
+	return buf.Bytes()
+}
+
+// Encode version 2 `desc` tag, with empty Unicode and ScriptCode parts.
+func encodeDescription(description string) []byte {
+	buf := bytes.NewBufferString("desc\x00\x00\x00\x00")
+	binary.Write(buf, binary.BigEndian, uint32(len(description)+1))
+	buf.WriteString(description + "\x00")
+	buf.Write(make([]byte, 4+4+2+1+67))
+	return buf.Bytes()
+}
+
+// Encode `curv` tag, pure gamma is stored as is, other curves are sampled.
+func encodeCurve(curve Curve) []byte {
+
+	buf := bytes.NewBufferString("curv\x00\x00\x00\x00")
+	if len(curve.Table) == 0 && curve.Kind == 0 && len(curve.Params) == 1 {
+		binary.Write(buf, binary.BigEndian, uint32(1))
+		binary.Write(buf, binary.BigEndian, uint16(math.Round(curve.Params[0]*256)))
+		return buf.Bytes()
+	}
+
+	binary.Write(buf, binary.BigEndian, uint32(curveTableSize))
+	for i := 0; i < curveTableSize; i++ {
+		v := curve.Eval(float64(i) / (curveTableSize - 1))
+		binary.Write(buf, binary.BigEndian, uint16(math.Round(math.Min(1, math.Max(0, v))*65535)))
+	}
+	return buf.Bytes()
+}
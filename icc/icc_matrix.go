@@ -0,0 +1,46 @@
+package icc
+
+import (
+	"math"
+)
+
+// Multiply 3x3 matrices.
+func multiplyMatrix(a [9]float64, b [9]float64) [9]float64 {
+	var m [9]float64
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				m[row*3+col] += a[row*3+k] * b[k*3+col]
+			}
+		}
+	}
+	return m
+}
+
+// Apply 3x3 matrix to vector.
+func applyMatrix(m [9]float64, v XYZ) XYZ {
+	return XYZ{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+}
+
+// Invert 3x3 matrix, false if singular.
+func invertMatrix(m [9]float64) ([9]float64, bool) {
+
+	cofactors := [9]float64{
+		m[4]*m[8] - m[5]*m[7], m[2]*m[7] - m[1]*m[8], m[1]*m[5] - m[2]*m[4],
+		m[5]*m[6] - m[3]*m[8], m[0]*m[8] - m[2]*m[6], m[2]*m[3] - m[0]*m[5],
+		m[3]*m[7] - m[4]*m[6], m[1]*m[6] - m[0]*m[7], m[0]*m[4] - m[1]*m[3],
+	}
+	determinant := m[0]*cofactors[0] + m[1]*cofactors[3] + m[2]*cofactors[6]
+	if math.Abs(determinant) < 1e-12 {
+		return [9]float64{}, false
+	}
+
+	for i := range cofactors {
+		cofactors[i] /= determinant
+	}
+	return cofactors, true
+}
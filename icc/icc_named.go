@@ -0,0 +1,118 @@
+package icc
+
+import (
+	"strings"
+)
+
+// Built-in color space, defined by chromaticities and transfer function.
+type namedSpace struct {
+	description string        // Profile description.
+	primaries   [3][2]float64 // Red, green and blue primaries, xy chromaticity.
+	white       [2]float64    // White point, xy chromaticity.
+	curve       Curve         // Transfer function.
+}
+
+// Transfer function of sRGB, also used by Display P3.
+var srgbCurve = Curve{Kind: 3, Params: []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}}
+
+// Built-in color spaces, keyed by upper case name. Names match those accepted by `icc_embed`.
+var namedSpaces = map[string]namedSpace{
+	"SRGB": {
+		description: "sRGB",
+		primaries:   [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+		white:       [2]float64{0.3127, 0.3290},
+		curve:       srgbCurve,
+	},
+	"DISPLAY P3": {
+		description: "Display P3",
+		primaries:   [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}},
+		white:       [2]float64{0.3127, 0.3290},
+		curve:       srgbCurve,
+	},
+	"DCI P3": {
+		description: "DCI P3",
+		primaries:   [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}},
+		white:       [2]float64{0.314, 0.351},
+		curve:       Curve{Params: []float64{2.6}},
+	},
+	"ADOBE RGB": {
+		description: "Adobe RGB (1998)",
+		primaries:   [3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}},
+		white:       [2]float64{0.3127, 0.3290},
+		curve:       Curve{Params: []float64{563.0 / 256}},
+	},
+	"ROMM RGB": {
+		description: "ROMM RGB",
+		primaries:   [3][2]float64{{0.7347, 0.2653}, {0.1596, 0.8404}, {0.0366, 0.0001}},
+		white:       [2]float64{0.3457, 0.3585},
+		curve:       Curve{Kind: 3, Params: []float64{1.8, 1, 0, 1.0 / 16, 1.0 / 32}},
+	},
+}
+
+// Check if the name is a built-in profile, names are case-insensitive.
+func IsNamed(name string) bool {
+	_, ok := namedSpaces[strings.ToUpper(strings.TrimSpace(name))]
+	return ok
+}
+
+// Get built-in profile by name.
+//
+// One of `sRGB`, `Display P3`, `DCI P3`, `Adobe RGB`, `ROMM RGB`, case-insensitive.
+func Named(name string) (*Profile, bool) {
+
+	space, ok := namedSpaces[strings.ToUpper(strings.TrimSpace(name))]
+	if !ok {
+		return nil, false
+	}
+
+	white := chromaticityToXYZ(space.white)
+
+	// Primaries are scaled so that full channels add up to the white point.
+	var primaries [9]float64
+	for col, xy := range space.primaries {
+		p := chromaticityToXYZ(xy)
+		for row := 0; row < 3; row++ {
+			primaries[row*3+col] = p[row]
+		}
+	}
+	inverse, _ := invertMatrix(primaries)
+	scale := applyMatrix(inverse, white)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			primaries[row*3+col] *= scale[col]
+		}
+	}
+
+	return &Profile{
+		Description: space.description,
+		Matrix:      multiplyMatrix(bradford(white, D50), primaries),
+		Curves:      [3]Curve{space.curve, space.curve, space.curve},
+		White:       white,
+	}, true
+}
+
+// Convert xy chromaticity to XYZ with Y of 1.
+func chromaticityToXYZ(xy [2]float64) XYZ {
+	return XYZ{xy[0] / xy[1], 1, (1 - xy[0] - xy[1]) / xy[1]}
+}
+
+// Bradford cone response matrix.
+var bradfordMatrix = [9]float64{
+	0.8951, 0.2664, -0.1614,
+	-0.7502, 1.7135, 0.0367,
+	0.0389, -0.0685, 1.0296,
+}
+
+// Chromatic adaptation matrix from one white point to another, by Bradford method.
+func bradford(from XYZ, to XYZ) [9]float64 {
+
+	source, destination := applyMatrix(bradfordMatrix, from), applyMatrix(bradfordMatrix, to)
+	scale := [9]float64{
+		destination[0] / source[0], 0, 0,
+		0, destination[1] / source[1], 0,
+		0, 0, destination[2] / source[2],
+	}
+
+	inverse, _ := invertMatrix(bradfordMatrix)
+	return multiplyMatrix(inverse, multiplyMatrix(scale, bradfordMatrix))
+}
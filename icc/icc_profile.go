@@ -0,0 +1,373 @@
+// Description: Pure Go reader of ICC color profiles, and color conversion between them.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Errors
+var (
+	ErrInvalidProfile     = errors.New("invalid ICC profile")
+	ErrUnsupportedProfile = errors.New("unsupported ICC profile")
+)
+
+// CIE XYZ color, Y of 1 is the reference white.
+type XYZ [3]float64
+
+// D50 illuminant, white point of the ICC profile connection space (PCS).
+var D50 = XYZ{0.9642, 1, 0.8249}
+
+// Length of ICC profile header, the tag table follows it.
+const headerLength = 128
+
+// Color profile of matrix/TRC kind.
+//
+// Covers RGB working spaces and most display and camera profiles. Profiles built from lookup tables
+// (e.g. printer and CMYK profiles) are rejected with `ErrUnsupportedProfile`.
+//
+// Description: Profile description, e.g. `sRGB IEC61966-2.1`.
+//
+// Gray: Grayscale profile, all channels share one curve.
+//
+// Matrix: Linear RGB to PCS XYZ (D50 adapted), row-major.
+//
+// Curves: Tone response curves, from encoded to linear values.
+//
+// White: Media white point, absolute XYZ.
+//
+// Data: Raw profile data, nil for built-in profiles.
+type Profile struct {
+	Description string     // Profile description.
+	Gray        bool       // Grayscale profile.
+	Matrix      [9]float64 // Linear RGB to PCS matrix.
+	Curves      [3]Curve   // Tone response curves.
+	White       XYZ        // Media white point.
+	Data        []byte     // Raw profile data.
+}
+
+// Load profile by built-in name, see `Named`, or from ICC file.
+func Load(name_or_path string) (*Profile, error) {
+
+	if profile, ok := Named(name_or_path); ok {
+		return profile, nil
+	}
+
+	data, err := os.ReadFile(name_or_path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile '%s': %w", name_or_path, err)
+	}
+	return profile, nil
+}
+
+// Parse ICC profile data.
+func Parse(data []byte) (*Profile, error) {
+
+	tags, err := readTagTable(data)
+	if err != nil {
+		return nil, err
+	}
+	data = data[:binary.BigEndian.Uint32(data)] // Drop padding after profile.
+
+	color_space, pcs := string(data[16:20]), string(data[20:24])
+	if pcs != "XYZ " {
+		return nil, fmt.Errorf("%w: profile connection space '%s', only matrix/TRC profiles are supported", ErrUnsupportedProfile, strings.TrimSpace(pcs))
+	}
+
+	profile := &Profile{Description: readText(tags["desc"]), White: D50, Data: data}
+	if wtpt, ok := tags["wtpt"]; ok {
+		profile.White, err = readXYZ(wtpt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Version 4 profiles store D50 as white point, the real one is recovered through adaptation matrix.
+	if chad, ok := tags["chad"]; ok && data[8] >= 4 {
+		matrix, err := readMatrix(chad)
+		if err != nil {
+			return nil, err
+		}
+		if inverse, ok := invertMatrix(matrix); ok {
+			profile.White = applyMatrix(inverse, profile.White)
+		}
+	}
+
+	switch color_space {
+	case "RGB ":
+		var columns [3]XYZ
+		for ch, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+			columns[ch], err = readXYZ(tags[sig])
+			if err != nil {
+				return nil, fmt.Errorf("%w: tag '%s'", err, sig)
+			}
+		}
+		for ch, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+			profile.Curves[ch], err = readCurve(tags[sig])
+			if err != nil {
+				return nil, fmt.Errorf("%w: tag '%s'", err, sig)
+			}
+		}
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 3; col++ {
+				profile.Matrix[row*3+col] = columns[col][row]
+			}
+		}
+
+	case "GRAY":
+		// Gray value is spread over channels, equal channels give gray of D50.
+		curve, err := readCurve(tags["kTRC"])
+		if err != nil {
+			return nil, fmt.Errorf("%w: tag 'kTRC'", err)
+		}
+		profile.Gray = true
+		profile.Curves = [3]Curve{curve, curve, curve}
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 3; col++ {
+				profile.Matrix[row*3+col] = D50[row] / 3
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: color space '%s'", ErrUnsupportedProfile, strings.TrimSpace(color_space))
+	}
+
+	return profile, nil
+}
+
+// Check ICC header and tag table, and read tags keyed by signature.
+func readTagTable(data []byte) (map[string][]byte, error) {
+
+	if len(data) < headerLength+4 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("%w: missing profile header", ErrInvalidProfile)
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size > len(data) || size < headerLength+4 {
+		return nil, fmt.Errorf("%w: profile size %d does not match data length %d", ErrInvalidProfile, size, len(data))
+	}
+	data = data[:size]
+
+	count := int(binary.BigEndian.Uint32(data[headerLength:]))
+	if count > (len(data)-headerLength-4)/12 {
+		return nil, fmt.Errorf("%w: tag table exceeds profile", ErrInvalidProfile)
+	}
+
+	tags := map[string][]byte{}
+	for i := 0; i < count; i++ {
+		entry := data[headerLength+4+i*12:]
+		offset, length := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < headerLength || length < 8 || offset+length > len(data) || offset+length < offset {
+			return nil, fmt.Errorf("%w: tag '%s' exceeds profile", ErrInvalidProfile, entry[:4])
+		}
+		tags[string(entry[:4])] = data[offset : offset+length]
+	}
+
+	return tags, nil
+}
+
+// Read signed 15.16 fixed point number.
+func readFixed(data []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(data))) / 65536
+}
+
+// Read `XYZ ` tag.
+func readXYZ(tag []byte) (XYZ, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return XYZ{}, fmt.Errorf("%w: missing or malformed XYZ tag", ErrInvalidProfile)
+	}
+	return XYZ{readFixed(tag[8:]), readFixed(tag[12:]), readFixed(tag[16:])}, nil
+}
+
+// Read 3x3 matrix of `sf32` tag.
+func readMatrix(tag []byte) ([9]float64, error) {
+	var matrix [9]float64
+	if len(tag) < 44 || string(tag[:4]) != "sf32" {
+		return matrix, fmt.Errorf("%w: malformed matrix tag", ErrInvalidProfile)
+	}
+	for i := range matrix {
+		matrix[i] = readFixed(tag[8+i*4:])
+	}
+	return matrix, nil
+}
+
+// Read text of `desc`, `mluc` or `text` tag, empty if absent.
+func readText(tag []byte) string {
+
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc": // Version 2, ASCII part.
+		length := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+length <= len(tag) {
+			return strings.TrimRight(string(tag[12:12+length]), "\x00")
+		}
+	case "mluc": // Version 4, first localized record.
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		length, offset := int(binary.BigEndian.Uint32(tag[20:])), int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+length > len(tag) || offset+length < offset {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case "text":
+		return strings.TrimRight(string(tag[8:]), "\x00")
+	}
+
+	return ""
+}
+
+// Tone response curve, maps encoded value in [0, 1] to linear value.
+//
+// Table: Sampled curve, used if not empty.
+//
+// Kind: Parametric function type 0 to 4, see ICC.1 `parametricCurveType`. Type 0 is pure gamma.
+//
+// Params: Function parameters, gamma first.
+type Curve struct {
+	Table  []float64 // Sampled curve.
+	Kind   int       // Parametric function type.
+	Params []float64 // Function parameters.
+}
+
+// Parameter count of parametric curve types.
+var parametricCurveParams = []int{1, 3, 4, 5, 7}
+
+// Read `curv` or `para` tag.
+func readCurve(tag []byte) (Curve, error) {
+
+	if len(tag) < 12 {
+		return Curve{}, fmt.Errorf("%w: missing or malformed curve tag", ErrInvalidProfile)
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case count == 0:
+			return Curve{Params: []float64{1}}, nil // Identity.
+		case count == 1:
+			if len(tag) < 14 {
+				return Curve{}, fmt.Errorf("%w: gamma exceeds curve tag", ErrInvalidProfile)
+			}
+			return Curve{Params: []float64{float64(binary.BigEndian.Uint16(tag[12:])) / 256}}, nil
+		case 12+count*2 > len(tag):
+			return Curve{}, fmt.Errorf("%w: curve table exceeds tag", ErrInvalidProfile)
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+		}
+		return Curve{Table: table}, nil
+
+	case "para":
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(parametricCurveParams) || 12+parametricCurveParams[kind]*4 > len(tag) {
+			return Curve{}, fmt.Errorf("%w: parametric curve type %d", ErrUnsupportedProfile, kind)
+		}
+		params := make([]float64, parametricCurveParams[kind])
+		for i := range params {
+			params[i] = readFixed(tag[12+i*4:])
+		}
+		return Curve{Kind: kind, Params: params}, nil
+	}
+
+	return Curve{}, fmt.Errorf("%w: curve type '%s'", ErrUnsupportedProfile, tag[:4])
+}
+
+// Evaluate curve, input is clamped to [0, 1].
+func (curve Curve) Eval(x float64) float64 {
+
+	x = math.Min(1, math.Max(0, x))
+
+	if len(curve.Table) > 0 {
+		position := x * float64(len(curve.Table)-1)
+		i := min(int(position), len(curve.Table)-2)
+		if i < 0 {
+			return curve.Table[0]
+		}
+		return curve.Table[i] + (curve.Table[i+1]-curve.Table[i])*(position-float64(i))
+	}
+
+	p := curve.Params
+	power := func(v float64) float64 { return math.Pow(math.Max(0, v), p[0]) }
+
+	switch curve.Kind {
+	case 1:
+		if x >= -p[2]/p[1] {
+			return power(p[1]*x + p[2])
+		}
+		return 0
+	case 2:
+		if x >= -p[2]/p[1] {
+			return power(p[1]*x+p[2]) + p[3]
+		}
+		return p[3]
+	case 3:
+		if x >= p[4] {
+			return power(p[1]*x + p[2])
+		}
+		return p[3] * x
+	case 4:
+		if x >= p[4] {
+			return power(p[1]*x+p[2]) + p[5]
+		}
+		return p[3]*x + p[6]
+	}
+
+	return power(x)
+}
+
+// Basic information of ICC profile, readable for every profile kind.
+//
+// Description: Profile description.
+//
+// Class: Device class, e.g. `mntr` (display), `prtr` (output), `scnr` (input).
+//
+// ColorSpace: Data color space, e.g. `RGB`, `CMYK`, `GRAY`.
+//
+// PCS: Profile connection space, `XYZ` or `Lab`.
+//
+// Version: Profile version, e.g. `4.3`.
+type Info struct {
+	Description string // Profile description.
+	Class       string // Device class.
+	ColorSpace  string // Data color space.
+	PCS         string // Profile connection space.
+	Version     string // Profile version.
+}
+
+// Read basic information of ICC profile, header and tag table are checked.
+//
+// Unlike `Parse`, lookup table based profiles (e.g. press profiles) are accepted as well.
+func ReadInfo(data []byte) (Info, error) {
+
+	tags, err := readTagTable(data)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{
+		Description: readText(tags["desc"]),
+		Class:       strings.TrimSpace(string(data[12:16])),
+		ColorSpace:  strings.TrimSpace(string(data[16:20])),
+		PCS:         strings.TrimSpace(string(data[20:24])),
+		Version:     fmt.Sprintf("%d.%d", data[8], data[9]>>4),
+	}, nil
+}
@@ -0,0 +1,270 @@
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// Build version 2 matrix/TRC profile from built-in profile, with parametric curves.
+func createProfileData(profile *Profile, pcs string) []byte {
+
+	fixed := func(buf *bytes.Buffer, v float64) { binary.Write(buf, binary.BigEndian, int32(math.Round(v*65536))) }
+	xyz := func(v XYZ) []byte {
+		buf := bytes.NewBufferString("XYZ \x00\x00\x00\x00")
+		for _, c := range v {
+			fixed(buf, c)
+		}
+		return buf.Bytes()
+	}
+
+	curve := bytes.NewBufferString("para\x00\x00\x00\x00")
+	binary.Write(curve, binary.BigEndian, uint16(profile.Curves[0].Kind))
+	binary.Write(curve, binary.BigEndian, uint16(0))
+	for _, p := range profile.Curves[0].Params {
+		fixed(curve, p)
+	}
+
+	desc := bytes.NewBufferString("desc\x00\x00\x00\x00")
+	binary.Write(desc, binary.BigEndian, uint32(len(profile.Description)+1))
+	desc.WriteString(profile.Description + "\x00")
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{{"desc", desc.Bytes()}, {"wtpt", xyz(profile.White)}, {"rTRC", curve.Bytes()}, {"gTRC", curve.Bytes()}, {"bTRC", curve.Bytes()}}
+	for col, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tags = append(tags, struct {
+			sig  string
+			data []byte
+		}{sig, xyz(XYZ{profile.Matrix[col], profile.Matrix[3+col], profile.Matrix[6+col]})})
+	}
+
+	// Header, tag table, then tag data aligned to 4 bytes.
+	table, body := &bytes.Buffer{}, &bytes.Buffer{}
+	offset := headerLength + 4 + len(tags)*12
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		table.WriteString(tag.sig)
+		binary.Write(table, binary.BigEndian, uint32(offset+body.Len()))
+		binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+		body.Write(tag.data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+
+	header := make([]byte, headerLength)
+	binary.BigEndian.PutUint32(header, uint32(headerLength+table.Len()+body.Len()))
+	header[8] = 2 // Version.
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], pcs)
+	copy(header[36:], "acsp")
+
+	return append(append(header, table.Bytes()...), body.Bytes()...)
+}
+
+func TestParse(t *testing.T) {
+
+	srgb, _ := Named("srgb")
+	profile, err := Parse(createProfileData(srgb, "XYZ "))
+	if err != nil {
+		t.Fatalf("Failed to parse profile: %v", err)
+	}
+	if profile.Description != "sRGB" || profile.Gray {
+		t.Fatalf("Unexpected profile: %+v", profile)
+	}
+	for i := range profile.Matrix {
+		if math.Abs(profile.Matrix[i]-srgb.Matrix[i]) > 1e-4 {
+			t.Fatalf("Expected matrix %v, got %v", srgb.Matrix, profile.Matrix)
+		}
+	}
+	if v := profile.Curves[1].Eval(0.5); math.Abs(v-0.214) > 1e-3 {
+		t.Fatalf("Expected sRGB curve, got %v at 0.5", v)
+	}
+
+	// D50 adapted sRGB primaries sum to D50.
+	if y := srgb.Matrix[3] + srgb.Matrix[4] + srgb.Matrix[5]; math.Abs(y-1) > 1e-4 {
+		t.Fatalf("Expected white luminance of 1, got %v", y)
+	}
+
+	if _, err := Parse(createProfileData(srgb, "Lab ")); !errors.Is(err, ErrUnsupportedProfile) {
+		t.Fatalf("Expected Lab profile to be unsupported, got %v", err)
+	}
+
+	// Lab profile is still readable, e.g. for embedding.
+	info, err := ReadInfo(createProfileData(srgb, "Lab "))
+	if err != nil || info != (Info{Description: "sRGB", Class: "mntr", ColorSpace: "RGB", PCS: "Lab", Version: "2.0"}) {
+		t.Fatalf("Unexpected profile info %+v (%v)", info, err)
+	}
+	if _, err := Parse(createProfileData(srgb, "XYZ ")[:200]); !errors.Is(err, ErrInvalidProfile) {
+		t.Fatalf("Expected truncated profile to be rejected, got %v", err)
+	}
+	if IsNamed("CMYK") || !IsNamed(" display p3 ") {
+		t.Fatalf("Unexpected built-in profile names")
+	}
+}
+
+func TestReadCurve(t *testing.T) {
+
+	curve, err := readCurve([]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33"))
+	if err != nil || len(curve.Params) != 1 || math.Abs(curve.Params[0]-2.2) > 0.01 {
+		t.Fatalf("Expected gamma 2.2, got %+v (%v)", curve, err)
+	}
+
+	// Truncated tags must not be read past their end.
+	for _, tag := range []string{
+		"curv\x00\x00\x00\x00\x00\x00\x00\x01",
+		"curv\x00\x00\x00\x00\x00\x00\x00\x01\x02",
+		"curv\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00",
+	} {
+		if _, err := readCurve([]byte(tag)); !errors.Is(err, ErrInvalidProfile) {
+			t.Fatalf("Expected truncated curve %q to be rejected, got %v", tag, err)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+
+	srgb, _ := Named("sRGB")
+	p3, _ := Named("Display P3")
+	romm, _ := Named("ROMM RGB")
+
+	convert := func(source *Profile, target *Profile, intent string, bpc bool, c [3]uint8) [3]uint8 {
+		transform, err := NewTransform(source, target, intent, bpc)
+		if err != nil {
+			t.Fatalf("Failed to create transform: %v", err)
+		}
+		r, g, b := transform.Convert(c[0], c[1], c[2])
+		return [3]uint8{r, g, b}
+	}
+	near := func(a [3]uint8, b [3]uint8) bool {
+		for ch := range a {
+			if math.Abs(float64(a[ch])-float64(b[ch])) > 1 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Same profile changes nothing, under every intent.
+	for _, intent := range []string{IntentPerceptual, IntentRelative, IntentSaturation} {
+		for _, c := range [][3]uint8{{0, 0, 0}, {255, 0, 0}, {12, 200, 99}, {255, 255, 255}} {
+			if got := convert(srgb, srgb, intent, true, c); got != c {
+				t.Fatalf("Expected %v unchanged with %s intent, got %v", c, intent, got)
+			}
+		}
+	}
+
+	// sRGB fits into Display P3, red is exact under relative and perceptual intent.
+	for _, intent := range []string{IntentRelative, IntentPerceptual} {
+		if got := convert(srgb, p3, intent, false, [3]uint8{255, 0, 0}); !near(got, [3]uint8{234, 51, 35}) {
+			t.Fatalf("Expected sRGB red as (234, 51, 35) in Display P3 with %s intent, got %v", intent, got)
+		}
+	}
+	if got := convert(srgb, p3, IntentSaturation, false, [3]uint8{255, 0, 0}); got[1] >= 51 || got[2] >= 35 {
+		t.Fatalf("Expected saturation intent to stretch sRGB red, got %v", got)
+	}
+
+	// Display P3 red is outside of sRGB.
+	relative := convert(p3, srgb, IntentRelative, false, [3]uint8{255, 0, 0})
+	perceptual := convert(p3, srgb, IntentPerceptual, false, [3]uint8{255, 0, 0})
+	if relative[0] != 255 || relative[1] > 80 || perceptual[0] != 255 {
+		t.Fatalf("Expected red clipped into sRGB, got %v and %v", relative, perceptual)
+	}
+	if got := convert(p3, srgb, IntentPerceptual, false, [3]uint8{60, 50, 40}); !near(got, convert(p3, srgb, IntentRelative, false, [3]uint8{60, 50, 40})) {
+		t.Fatalf("Expected dull colors to be kept by perceptual intent, got %v", got)
+	}
+
+	// White maps to white, except under absolute intent.
+	if got := convert(srgb, romm, IntentRelative, false, [3]uint8{255, 255, 255}); got != [3]uint8{255, 255, 255} {
+		t.Fatalf("Expected white kept by relative intent, got %v", got)
+	}
+	if got := convert(srgb, romm, IntentAbsolute, false, [3]uint8{255, 255, 255}); got[2] <= got[0] {
+		t.Fatalf("Expected D65 white to be bluish in ROMM RGB under absolute intent, got %v", got)
+	}
+
+	// Lifted black of source is mapped onto target black with compensation.
+	lifted := &Profile{Matrix: srgb.Matrix, White: srgb.White}
+	for ch := range lifted.Curves {
+		lifted.Curves[ch] = Curve{Table: []float64{0.05, 1}}
+	}
+	if got := convert(lifted, srgb, IntentRelative, true, [3]uint8{0, 0, 0}); got != [3]uint8{0, 0, 0} {
+		t.Fatalf("Expected black with compensation, got %v", got)
+	}
+	if got := convert(lifted, srgb, IntentRelative, false, [3]uint8{0, 0, 0}); got[0] < 50 {
+		t.Fatalf("Expected lifted black without compensation, got %v", got)
+	}
+
+	if _, err := NewTransform(srgb, &Profile{Gray: true}, "", false); !errors.Is(err, ErrUnsupportedProfile) {
+		t.Fatalf("Expected gray target to be rejected, got %v", err)
+	}
+	if _, err := NewTransform(srgb, p3, "vivid", false); err == nil {
+		t.Fatalf("Expected unknown intent to be rejected")
+	}
+}
+
+func TestNamed(t *testing.T) {
+
+	if !IsNamed("srgb") || !IsNamed(" Display P3 ") || IsNamed("srbg") {
+		t.Fatalf("Expected built-in names to be case-insensitive")
+	}
+	if _, ok := Named("unknown"); ok {
+		t.Fatalf("Expected unknown name to be rejected")
+	}
+
+	// Full channels add up to D50, the white point of PCS.
+	srgb, _ := Named("sRGB")
+	for row := 0; row < 3; row++ {
+		sum := srgb.Matrix[row*3] + srgb.Matrix[row*3+1] + srgb.Matrix[row*3+2]
+		if sum < D50[row]-1e-3 || sum > D50[row]+1e-3 {
+			t.Fatalf("Expected sRGB white to map to D50, got row %d sum %v", row, sum)
+		}
+	}
+}
+
+func TestProfileBytes(t *testing.T) {
+
+	for _, name := range []string{"sRGB", "Adobe RGB", "ROMM RGB"} {
+		named, _ := Named(name)
+		data := named.Bytes()
+
+		if len(data) < headerLength+4 || int(binary.BigEndian.Uint32(data)) != len(data) {
+			t.Fatalf("Expected %s profile size field to match %d bytes", name, len(data))
+		}
+		if data[8] != 2 || string(data[12:16]) != "mntr" || string(data[36:40]) != "acsp" {
+			t.Fatalf("Unexpected %s profile header %q", name, data[:40])
+		}
+		if count := binary.BigEndian.Uint32(data[headerLength:]); count != 9 {
+			t.Fatalf("Expected 9 tags in %s profile, got %d", name, count)
+		}
+		if !bytes.Contains(data, []byte(named.Description+"\x00")) {
+			t.Fatalf("Expected %s profile to contain its description", name)
+		}
+
+		// Encoded profile reads back as the built-in one.
+		profile, err := Parse(data)
+		if err != nil || profile.Description != named.Description {
+			t.Fatalf("Failed to parse encoded %s profile %+v (%v)", name, profile, err)
+		}
+		for i := range profile.Matrix {
+			if math.Abs(profile.Matrix[i]-named.Matrix[i]) > 1e-4 {
+				t.Fatalf("Expected %s matrix %v, got %v", name, named.Matrix, profile.Matrix)
+			}
+		}
+		for _, x := range []float64{0, 0.02, 0.5, 1} {
+			if math.Abs(profile.Curves[0].Eval(x)-named.Curves[0].Eval(x)) > 1e-3 {
+				t.Fatalf("Expected %s curve %v at %v, got %v", name, named.Curves[0].Eval(x), x, profile.Curves[0].Eval(x))
+			}
+		}
+	}
+
+	// Profiles read from files keep their data.
+	profile := &Profile{Data: []byte("profile-data")}
+	if !bytes.Equal(profile.Bytes(), profile.Data) {
+		t.Fatalf("Expected profile data to be kept")
+	}
+}
@@ -0,0 +1,190 @@
+package icc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Rendering intents, how colors outside of target gamut are handled.
+const (
+	IntentPerceptual = "perceptual" // Compress source gamut smoothly into target gamut, default.
+	IntentRelative   = "relative"   // Keep in-gamut colors exact, clip the rest, white maps to white.
+	IntentAbsolute   = "absolute"   // Like relative, without white point adaptation, e.g. paper white simulation.
+	IntentSaturation = "saturation" // Stretch source gamut onto target gamut, keeps colors vivid.
+)
+
+// Start of perceptual compression, as fraction of target gamut. Less saturated colors stay exact.
+const perceptualKnee = 0.8
+
+// Check if the rendering intent is supported, empty intent is `perceptual`.
+func IsIntent(intent string) bool {
+	switch strings.ToLower(intent) {
+	case "", IntentPerceptual, IntentRelative, IntentAbsolute, IntentSaturation:
+		return true
+	}
+	return false
+}
+
+// Color conversion from source to target profile, for 8-bit channels.
+type Transform struct {
+	decode     [3][256]float64 // Source channel value to linear value.
+	luminance  [3]float64      // Source linear RGB to luminance.
+	matrix     [9]float64      // Source linear RGB to target linear RGB.
+	offset     [3]float64      // Added after matrix, from black point compensation.
+	thresholds [3][255]float64 // Linear values between target channel values.
+	intent     string          // Rendering intent.
+}
+
+// Create color conversion.
+//
+// Conversion goes through the profile connection space, with linear math in between of tone curves.
+// Out-of-gamut colors are moved toward gray of the same luminance, which keeps the hue.
+//
+// source, target: Color profiles. Gray profiles are only supported as source.
+// intent: Rendering intent, see `IsIntent`.
+// black_point_compensation: Map source black onto target black, keeps shadow detail. Ignored by absolute intent.
+func NewTransform(source *Profile, target *Profile, intent string, black_point_compensation bool) (*Transform, error) {
+
+	intent = strings.ToLower(intent)
+	if !IsIntent(intent) {
+		return nil, fmt.Errorf("unknown rendering intent '%s'", intent)
+	}
+	if intent == "" {
+		intent = IntentPerceptual
+	}
+
+	if target.Gray {
+		return nil, fmt.Errorf("%w: gray profile '%s' as target", ErrUnsupportedProfile, target.Description)
+	}
+	target_inverse, ok := invertMatrix(target.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("%w: singular matrix of '%s'", ErrInvalidProfile, target.Description)
+	}
+
+	transform := &Transform{intent: intent}
+	for ch := 0; ch < 3; ch++ {
+		for v := 0; v < 256; v++ {
+			transform.decode[ch][v] = source.Curves[ch].Eval(float64(v) / 255)
+		}
+		for v := 0; v < 255; v++ {
+			transform.thresholds[ch][v] = target.Curves[ch].Eval((float64(v) + 0.5) / 255)
+		}
+	}
+
+	// Equal channels are gray, scaled so that white has luminance of 1.
+	white_y := source.Matrix[3] + source.Matrix[4] + source.Matrix[5]
+	for ch := 0; ch < 3; ch++ {
+		transform.luminance[ch] = source.Matrix[3+ch] / white_y
+	}
+
+	// Change in PCS as diagonal scale plus offset.
+	scale, offset := XYZ{1, 1, 1}, XYZ{}
+	switch {
+	case intent == IntentAbsolute:
+		for i := range scale {
+			scale[i] = source.White[i] / target.White[i]
+		}
+	case black_point_compensation:
+		source_black, target_black := source.blackPoint(), target.blackPoint()
+		for i := range scale {
+			scale[i] = (D50[i] - target_black[i]) / (D50[i] - source_black[i])
+			offset[i] = target_black[i] - scale[i]*source_black[i]
+		}
+	}
+
+	pcs := source.Matrix
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			pcs[row*3+col] *= scale[row]
+		}
+	}
+	transform.matrix = multiplyMatrix(target_inverse, pcs)
+	transform.offset = applyMatrix(target_inverse, offset)
+
+	return transform, nil
+}
+
+// Black point of profile in PCS.
+func (profile *Profile) blackPoint() XYZ {
+	black := XYZ{profile.Curves[0].Eval(0), profile.Curves[1].Eval(0), profile.Curves[2].Eval(0)}
+	return applyMatrix(profile.Matrix, black)
+}
+
+// Convert color.
+func (transform *Transform) Convert(r uint8, g uint8, b uint8) (uint8, uint8, uint8) {
+
+	source := XYZ{transform.decode[0][r], transform.decode[1][g], transform.decode[2][b]}
+	y := source[0]*transform.luminance[0] + source[1]*transform.luminance[1] + source[2]*transform.luminance[2]
+	source_gray := XYZ{y, y, y}
+
+	result := transform.linear(source)
+	gray := transform.linear(source_gray)
+	for ch := range gray {
+		gray[ch] = math.Min(1, math.Max(0, gray[ch]))
+	}
+
+	// Position of color between gray and gamut boundary, 1 is on the boundary of target gamut.
+	var chroma XYZ
+	for ch := range chroma {
+		chroma[ch] = result[ch] - gray[ch]
+	}
+	position := 1 / gamutExtent(gray, chroma)
+	source_extent := gamutExtent(source_gray, XYZ{source[0] - y, source[1] - y, source[2] - y})
+
+	if position > 0 && !math.IsInf(source_extent, 1) {
+		limit := position * source_extent // Source gamut boundary in the same direction.
+		mapped := position
+
+		switch transform.intent {
+		case IntentPerceptual:
+			if limit > 1 && position > perceptualKnee {
+				// Rational roll-off, slope 1 at the knee, source boundary lands on target boundary.
+				a := (limit - perceptualKnee) / (1 - perceptualKnee)
+				x := (position - perceptualKnee) / (limit - perceptualKnee)
+				mapped = perceptualKnee + (1-perceptualKnee)*a*x/(1+(a-1)*x)
+			}
+		case IntentSaturation:
+			mapped = position / limit
+		default:
+			mapped = math.Min(position, 1)
+		}
+
+		for ch := range result {
+			result[ch] = gray[ch] + chroma[ch]*mapped/position
+		}
+	}
+
+	return transform.encode(0, result[0]), transform.encode(1, result[1]), transform.encode(2, result[2])
+}
+
+// Convert linear source color to linear target color.
+func (transform *Transform) linear(c XYZ) XYZ {
+	result := applyMatrix(transform.matrix, c)
+	for ch := range result {
+		result[ch] += transform.offset[ch]
+	}
+	return result
+}
+
+// Encode linear value to target channel value.
+func (transform *Transform) encode(ch int, v float64) uint8 {
+	return uint8(sort.SearchFloat64s(transform.thresholds[ch][:], v))
+}
+
+// How far color can move from gray along direction until leaving [0, 1] on any channel.
+//
+// Returns +Inf if direction is zero.
+func gamutExtent(gray XYZ, direction XYZ) float64 {
+	extent := math.Inf(1)
+	for ch := range direction {
+		switch {
+		case direction[ch] > 1e-9:
+			extent = math.Min(extent, (1-gray[ch])/direction[ch])
+		case direction[ch] < -1e-9:
+			extent = math.Min(extent, gray[ch]/-direction[ch])
+		}
+	}
+	return extent
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// Tool version, also part of the processing cache key, see `cacheVersion`.
+const toolVersion = "0.2.0"
+
 // Get profile from home directory.
 // This function will trying to get profile from `.imgtools` directory in home directory.
 //
@@ -62,6 +65,43 @@ func getProfileFromHomeDir(profile_name string, create_dir bool) (path string, e
 	return profile_file, err
 }
 
+// Load and merge config files.
+//
+// Invalid config files are logged and ignored.
+// If no profile is loaded, the default profile from home directory is used instead.
+// Returns error only if the default profile cannot be loaded.
+//
+// config_paths: Config file paths.
+func loadConfigRoot(config_paths []string) (config.ProfileRoot, error) {
+
+	// Placeholder for input config file paths.
+	loaded_configs := make([]config.ProfileRoot, 0) // Placeholder for loaded configs.
+
+	for _, path := range config_paths { // Iterate through input config file paths.
+		conf, err := config.LoadConfigFromFile(path) // Load config file.
+		if err != nil {
+			log.Printf("[!] Error (%s) while loading config file: %s The config file will be ignored.\n", err, path)
+		}
+		loaded_configs = append(loaded_configs, conf) // Append to loaded configs.
+	}
+
+	config_root := config.MergeConfigFiles(loaded_configs...) // Merge all loaded configs.
+
+	// If no profile specified, try to load default profile from home directory.
+	if len(config_root.Profiles) == 0 {
+
+		log.Printf("[!] No profile specified. Trying to load default profile from home directory.\n")
+
+		config_path, err := getProfileFromHomeDir("default", true)
+		if err != nil {
+			return config.ProfileRoot{}, err
+		}
+		return config.LoadConfigFromFile(config_path) // Load default config file.
+	}
+
+	return config_root, nil
+}
+
 // Main function, defines arguments and flags.
 func main() {
 
@@ -69,8 +109,9 @@ func main() {
 	ctx := context.Background()
 
 	app := &cli.App{
-		Name:  "Image Processing CLI",
-		Usage: "Batch process images",
+		Name:    "Image Processing CLI",
+		Usage:   "Batch process images",
+		Version: toolVersion,
 		Authors: []*cli.Author{
 			{
 				Name:  "h-alice",
@@ -86,15 +127,34 @@ func main() {
 				Name:  "f",
 				Usage: "Config file path (can be specified multiple times)",
 			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the execution plan without processing images",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Process all images even if outputs are up to date",
+			},
+			&cli.BoolFlag{
+				Name:  "clean-cache",
+				Usage: "Remove processing cache before processing",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Resume interrupted run, skipping jobs recorded in the job journal",
+			},
+			&cli.StringFlag{
+				Name:  "journal",
+				Usage: "Job journal file path",
+				Value: journalFileName,
+			},
+		},
+		Commands: []*cli.Command{
+			watchCommand(),
+			inspectCommand(),
 		},
 		Action: func(c *cli.Context) error {
 
-			// Placeholder for input config file paths.
-			loaded_configs := make([]config.ProfileRoot, 0) // Placeholder for loaded configs.
-
-			// Placeholder for config root.
-			config_root := config.ProfileRoot{}
-
 			// First, check if any argument is specified.
 			if c.NArg() == 0 {
 				// Show help message.
@@ -108,58 +168,43 @@ func main() {
 				return nil
 			}
 
-			for _, path := range c.StringSlice("f") { // Iterate through input config file paths.
-				conf, err := config.LoadConfigFromFile(path) // Load config file.
-				if err != nil {
-					log.Printf("[!] Error (%s) while loading config file: %s The config file will be ignored.\n", err, path)
-				}
-				loaded_configs = append(loaded_configs, conf) // Append to loaded configs.
+			// Load all config files.
+			config_root, err := loadConfigRoot(c.StringSlice("f"))
+			if err != nil {
+				log.Fatalf("[x] Cannot load default config file: %s\n", err)
 			}
 
-			config_root = config.MergeConfigFiles(loaded_configs...) // Merge all loaded configs.
-
-			// If no profile specified, try to load default profile from home directory.
-			// If there still an error, exit the program.
-			if len(config_root.Profiles) == 0 {
-
-				log.Printf("[!] No profile specified. Trying to load default profile from home directory.\n")
-
-				config_path, err := getProfileFromHomeDir("default", true)
-				if err != nil {
-					log.Fatalf("[x] Cannot load default config file: %s\n", err)
-				}
-				config_root, err = config.LoadConfigFromFile(config_path) // Load default config file.
-				if err != nil {
-					log.Fatalf("[x] Cannot load default config file: %s\n", err)
-				}
+			// In dry-run mode, only print what would be done.
+			if c.Bool("dry-run") {
+				printExecutionPlan(config_root, c.Args().Slice())
+				return nil
 			}
 
-			// Iterate through input images.
-			for _, f := range c.Args().Slice() {
-				// Create result channel to capture return from goroutine.
-				result_chan := make(chan error) // Result channel.
+			// Remove stale cache if requested.
+			if c.Bool("clean-cache") {
+				cleanCache(c.Args().Slice())
+			}
 
-				// Currently, this function will only affect the `fileName` field in `write` block.
-				// This is a temporary solution to the issue which "write" block cannot get original input file name.
-				config_root.AssignInputFile(f)
+			// Processing cache, used to skip up-to-date jobs.
+			cache := newProcessingCache()
+			defer cache.Save()
 
-				// Check if the file exists.
-				_, err := os.Stat(f) // Check if file exists.
-				if err != nil {
-					log.Printf("[!] Input file not found: %s\n", f)
-					continue // Skip to next file.
-				}
+			// Job journal, used to resume interrupted run.
+			journal, err := openJobJournal(c.String("journal"), c.Bool("resume"))
+			if err != nil {
+				log.Fatalf("[x] Cannot open job journal: %s\n", err)
+			}
 
-				// Dispatch goroutine for each profile.
-				for _, pf := range config_root.Profiles { // Apply all profile to input image.
-					// Process image in goroutine.
-					go mainWorker(ctx, pf, result_chan)
-				}
+			failed := processImages(ctx, config_root, c.Args().Slice(), batchOptions{
+				force:   c.Bool("force"),
+				cache:   cache,
+				journal: journal,
+			})
 
-				// Wait for all goroutines to finish.
-				for range config_root.Profiles {
-					<-result_chan
-				}
+			// Journal is only needed if there's something left to resume.
+			journal.Close(failed == 0)
+			if failed > 0 {
+				log.Printf("[!] %d job(s) failed, rerun with --resume to process remaining jobs.\n", failed)
 			}
 
 			return nil
@@ -0,0 +1,339 @@
+// Description: Incremental processing cache, skips jobs whose outputs are up to date.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"imagetools/config"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Cache manifest file name, placed in the output directory.
+// Since `write` blocks always output next to the input image, this is also the input directory.
+const cacheFileName = ".imgtools-cache"
+
+// Version recorded in cache entries, outputs of other versions are outdated.
+var cacheVersion = versionOf(debug.ReadBuildInfo())
+
+// Get cache version of build, tool version with VCS revision of the build if known.
+//
+// Builds between releases produce different outputs under the same tool version, the revision tells them apart.
+func versionOf(info *debug.BuildInfo, ok bool) string {
+
+	if !ok {
+		return toolVersion
+	}
+
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return toolVersion
+	}
+
+	version := toolVersion + "+" + revision[:min(12, len(revision))]
+	if modified {
+		version += ".dirty"
+	}
+	return version
+}
+
+// Cache entry of a single (input file, profile) job.
+//
+// InputHash: SHA-256 of input file content.
+//
+// ProfileHash: SHA-256 of canonicalized profile definition and referenced files.
+//
+// Version: Cache version of the tool which produced the outputs.
+//
+// Outputs: Output files produced by the job.
+type cacheEntry struct {
+	InputHash   string   `yaml:"input_hash"`   // Input content hash.
+	ProfileHash string   `yaml:"profile_hash"` // Profile definition hash.
+	Version     string   `yaml:"version"`      // Tool version.
+	Outputs     []string `yaml:"outputs"`      // Output file paths.
+}
+
+// Cache manifest of a directory, keyed by input file name and profile name.
+type cacheManifest struct {
+	Entries map[string]cacheEntry `yaml:"entries"` // Cache entries.
+}
+
+// Processing cache, safe for concurrent use by workers.
+type processingCache struct {
+	mutex        sync.Mutex                // Guards all fields below.
+	manifests    map[string]*cacheManifest // Loaded manifests, keyed by directory.
+	dirty        map[string]bool           // Directories with modified manifest.
+	input_hashes map[string]string         // Hash of input files, keyed by path.
+}
+
+// Create empty processing cache.
+func newProcessingCache() *processingCache {
+	return &processingCache{
+		manifests:    map[string]*cacheManifest{},
+		dirty:        map[string]bool{},
+		input_hashes: map[string]string{},
+	}
+}
+
+// Hash file content with SHA-256.
+func hashFile(file_path string) (string, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Hash string with SHA-256.
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hash of referenced file, valid while size and modification time are unchanged.
+type referencedHash struct {
+	size     int64     // File size when hashed.
+	mod_time time.Time // Modification time when hashed.
+	hash     string    // Content hash.
+}
+
+// Hashes of files referenced by profiles, shared by all jobs since every job of a profile references the same files.
+var referencedHashes = struct {
+	sync.Mutex
+	hashes map[string]referencedHash
+}{hashes: map[string]referencedHash{}}
+
+// Get hash of referenced file, the file is hashed again only if it was modified.
+//
+// Missing files hash to empty string, the job fails and is not recorded anyway.
+func hashReferencedFile(file_path string) string {
+
+	info, err := os.Stat(file_path)
+	if err != nil {
+		return ""
+	}
+
+	referencedHashes.Lock()
+	defer referencedHashes.Unlock()
+
+	known, ok := referencedHashes.hashes[file_path]
+	if ok && known.size == info.Size() && known.mod_time.Equal(info.ModTime()) {
+		return known.hash
+	}
+
+	hash, err := hashFile(file_path)
+	if err != nil {
+		return ""
+	}
+	referencedHashes.hashes[file_path] = referencedHash{info.Size(), info.ModTime(), hash}
+	return hash
+}
+
+// Hash profile definition together with content of files it references, e.g. watermark images and LUTs.
+//
+// Editing a referenced file changes the outputs, just like editing the profile.
+func profileHash(profile config.ImageProcessingProfile) string {
+	definition := profile.ToYaml()
+	for _, f := range profile.ReferencedFiles() {
+		definition += "\n" + f + "\t" + hashReferencedFile(f)
+	}
+	return hashString(definition)
+}
+
+// Key of cache entry in manifest.
+func cacheKey(input_file string, profile config.ImageProcessingProfile) string {
+	return filepath.Base(input_file) + "|" + profile.ProfileName
+}
+
+// Get manifest of the directory, loading it from disk on first access.
+//
+// NOTE: Caller must hold the mutex.
+func (cache *processingCache) manifestOf(dir string) *cacheManifest {
+
+	if manifest, ok := cache.manifests[dir]; ok {
+		return manifest
+	}
+
+	manifest := &cacheManifest{}
+	raw, err := os.ReadFile(filepath.Join(dir, cacheFileName))
+	if err == nil {
+		err = yaml.Unmarshal(raw, manifest)
+		if err != nil { // Corrupted cache is simply discarded.
+			log.Printf("[!] Ignoring corrupted cache file in %s: %s\n", dir, err)
+			manifest = &cacheManifest{}
+		}
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]cacheEntry{}
+	}
+
+	cache.manifests[dir] = manifest
+	return manifest
+}
+
+// Get hash of input file, the hash is computed once per file.
+//
+// Focus sidecar file is part of the input, editing it invalidates the cache.
+//
+// NOTE: Caller must hold the mutex.
+func (cache *processingCache) inputHashOf(input_file string) (string, error) {
+
+	if hash, ok := cache.input_hashes[input_file]; ok {
+		return hash, nil
+	}
+
+	hash, err := hashFile(input_file)
+	if err != nil {
+		return "", err
+	}
+
+	if sidecar_hash, err := hashFile(config.FocusSidecarPath(input_file)); err == nil {
+		hash = hashString(hash + sidecar_hash)
+	}
+
+	cache.input_hashes[input_file] = hash
+	return hash, nil
+}
+
+// Compute expected cache entry of the job.
+//
+// NOTE: Caller must hold the mutex.
+func (cache *processingCache) expectedEntry(input_file string, profile config.ImageProcessingProfile) (cacheEntry, error) {
+
+	input_hash, err := cache.inputHashOf(input_file)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+
+	return cacheEntry{
+		InputHash:   input_hash,
+		ProfileHash: profileHash(profile),
+		Version:     cacheVersion,
+	}, nil
+}
+
+// Check if outputs of the job are up to date.
+//
+// profile: Profile with input file assigned.
+func (cache *processingCache) IsUpToDate(profile config.ImageProcessingProfile) bool {
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	input_file := profile.GetAssignedFilePath()
+	expected, err := cache.expectedEntry(input_file, profile)
+	if err != nil {
+		return false
+	}
+
+	entry, ok := cache.manifestOf(filepath.Dir(input_file)).Entries[cacheKey(input_file, profile)]
+	if !ok || entry.InputHash != expected.InputHash || entry.ProfileHash != expected.ProfileHash || entry.Version != expected.Version {
+		return false
+	}
+
+	// All outputs must still exist.
+	for _, output := range entry.Outputs {
+		_, err := os.Stat(output)
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Record successfully processed job.
+//
+// profile: Profile with input file assigned.
+func (cache *processingCache) Record(profile config.ImageProcessingProfile) {
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	input_file := profile.GetAssignedFilePath()
+	entry, err := cache.expectedEntry(input_file, profile)
+	if err != nil {
+		log.Printf("[!] Cannot cache result of [%s]: %s\n", input_file, err)
+		return
+	}
+
+	// Only record outputs actually written, `write` blocks may be skipped by conditions.
+	entry.Outputs = []string{}
+	for _, output := range profile.OutputFiles() {
+		_, err := os.Stat(output)
+		if err != nil {
+			continue
+		}
+		if abs, err := filepath.Abs(output); err == nil { // Independent of working directory.
+			output = abs
+		}
+		entry.Outputs = append(entry.Outputs, output)
+	}
+
+	dir := filepath.Dir(input_file)
+	cache.manifestOf(dir).Entries[cacheKey(input_file, profile)] = entry
+	cache.dirty[dir] = true
+}
+
+// Write modified manifests back to disk.
+func (cache *processingCache) Save() {
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for dir := range cache.dirty {
+		raw, err := yaml.Marshal(cache.manifests[dir])
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, cacheFileName), raw, 0644)
+		}
+		if err != nil {
+			log.Printf("[!] Cannot write cache file in %s: %s\n", dir, err)
+		}
+	}
+
+	cache.dirty = map[string]bool{}
+}
+
+// Remove cache files of directories containing input files.
+//
+// input_files: Input image paths.
+func cleanCache(input_files []string) {
+
+	cleaned := map[string]bool{}
+	for _, f := range input_files {
+		dir := filepath.Dir(f)
+		if cleaned[dir] {
+			continue
+		}
+		cleaned[dir] = true
+
+		err := os.Remove(filepath.Join(dir, cacheFileName))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[!] Cannot remove cache file in %s: %s\n", dir, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"imagetools/config"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"testing"
+)
+
+// Create profile writing one output next to input file.
+func createTestProfile(name string, suffix string, input_file string) config.ImageProcessingProfile {
+	profile := config.ImageProcessingProfile{
+		ProfileName: name,
+		PipelineBlocks: []config.PipelineBlock{
+			{Operation: config.OperationDecode},
+			{Operation: config.OperationWrite, Write: &config.OutputConfig{NameSuffix: suffix}},
+		},
+	}
+	profile.AssignInputFile(input_file)
+	return profile
+}
+
+// Write file, failing test on error.
+func writeTestFile(t *testing.T, file_path string, content string) {
+	if err := os.WriteFile(file_path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", file_path, err)
+	}
+}
+
+func TestProcessingCache(t *testing.T) {
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "image.png")
+	output := filepath.Join(dir, "image_out.png")
+	writeTestFile(t, input, "pixels")
+	profile := createTestProfile("web", "_out", input)
+
+	cache := newProcessingCache()
+	if cache.IsUpToDate(profile) {
+		t.Fatalf("Expected unknown job not to be up to date")
+	}
+
+	// Missing outputs are not recorded, e.g. `write` skipped by condition.
+	cache.Record(profile)
+	if entry := cache.manifestOf(dir).Entries[cacheKey(input, profile)]; len(entry.Outputs) != 0 {
+		t.Fatalf("Expected no outputs recorded, got %v", entry.Outputs)
+	}
+
+	writeTestFile(t, output, "result")
+	cache.Record(profile)
+	cache.Save()
+
+	// Manifest is read back by next run.
+	if !newProcessingCache().IsUpToDate(profile) {
+		t.Fatalf("Expected recorded job to be up to date")
+	}
+	if newProcessingCache().IsUpToDate(createTestProfile("web", "_other", input)) {
+		t.Fatalf("Expected edited profile to invalidate cache")
+	}
+	if newProcessingCache().IsUpToDate(createTestProfile("print", "_out", input)) {
+		t.Fatalf("Expected other profile not to be up to date")
+	}
+
+	// Focus sidecar is part of the input.
+	writeTestFile(t, config.FocusSidecarPath(input), "x: 10")
+	if newProcessingCache().IsUpToDate(profile) {
+		t.Fatalf("Expected new focus sidecar to invalidate cache")
+	}
+	os.Remove(config.FocusSidecarPath(input))
+
+	writeTestFile(t, input, "other pixels")
+	if newProcessingCache().IsUpToDate(profile) {
+		t.Fatalf("Expected changed input to invalidate cache")
+	}
+	writeTestFile(t, input, "pixels")
+
+	os.Remove(output)
+	if newProcessingCache().IsUpToDate(profile) {
+		t.Fatalf("Expected missing output to invalidate cache")
+	}
+	writeTestFile(t, output, "result")
+
+	// Outputs of other tool versions are outdated.
+	cache = newProcessingCache()
+	manifest := cache.manifestOf(dir)
+	entry := manifest.Entries[cacheKey(input, profile)]
+	entry.Version = "0.0.1"
+	manifest.Entries[cacheKey(input, profile)] = entry
+	if cache.IsUpToDate(profile) {
+		t.Fatalf("Expected other tool version to invalidate cache")
+	}
+
+	// Corrupted manifest is discarded, and removed by cleaning.
+	writeTestFile(t, filepath.Join(dir, cacheFileName), "entries: [")
+	if newProcessingCache().IsUpToDate(profile) {
+		t.Fatalf("Expected corrupted cache to be ignored")
+	}
+	cleanCache([]string{input})
+	if _, err := os.Stat(filepath.Join(dir, cacheFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Expected cache file to be removed, got %v", err)
+	}
+}
+
+func TestCacheVersion(t *testing.T) {
+
+	if version := versionOf(nil, false); version != toolVersion {
+		t.Fatalf("Expected tool version without build info, got %s", version)
+	}
+
+	info := &debug.BuildInfo{Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123456789abcdef"}}}
+	if version := versionOf(info, true); version != toolVersion+"+0123456789ab" {
+		t.Fatalf("Expected revision in version, got %s", version)
+	}
+
+	info.Settings = append(info.Settings, debug.BuildSetting{Key: "vcs.modified", Value: "true"})
+	if version := versionOf(info, true); version != toolVersion+"+0123456789ab.dirty" {
+		t.Fatalf("Expected modified build in version, got %s", version)
+	}
+}
+
+func TestProfileHash(t *testing.T) {
+
+	dir := t.TempDir()
+	input, mark := filepath.Join(dir, "image.png"), filepath.Join(dir, "mark.png")
+	writeTestFile(t, mark, "mark")
+
+	profile := createTestProfile("web", "_out", input)
+	plain := profileHash(profile)
+	profile.PipelineBlocks = append(profile.PipelineBlocks, config.PipelineBlock{
+		Operation: config.OperationWatermark,
+		Watermark: &config.WatermarkConfig{File: mark},
+	})
+	if files := profile.ReferencedFiles(); len(files) != 1 || files[0] != mark {
+		t.Fatalf("Expected watermark to be referenced, got %v", files)
+	}
+
+	hash := profileHash(profile)
+	if hash == plain || profileHash(profile) != hash {
+		t.Fatalf("Expected stable hash including watermark")
+	}
+
+	// Editing the watermark changes outputs, completed jobs are outdated.
+	writeTestFile(t, mark, "edited mark")
+	if profileHash(profile) == hash {
+		t.Fatalf("Expected edited watermark to change profile hash")
+	}
+}
@@ -0,0 +1,104 @@
+// Description: Dry-run mode, prints the execution plan without processing any pixel.
+package main
+
+import (
+	"fmt"
+	"imagetools/config"
+	"imagetools/metadata"
+	"os"
+	"path/filepath"
+)
+
+// Origin of an output file, used for conflict detection.
+type plannedOutput struct {
+	input_file   string // Input image path.
+	profile_name string // Profile name.
+}
+
+// Print execution plan for all input images and profiles.
+//
+// Only image headers are read, nothing is decoded nor written.
+//
+// config_root: Loaded config.
+// input_files: Input image paths.
+func printExecutionPlan(config_root config.ProfileRoot, input_files []string) {
+
+	outputs := map[string][]plannedOutput{} // Output path to its producers.
+	output_order := []string{}              // Output paths in order of appearance.
+	inputs := map[string]bool{}             // Absolute paths of all input files.
+
+	for _, f := range input_files {
+		if abs, err := filepath.Abs(f); err == nil {
+			inputs[abs] = true
+		}
+	}
+
+	for _, f := range input_files {
+
+		config_root.AssignInputFile(f)
+
+		header, err := metadata.ReadHeader(f) // Read header only.
+		if err != nil {
+			fmt.Printf("[!] %s: cannot read image header (%s), skipped.\n", f, err)
+			continue
+		}
+
+		fmt.Printf("[.] %s (%s, %dx%d)\n", f, header.Format, header.Width, header.Height)
+
+		for _, pf := range config_root.Profiles {
+
+			fmt.Printf("    Profile [%s]\n", pf.ProfileName)
+
+			steps, err := pf.Plan(header)
+			if err != nil {
+				fmt.Printf("      [x] Cannot plan profile: %s\n", err)
+				continue
+			}
+
+			for index, step := range steps {
+				line := fmt.Sprintf("      #%d %-10s", index+1, step.Operation)
+
+				if step.Skipped {
+					line += fmt.Sprintf(" skipped (when: %s)", step.Condition)
+				} else {
+					line += fmt.Sprintf(" %dx%d", step.Width, step.Height)
+				}
+
+				if step.OutputPath != "" {
+					line += fmt.Sprintf(" -> %s", step.OutputPath)
+
+					if _, seen := outputs[step.OutputPath]; !seen {
+						output_order = append(output_order, step.OutputPath)
+					}
+					outputs[step.OutputPath] = append(outputs[step.OutputPath], plannedOutput{f, pf.ProfileName})
+				}
+
+				fmt.Println(line)
+			}
+		}
+	}
+
+	// Report conflicts.
+	conflicts := 0
+	for _, path := range output_order {
+		producers := outputs[path]
+
+		if len(producers) > 1 { // Same output written multiple times.
+			conflicts++
+			fmt.Printf("[!] Conflict: %s is written %d times by:\n", path, len(producers))
+			for _, p := range producers {
+				fmt.Printf("      %s with profile [%s]\n", p.input_file, p.profile_name)
+			}
+		}
+
+		if abs, err := filepath.Abs(path); err == nil && inputs[abs] { // Output overwrites an input.
+			conflicts++
+			fmt.Printf("[!] Conflict: %s overwrites an input image.\n", path)
+		} else if _, err := os.Stat(path); err == nil { // Output file already exists.
+			conflicts++
+			fmt.Printf("[!] Conflict: %s already exists and will be overwritten.\n", path)
+		}
+	}
+
+	fmt.Printf("[+] Dry run finished: %d input(s), %d output(s), %d conflict(s).\n", len(input_files), len(output_order), conflicts)
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"imagetools/config"
+	"imagetools/metadata"
 	"log"
 	"path/filepath"
 )
@@ -19,13 +20,39 @@ func processFile(profile config.ImageProcessingProfile) error {
 		return err
 	}
 
+	// Image state for evaluating `when` clauses, initialized from file header.
+	state := config.ImageState{}
+	header, err := metadata.ReadHeader(profile.GetAssignedFilePath())
+	if err == nil {
+		state.Format = header.Format
+		state.Width = header.Width
+		state.Height = header.Height
+	}
+
 	// Create image processing pipeline.
 	for _, pb := range profile.PipelineBlocks {
 		// log.Printf("Processing Operation #%d: %s", index, pb.Operation)
-		working_image = working_image.Then(config.PipelineBlockToOperation(pb))
-		if working_image.LastError() != nil {
-			log.Printf("[x] Error while processing image: %v", working_image.LastError())
-			return working_image.LastError()
+
+		if pb.When != "" {
+			// Refresh image state, since previous blocks may have changed it.
+			working_image = working_image.Then(config.CaptureImageState(&state))
+			apply, err := pb.ShouldApply(state)
+			if err != nil {
+				log.Printf("[x] Error while evaluating condition: %v", err)
+				return err
+			}
+			if !apply {
+				log.Printf("[.] Condition [%s] not met, skipping operation [%s]", pb.When, pb.Operation)
+				continue
+			}
+		}
+
+		for _, operation := range config.PipelineBlockToOperations(pb) {
+			working_image = working_image.Then(operation)
+			if working_image.LastError() != nil {
+				log.Printf("[x] Error while processing image: %v", working_image.LastError())
+				return working_image.LastError()
+			}
 		}
 	}
 
@@ -35,16 +62,17 @@ func processFile(profile config.ImageProcessingProfile) error {
 // Main worker, this subroutine is designed to be run in a goroutine.
 //
 // ctx: Context.
-// profile: Image processing profile.
+// job: Processing job.
 // result_chan: Result channel, used to send result back to main thread.
-func mainWorker(ctx context.Context, profile config.ImageProcessingProfile, result_chan chan<- error) {
+func mainWorker(ctx context.Context, job processingJob, result_chan chan<- jobResult) {
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	profile := job.profile
+
 	// Get only file name from the path.
-	input_image_name := profile.GetAssignedFilePath()
-	input_image_name = filepath.Base(input_image_name)
+	input_image_name := filepath.Base(job.input_file)
 
 	log.Printf("[.] Processing image [%s] with profile [%s]\n", input_image_name, profile.ProfileName)
 
@@ -53,16 +81,16 @@ func mainWorker(ctx context.Context, profile config.ImageProcessingProfile, resu
 		select {
 		case <-ctx.Done(): // Check if context is cancelled.
 			log.Printf("[!] Context cancelled. Exiting...\n")
-			result_chan <- nil
-			return // Terminate goroutine.
+			result_chan <- jobResult{job, ctx.Err()} // Not completed.
+			return                                   // Terminate goroutine.
 		default:
 			err := processFile(profile) // Process image.
 			if err != nil {
 				log.Printf("[x] Error while processing image: %v\n", err)
-				result_chan <- err
+				result_chan <- jobResult{job, err}
 				return // Terminate goroutine.
 			}
-			result_chan <- nil
+			result_chan <- jobResult{job, nil}
 			return // Goroutine finished.
 		}
 	}
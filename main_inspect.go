@@ -0,0 +1,87 @@
+// Description: Inspect command, prints image metadata without processing.
+package main
+
+import (
+	"fmt"
+	"imagetools/icc"
+	"imagetools/metadata"
+	"log"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Inspect command, shows format, size, EXIF and embedded color profile of images.
+func inspectCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "inspect",
+		Usage:     "Show image metadata, including embedded ICC profile",
+		ArgsUsage: "<image> [image...]",
+		Action: func(c *cli.Context) error {
+
+			if c.NArg() == 0 {
+				cli.ShowSubcommandHelp(c)
+				log.Printf("[!] No image file specified, check again your input.\n")
+				return nil
+			}
+
+			for _, f := range c.Args().Slice() {
+				inspectImage(f)
+			}
+			return nil
+		},
+	}
+}
+
+// Print metadata of image file.
+func inspectImage(file_path string) {
+
+	// Only headers and metadata are read, pixel data is skipped.
+	f, err := os.Open(file_path)
+	if err != nil {
+		fmt.Printf("[!] %s: %s\n", file_path, err)
+		return
+	}
+	defer f.Close()
+
+	header, err := metadata.ReadHeaderFrom(f)
+	if err != nil {
+		fmt.Printf("[!] %s: cannot read image header (%s)\n", file_path, err)
+		return
+	}
+
+	fmt.Printf("[.] %s\n", file_path)
+	fmt.Printf("    Format:      %s\n", header.Format)
+	fmt.Printf("    Size:        %dx%d\n", header.Width, header.Height)
+
+	exif, err := metadata.ReadExifFrom(f)
+	if err != nil {
+		fmt.Printf("    EXIF:        unreadable (%s)\n", err)
+	}
+	fmt.Printf("    Orientation: %d\n", exif.Orientation)
+	if exif.Artist != "" {
+		fmt.Printf("    Artist:      %s\n", exif.Artist)
+	}
+	if exif.Copyright != "" {
+		fmt.Printf("    Copyright:   %s\n", exif.Copyright)
+	}
+
+	profile, err := metadata.ReadICCProfileFrom(f)
+	switch {
+	case err != nil:
+		fmt.Printf("    ICC profile: unreadable (%s)\n", err)
+	case profile == nil:
+		fmt.Printf("    ICC profile: none (treated as sRGB)\n")
+	default:
+		info, err := icc.ReadInfo(profile)
+		if err != nil {
+			fmt.Printf("    ICC profile: invalid, %d bytes (%s)\n", len(profile), err)
+			return
+		}
+		fmt.Printf("    ICC profile: %s\n", info.Description)
+		fmt.Printf("                 %s, class %s, PCS %s, version %s, %d bytes\n", info.ColorSpace, info.Class, info.PCS, info.Version, len(profile))
+		if _, err := icc.Parse(profile); err != nil {
+			fmt.Printf("                 not usable by icc_convert (%s)\n", err)
+		}
+	}
+}
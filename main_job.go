@@ -0,0 +1,214 @@
+// Description: Processing jobs, batch dispatching and the job journal for resumable runs.
+package main
+
+import (
+	"bufio"
+	"context"
+	"imagetools/config"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Default journal file name, placed in the working directory.
+const journalFileName = ".imgtools-journal"
+
+// Processing job, a single (input file, profile) pair.
+type processingJob struct {
+	input_file string                        // Input image path.
+	profile    config.ImageProcessingProfile // Profile with input file assigned.
+}
+
+// Result of processing job.
+type jobResult struct {
+	job processingJob // Finished job.
+	err error         // Error, nil if the job completed.
+}
+
+// Identifier of the job, stable across runs.
+//
+// Profile definition and referenced files are part of the key, so editing them invalidates completed jobs.
+func (job processingJob) Key() string {
+	input_file := job.input_file
+	if abs, err := filepath.Abs(input_file); err == nil {
+		input_file = abs
+	}
+	return input_file + "\t" + job.profile.ProfileName + "\t" + profileHash(job.profile)
+}
+
+// Batch processing options.
+type batchOptions struct {
+	force   bool             // Process images even if outputs are up to date.
+	cache   *processingCache // Processing cache.
+	journal *jobJournal      // Job journal, nil to disable.
+}
+
+// Process input images with all profiles.
+//
+// ctx: Context.
+// config_root: Loaded config.
+// input_files: Input image paths.
+// options: Batch processing options.
+//
+// Returns number of failed jobs.
+func processImages(ctx context.Context, config_root config.ProfileRoot, input_files []string, options batchOptions) int {
+
+	failed := 0
+
+	// Iterate through input images.
+	for _, f := range input_files {
+		// Create result channel to capture return from goroutine.
+		result_chan := make(chan jobResult) // Result channel.
+
+		// Currently, this function will only affect the `fileName` field in `write` block.
+		// This is a temporary solution to the issue which "write" block cannot get original input file name.
+		config_root.AssignInputFile(f)
+
+		// Check if the file exists.
+		_, err := os.Stat(f) // Check if file exists.
+		if err != nil {
+			log.Printf("[!] Input file not found: %s\n", f)
+			continue // Skip to next file.
+		}
+
+		// Dispatch goroutine for each profile.
+		dispatched := 0
+		for _, pf := range config_root.Profiles { // Apply all profile to input image.
+
+			job := processingJob{input_file: f, profile: pf}
+
+			// Skip jobs completed by previous run.
+			if options.journal.IsCompleted(job) {
+				log.Printf("[.] Image [%s] with profile [%s] completed in previous run, skipped.\n", filepath.Base(f), pf.ProfileName)
+				continue
+			}
+
+			// Skip jobs whose outputs are up to date.
+			if !options.force && options.cache.IsUpToDate(pf) {
+				log.Printf("[.] Image [%s] with profile [%s] is up to date, skipped.\n", filepath.Base(f), pf.ProfileName)
+				options.journal.Complete(job)
+				continue
+			}
+
+			// Process image in goroutine.
+			go mainWorker(ctx, job, result_chan)
+			dispatched++
+		}
+
+		// Wait for all goroutines to finish.
+		for i := 0; i < dispatched; i++ {
+			result := <-result_chan
+			if result.err != nil {
+				failed++
+				continue
+			}
+			options.cache.Record(result.job.profile) // Remember the job for incremental processing.
+			options.journal.Complete(result.job)     // Remember the job for resuming.
+		}
+	}
+
+	return failed
+}
+
+// Journal of completed jobs, safe for concurrent use by workers.
+//
+// Every completed job is appended and synced immediately, so a crashed run can be resumed.
+// A nil journal is valid and records nothing.
+type jobJournal struct {
+	mutex     sync.Mutex      // Guards all fields below.
+	file      *os.File        // Journal file, opened for appending.
+	completed map[string]bool // Keys of completed jobs.
+}
+
+// Open job journal.
+//
+// journal_path: Journal file path.
+// resume: Keep completed jobs of previous run, otherwise the journal starts empty.
+func openJobJournal(journal_path string, resume bool) (*jobJournal, error) {
+
+	journal := &jobJournal{completed: map[string]bool{}}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		// Load completed jobs from previous run.
+		f, err := os.Open(journal_path)
+		if err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line != "" {
+					journal.completed[line] = true
+				}
+			}
+			f.Close()
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		flag |= os.O_TRUNC // Start a new journal.
+	}
+
+	f, err := os.OpenFile(journal_path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	journal.file = f
+
+	return journal, nil
+}
+
+// Check if the job is completed.
+func (journal *jobJournal) IsCompleted(job processingJob) bool {
+	if journal == nil {
+		return false
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	return journal.completed[job.Key()]
+}
+
+// Mark the job as completed.
+func (journal *jobJournal) Complete(job processingJob) {
+	if journal == nil {
+		return
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	key := job.Key()
+	if journal.completed[key] {
+		return
+	}
+	journal.completed[key] = true
+
+	// Write and sync immediately, the run may crash anytime.
+	_, err := journal.file.WriteString(key + "\n")
+	if err == nil {
+		err = journal.file.Sync()
+	}
+	if err != nil {
+		log.Printf("[!] Cannot write job journal: %s\n", err)
+	}
+}
+
+// Close journal file.
+//
+// remove: Remove journal file, used when all jobs are completed.
+func (journal *jobJournal) Close(remove bool) {
+	if journal == nil {
+		return
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	journal.file.Close()
+	if remove {
+		os.Remove(journal.file.Name())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"imagetools/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobJournal(t *testing.T) {
+
+	dir := t.TempDir()
+	journal_path := filepath.Join(dir, journalFileName)
+	input := filepath.Join(dir, "image.png")
+	job := processingJob{input, createTestProfile("web", "_out", input)}
+
+	// Nil journal records nothing.
+	var disabled *jobJournal
+	disabled.Complete(job)
+	if disabled.IsCompleted(job) {
+		t.Fatalf("Expected nil journal to record nothing")
+	}
+
+	journal, err := openJobJournal(journal_path, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	journal.Complete(job)
+	journal.Complete(job) // Written once.
+	journal.Close(false)
+
+	raw, _ := os.ReadFile(journal_path)
+	if lines := strings.Split(strings.TrimSpace(string(raw)), "\n"); len(lines) != 1 || lines[0] != job.Key() {
+		t.Fatalf("Expected one journal line per job, got %q", raw)
+	}
+
+	// Resumed run skips completed jobs, edited profile runs again.
+	journal, err = openJobJournal(journal_path, true)
+	if err != nil {
+		t.Fatalf("Failed to resume journal: %v", err)
+	}
+	if !journal.IsCompleted(job) {
+		t.Fatalf("Expected completed job after resume")
+	}
+	if journal.IsCompleted(processingJob{input, createTestProfile("web", "_other", input)}) {
+		t.Fatalf("Expected edited profile not to be completed")
+	}
+	journal.Close(true)
+	if _, err := os.Stat(journal_path); !os.IsNotExist(err) {
+		t.Fatalf("Expected journal to be removed, got %v", err)
+	}
+
+	// New run starts empty.
+	writeTestFile(t, journal_path, job.Key()+"\n")
+	journal, err = openJobJournal(journal_path, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close(false)
+	if journal.IsCompleted(job) {
+		t.Fatalf("Expected new journal to start empty")
+	}
+}
+
+func TestProcessImages(t *testing.T) {
+
+	dir := t.TempDir()
+	input, broken := filepath.Join(dir, "image.png"), filepath.Join(dir, "broken.png")
+	data, err := os.ReadFile("test_resources/test_ayaya.png")
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	writeTestFile(t, input, string(data))
+	writeTestFile(t, broken, "not an image")
+
+	profile := config.ImageProcessingProfile{
+		ProfileName: "web",
+		PipelineBlocks: []config.PipelineBlock{
+			{Operation: config.OperationDecode},
+			{Operation: config.OperationEncode, Encode: &config.EncodeConfig{Format: "png"}},
+			{Operation: config.OperationWrite, Write: &config.OutputConfig{Format: "png", NameSuffix: "_out"}},
+		},
+	}
+	config_root := config.ProfileRoot{Profiles: []config.ImageProcessingProfile{profile}}
+	inputs := []string{input, broken, filepath.Join(dir, "missing.png")}
+
+	journal, err := openJobJournal(filepath.Join(dir, journalFileName), false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.Close(false)
+
+	cache := newProcessingCache()
+	if failed := processImages(context.Background(), config_root, inputs, batchOptions{cache: cache, journal: journal}); failed != 1 {
+		t.Fatalf("Expected broken image to fail, got %d failures", failed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "image_out.png")); err != nil {
+		t.Fatalf("Expected output of image: %v", err)
+	}
+
+	// Only the completed job is recorded.
+	config_root.AssignInputFile(input)
+	completed := processingJob{input, config_root.Profiles[0]}
+	config_root.AssignInputFile(broken)
+	failed := processingJob{broken, config_root.Profiles[0]}
+	if !journal.IsCompleted(completed) || journal.IsCompleted(failed) {
+		t.Fatalf("Expected only completed job in journal")
+	}
+	if !cache.IsUpToDate(completed.profile) || cache.IsUpToDate(failed.profile) {
+		t.Fatalf("Expected only completed job in cache")
+	}
+}
@@ -0,0 +1,329 @@
+// Description: Watch mode, processes images whenever they appear or change in hot folders.
+package main
+
+import (
+	"context"
+	"imagetools/config"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	watchDebounce     = 2 * time.Second        // Quiet period before a changed file is processed.
+	watchTickInterval = 500 * time.Millisecond // Interval of debounce and config reload checks.
+	watchPollInterval = 2 * time.Second        // Scan interval of polling watcher.
+
+	watchOwnWrites = watchDebounce + watchPollInterval // Period in which events of written outputs are ignored.
+)
+
+// File system watcher, reports paths of created or modified files.
+type fileWatcher interface {
+	Events() <-chan string // Paths of created or modified files.
+	Close() error          // Stop watching.
+}
+
+// Create file watcher for directories.
+//
+// Native file system notification is preferred, polling is used as fallback.
+func newFileWatcher(dirs []string) fileWatcher {
+
+	watcher, err := newNotifyWatcher(dirs)
+	if err == nil {
+		return watcher
+	}
+
+	log.Printf("[!] File system notification unavailable (%s), falling back to polling.\n", err)
+	return newPollingWatcher(dirs, watchPollInterval)
+}
+
+// Check if the file is a supported input image.
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// Get latest modification time of config files.
+func configModTime(config_paths []string) time.Time {
+	latest := time.Time{}
+	for _, path := range config_paths {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Watch command, monitors directories and processes new or changed images.
+func watchCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "watch",
+		Usage:     "Watch directories and process images whenever they appear or change",
+		ArgsUsage: "<dir> [dir...]",
+		Action: func(c *cli.Context) error {
+
+			if c.NArg() == 0 {
+				cli.ShowSubcommandHelp(c)
+				log.Printf("[!] No directory specified, check again your input.\n")
+				return nil
+			}
+
+			// Stop watching on interrupt.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			return runWatch(ctx, c.StringSlice("f"), c.Args().Slice(), c.Bool("force"))
+		},
+	}
+}
+
+// Watch directories until context is cancelled.
+//
+// ctx: Context.
+// config_paths: Config file paths, reloaded whenever they change.
+// dirs: Directories to watch.
+// force: Process images even if their outputs are up to date.
+func runWatch(ctx context.Context, config_paths []string, dirs []string, force bool) error {
+
+	config_root, err := loadConfigRoot(config_paths)
+	if err != nil {
+		return err
+	}
+	config_time := configModTime(config_paths)
+
+	// Use absolute paths, so events and outputs can be compared.
+	for index, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		dirs[index] = abs
+	}
+
+	watcher := newFileWatcher(dirs)
+	defer watcher.Close()
+
+	pending := map[string]time.Time{} // Changed files, with time of last change.
+	produced := producedFiles{}       // Outputs written by us, must not be processed again.
+
+	ticker := time.NewTicker(watchTickInterval)
+	defer ticker.Stop()
+
+	log.Printf("[.] Watching %s\n", strings.Join(dirs, ", "))
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[.] Watch stopped.\n")
+			return nil
+
+		case path, ok := <-watcher.Events():
+			if !ok {
+				return nil
+			}
+			if isImageFile(path) && !produced.consume(path, time.Now()) {
+				pending[path] = time.Now() // Restart debounce on every change.
+			}
+
+		case <-ticker.C:
+
+			// Hot reload config files.
+			if latest := configModTime(config_paths); latest.After(config_time) {
+				config_time = latest
+				reloaded, err := reloadConfigRoot(config_paths)
+				if err != nil {
+					log.Printf("[!] Config reload failed (%s), keeping previous config.\n", err)
+				} else {
+					config_root = reloaded
+					log.Printf("[+] Config reloaded.\n")
+				}
+			}
+
+			produced.expire(time.Now())
+			ready := readyFiles(pending, time.Now())
+			if len(ready) == 0 {
+				continue
+			}
+
+			// New cache per batch, since input content may have changed.
+			cache := newProcessingCache()
+			processImages(ctx, config_root, ready, batchOptions{force: force, cache: cache})
+			cache.Save()
+
+			// Events of written outputs are delivered after processing.
+			for _, f := range ready {
+				config_root.AssignInputFile(f)
+				for _, pf := range config_root.Profiles {
+					for _, output := range pf.OutputFiles() {
+						if abs, err := filepath.Abs(output); err == nil {
+							produced.add(abs, time.Now())
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Collect changed files which have been quiet long enough, they are removed from pending files.
+//
+// Partial writes are still in progress otherwise.
+//
+// pending: Changed files, with time of last change.
+// now: Current time.
+func readyFiles(pending map[string]time.Time, now time.Time) []string {
+	ready := []string{}
+	for path, changed := range pending {
+		if now.Sub(changed) >= watchDebounce {
+			ready = append(ready, path)
+			delete(pending, path)
+		}
+	}
+	sort.Strings(ready)
+	return ready
+}
+
+// Outputs written by watch mode, with time of writing.
+//
+// A single write raises several events, the polling watcher reports it up to one scan later. Later events
+// come from someone else replacing the output, so entries expire after `watchOwnWrites`.
+type producedFiles map[string]time.Time
+
+// Remember output written at given time.
+func (produced producedFiles) add(path string, written time.Time) {
+	produced[path] = written
+}
+
+// Check if the event of path is caused by our own write, the write is forgotten once its events are over.
+func (produced producedFiles) consume(path string, now time.Time) bool {
+	written, ok := produced[path]
+	if !ok {
+		return false
+	}
+	if now.Sub(written) < watchOwnWrites {
+		return true
+	}
+	delete(produced, path)
+	return false
+}
+
+// Forget outputs whose events are over.
+func (produced producedFiles) expire(now time.Time) {
+	for path, written := range produced {
+		if now.Sub(written) >= watchOwnWrites {
+			delete(produced, path)
+		}
+	}
+}
+
+// Reload config files, fails if any config file is invalid.
+//
+// Unlike `loadConfigRoot`, a broken config (e.g. saved halfway) must not silently drop profiles.
+func reloadConfigRoot(config_paths []string) (config.ProfileRoot, error) {
+	for _, path := range config_paths {
+		_, err := config.LoadConfigFromFile(path)
+		if err != nil {
+			return config.ProfileRoot{}, err
+		}
+	}
+	return loadConfigRoot(config_paths)
+}
+
+// Polling file watcher, compares directory snapshots periodically.
+type pollingWatcher struct {
+	events chan string   // Changed file paths.
+	done   chan struct{} // Closed to stop polling.
+}
+
+// File state in polling snapshot.
+type polledFile struct {
+	size     int64     // File size.
+	mod_time time.Time // Modification time.
+}
+
+// Create polling file watcher.
+//
+// dirs: Directories to watch.
+// interval: Scan interval.
+func newPollingWatcher(dirs []string, interval time.Duration) *pollingWatcher {
+
+	watcher := &pollingWatcher{
+		events: make(chan string),
+		done:   make(chan struct{}),
+	}
+
+	// Existing files are not reported, snapshot is taken before returning so later files are.
+	snapshot := scanDirectories(dirs)
+
+	go func() {
+		defer close(watcher.events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-watcher.done:
+				return
+			case <-ticker.C:
+				current := scanDirectories(dirs)
+				for path, state := range current {
+					if previous, ok := snapshot[path]; ok && previous == state {
+						continue // Unchanged.
+					}
+					select {
+					case watcher.events <- path:
+					case <-watcher.done:
+						return
+					}
+				}
+				snapshot = current
+			}
+		}
+	}()
+
+	return watcher
+}
+
+// Scan regular files in directories.
+func scanDirectories(dirs []string) map[string]polledFile {
+
+	files := map[string]polledFile{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			files[filepath.Join(dir, entry.Name())] = polledFile{info.Size(), info.ModTime()}
+		}
+	}
+
+	return files
+}
+
+// Paths of created or modified files.
+func (watcher *pollingWatcher) Events() <-chan string {
+	return watcher.events
+}
+
+// Stop polling.
+func (watcher *pollingWatcher) Close() error {
+	close(watcher.done)
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+// Description: Inotify based file watcher for Linux.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Inotify file watcher.
+type notifyWatcher struct {
+	file   *os.File       // Inotify instance, closing it stops the reader.
+	events chan string    // Changed file paths.
+	done   chan struct{}  // Closed to stop reporting.
+	dirs   map[int]string // Watched directories, keyed by watch descriptor.
+}
+
+// Create inotify watcher for directories.
+//
+// dirs: Directories to watch, not recursive.
+func newNotifyWatcher(dirs []string) (fileWatcher, error) {
+
+	// Non-blocking instance, so it can be integrated with Go's poller and closed while reading.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &notifyWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string),
+		done:   make(chan struct{}),
+		dirs:   map[int]string{},
+	}
+
+	// Files finished writing, moved in, or modified.
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MODIFY | syscall.IN_CREATE)
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+		if err != nil {
+			watcher.file.Close()
+			return nil, err
+		}
+		watcher.dirs[wd] = dir
+	}
+
+	go watcher.readEvents()
+
+	return watcher, nil
+}
+
+// Read inotify events until the instance is closed.
+func (watcher *notifyWatcher) readEvents() {
+
+	defer close(watcher.events)
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := watcher.file.Read(buffer)
+		if err != nil {
+			return // Closed.
+		}
+
+		// Parse all events in buffer.
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			name_start := offset + syscall.SizeofInotifyEvent
+			name_end := name_start + int(event.Len)
+			offset = name_end
+
+			dir, ok := watcher.dirs[int(event.Wd)]
+			if !ok || event.Len == 0 || event.Mask&syscall.IN_ISDIR != 0 {
+				continue // Event of directory itself, or sub-directory.
+			}
+
+			// Name is padded with NUL bytes.
+			name := buffer[name_start:name_end]
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+
+			select {
+			case watcher.events <- filepath.Join(dir, string(name)):
+			case <-watcher.done:
+				return
+			}
+		}
+	}
+}
+
+// Paths of created or modified files.
+func (watcher *notifyWatcher) Events() <-chan string {
+	return watcher.events
+}
+
+// Stop watching.
+func (watcher *notifyWatcher) Close() error {
+	close(watcher.done)
+	return watcher.file.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+// Description: Native file watcher is not implemented on this platform, polling is used instead.
+package main
+
+import (
+	"errors"
+)
+
+// Create native file watcher, always fails on this platform.
+func newNotifyWatcher(dirs []string) (fileWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Wait for event of path, failing test on timeout.
+func expectEvent(t *testing.T, watcher fileWatcher, path string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Events():
+			if event == path {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected event of %s", path)
+		}
+	}
+}
+
+func TestReadyFiles(t *testing.T) {
+
+	now := time.Now()
+	pending := map[string]time.Time{
+		"b.png": now.Add(-watchDebounce),
+		"a.png": now.Add(-time.Minute),
+		"c.png": now.Add(-watchDebounce / 2), // Still being written.
+	}
+
+	if ready := readyFiles(pending, now); !reflect.DeepEqual(ready, []string{"a.png", "b.png"}) {
+		t.Fatalf("Expected quiet files to be ready, got %v", ready)
+	}
+	if _, ok := pending["c.png"]; !ok || len(pending) != 1 {
+		t.Fatalf("Expected only recently changed file to stay pending, got %v", pending)
+	}
+	if ready := readyFiles(pending, now.Add(watchDebounce)); !reflect.DeepEqual(ready, []string{"c.png"}) || len(pending) != 0 {
+		t.Fatalf("Expected file to be ready after debounce, got %v", ready)
+	}
+}
+
+func TestProducedFiles(t *testing.T) {
+
+	now := time.Now()
+	produced := producedFiles{}
+	produced.add("/out/a.png", now)
+	produced.add("/out/b.png", now)
+
+	// Every event of our own write is ignored.
+	for i := 0; i < 3; i++ {
+		if !produced.consume("/out/a.png", now.Add(time.Second)) {
+			t.Fatalf("Expected event of written output to be ignored")
+		}
+	}
+	if produced.consume("/in/c.png", now) {
+		t.Fatalf("Expected event of input to be processed")
+	}
+
+	// Replacing the output later is a change of its own.
+	if produced.consume("/out/a.png", now.Add(watchOwnWrites)) {
+		t.Fatalf("Expected later event of output to be processed")
+	}
+	if _, ok := produced["/out/a.png"]; ok {
+		t.Fatalf("Expected output to be forgotten")
+	}
+
+	produced.expire(now.Add(watchOwnWrites))
+	if len(produced) != 0 {
+		t.Fatalf("Expected outputs to expire, got %v", produced)
+	}
+}
+
+func TestPollingWatcher(t *testing.T) {
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.png")
+	writeTestFile(t, existing, "old")
+
+	watcher := newPollingWatcher([]string{dir}, 10*time.Millisecond)
+	defer watcher.Close()
+
+	created := filepath.Join(dir, "created.png")
+	writeTestFile(t, created, "new")
+	expectEvent(t, watcher, created)
+
+	writeTestFile(t, existing, "changed")
+	expectEvent(t, watcher, existing)
+}
+
+func TestNotifyWatcher(t *testing.T) {
+
+	dir := t.TempDir()
+	watcher, err := newNotifyWatcher([]string{dir})
+	if err != nil {
+		t.Skipf("Native file watcher unavailable: %v", err)
+	}
+	defer watcher.Close()
+
+	created := filepath.Join(dir, "created.png")
+	writeTestFile(t, created, "new")
+	expectEvent(t, watcher, created)
+}
+
+func TestIsImageFile(t *testing.T) {
+	for path, expected := range map[string]bool{"a.JPG": true, "b.png": true, "c.gif": true, "d.txt": false, cacheFileName: false} {
+		if isImageFile(path) != expected {
+			t.Fatalf("Expected image file check of %s to be %v", path, expected)
+		}
+	}
+}
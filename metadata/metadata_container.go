@@ -0,0 +1,218 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Errors
+var (
+	ErrUnsupportedContainer = errors.New("unsupported image container")
+	ErrMetadataTooLarge     = errors.New("image metadata too large")
+)
+
+// Most metadata read from image file, JPEG holds up to 16 MB of ICC profile in 255 segments.
+const metadataReadLimit = 32 << 20
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n") // PNG file signature.
+
+// JPEG segment.
+type jpegSegment struct {
+	marker byte   // Marker, e.g. 0xE1 for APP1.
+	data   []byte // Segment payload, without length field.
+}
+
+// PNG chunk.
+type pngChunk struct {
+	kind string // Chunk type, e.g. `iCCP`.
+	data []byte // Chunk data.
+}
+
+// Check if data is JPEG.
+func isJPEG(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+// Check if data is PNG.
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// Read JPEG segments before image data (SOS marker).
+func readJPEGSegments(data []byte) []jpegSegment {
+	segments, _ := scanJPEGSegments(data)
+	return segments
+}
+
+// Read JPEG segments before image data, and offset where image data starts.
+func scanJPEGSegments(data []byte) ([]jpegSegment, int) {
+
+	segments := []jpegSegment{}
+	offset := 2 // Skip SOI.
+
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			break // Malformed.
+		}
+		marker := data[offset+1]
+		if marker == 0xFF { // Fill byte.
+			offset++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // SOS or EOI, metadata ends here.
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			break // Truncated.
+		}
+
+		segments = append(segments, jpegSegment{marker, data[offset+4 : offset+2+length]})
+		offset += 2 + length
+	}
+
+	return segments, offset
+}
+
+// Read PNG chunks.
+func readPNGChunks(data []byte) []pngChunk {
+
+	chunks := []pngChunk{}
+	offset := len(pngSignature)
+
+	for offset+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		kind := string(data[offset+4 : offset+8])
+		if length < 0 || offset+12+length > len(data) {
+			break // Truncated.
+		}
+
+		chunks = append(chunks, pngChunk{kind, data[offset+8 : offset+8+length]})
+		offset += 12 + length // Length, type, data and CRC.
+
+		if kind == "IEND" {
+			break
+		}
+	}
+
+	return chunks
+}
+
+// Read JPEG segments or PNG chunks selected by `keep`, seeking over others instead of reading them.
+//
+// Kept parts are returned as minimal container of the same format, to be parsed like whole image data.
+// JPEG is read up to image data, PNG up to `IEND` chunk. Malformed files return the parts read so far.
+//
+// keep: Selects JPEG segment by marker, or PNG chunk by type.
+func readContainerParts(r io.ReadSeeker, keep func(marker byte, kind string) bool) ([]byte, error) {
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return nil, nil // Too short for any container.
+	}
+
+	buf := &bytes.Buffer{}
+	skip := func(length int64) bool {
+		_, err := r.Seek(length, io.SeekCurrent)
+		return err == nil
+	}
+	read := func(length int64) ([]byte, error) {
+		if int64(buf.Len())+length > metadataReadLimit {
+			return nil, fmt.Errorf("%w: exceeds %d bytes", ErrMetadataTooLarge, metadataReadLimit)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, length)) // Truncated files do not allocate declared length.
+		if err != nil || int64(len(data)) != length {
+			return nil, nil
+		}
+		return data, nil
+	}
+
+	switch {
+	case isJPEG(signature):
+		buf.Write(signature[:2])
+		if _, err := r.Seek(2, io.SeekStart); err != nil { // Skip SOI.
+			return nil, err
+		}
+		marker := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(r, marker[:2]); err != nil || marker[0] != 0xFF {
+				return buf.Bytes(), nil
+			}
+			for marker[1] == 0xFF { // Fill bytes.
+				if _, err := io.ReadFull(r, marker[1:2]); err != nil {
+					return buf.Bytes(), nil
+				}
+			}
+			if marker[1] == 0xDA || marker[1] == 0xD9 { // SOS or EOI, metadata ends here.
+				return buf.Bytes(), nil
+			}
+			if _, err := io.ReadFull(r, marker[2:]); err != nil {
+				return buf.Bytes(), nil
+			}
+			length := int64(binary.BigEndian.Uint16(marker[2:])) - 2
+			if length < 0 {
+				return buf.Bytes(), nil
+			}
+			if !keep(marker[1], "") {
+				if !skip(length) {
+					return buf.Bytes(), nil
+				}
+				continue
+			}
+			data, err := read(length)
+			if data == nil {
+				return buf.Bytes(), err
+			}
+			writeJPEGSegment(buf, jpegSegment{marker[1], data})
+		}
+
+	case isPNG(signature):
+		buf.Write(signature)
+		header := make([]byte, 8)
+		for {
+			if _, err := io.ReadFull(r, header); err != nil {
+				return buf.Bytes(), nil
+			}
+			length, kind := int64(binary.BigEndian.Uint32(header)), string(header[4:])
+			if kind == "IEND" {
+				return buf.Bytes(), nil
+			}
+			if !keep(0, kind) {
+				if !skip(length + 4) { // Data and CRC.
+					return buf.Bytes(), nil
+				}
+				continue
+			}
+			data, err := read(length)
+			if data == nil || !skip(4) { // CRC is not checked.
+				return buf.Bytes(), err
+			}
+			writePNGChunk(buf, pngChunk{kind, data})
+		}
+	}
+
+	return nil, nil
+}
+
+// Write JPEG segment, with marker and length field.
+func writeJPEGSegment(buf *bytes.Buffer, segment jpegSegment) {
+	buf.Write([]byte{0xFF, segment.marker})
+	binary.Write(buf, binary.BigEndian, uint16(len(segment.data)+2))
+	buf.Write(segment.data)
+}
+
+// Write PNG chunk, with length field and CRC.
+func writePNGChunk(buf *bytes.Buffer, chunk pngChunk) {
+	binary.Write(buf, binary.BigEndian, uint32(len(chunk.data)))
+	buf.WriteString(chunk.kind)
+	buf.Write(chunk.data)
+	binary.Write(buf, binary.BigEndian, crc32.Update(crc32.ChecksumIEEE([]byte(chunk.kind)), crc32.IEEETable, chunk.data))
+}
@@ -0,0 +1,204 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+)
+
+// EXIF tags used by this tool.
+const (
+	exifTagOrientation = 0x0112 // Orientation tag.
+	exifTagArtist      = 0x013B // Artist tag.
+	exifTagCopyright   = 0x8298 // Copyright tag.
+)
+
+// EXIF information of image.
+//
+// Orientation: EXIF orientation, 1 (upright) if absent.
+//
+// Artist, Copyright: Creator and copyright notice, empty if absent.
+type Exif struct {
+	Orientation int    // EXIF orientation.
+	Artist      string // Image creator.
+	Copyright   string // Copyright notice.
+}
+
+// Read EXIF information from image file.
+//
+// Only the EXIF block is read, other segments and chunks are skipped.
+// Files without EXIF return default values rather than error.
+//
+// file_path: Path to image file.
+func ReadExif(file_path string) (Exif, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return Exif{}, err
+	}
+	defer f.Close()
+
+	return ReadExifFrom(f)
+}
+
+// Read EXIF information from opened image file, like `ReadExif`.
+func ReadExifFrom(r io.ReadSeeker) (Exif, error) {
+
+	tiff, err := readExifBlock(r)
+	if err != nil {
+		return Exif{}, err
+	}
+
+	return parseExifBlock(tiff), nil
+}
+
+// Parse EXIF information from encoded JPEG or PNG data.
+func ParseExif(data []byte) Exif {
+	return parseExifBlock(findExifBlock(data))
+}
+
+// Parse EXIF information from TIFF structured EXIF block, nil block gives default values.
+func parseExifBlock(tiff []byte) Exif {
+
+	exif := Exif{Orientation: 1}
+	if tiff == nil {
+		return exif
+	}
+
+	entries := readIFD0(tiff)
+	if entry, ok := entries[exifTagOrientation]; ok {
+		if orientation := entry.uint16Value(); orientation >= 1 && orientation <= 8 {
+			exif.Orientation = int(orientation)
+		}
+	}
+	exif.Artist = entries[exifTagArtist].stringValue()
+	exif.Copyright = entries[exifTagCopyright].stringValue()
+
+	return exif
+}
+
+var exifHeader = []byte("Exif\x00\x00") // Header of JPEG APP1 segment holding EXIF.
+
+// Find TIFF structured EXIF block in JPEG APP1 segment or PNG `eXIf` chunk.
+func findExifBlock(data []byte) []byte {
+
+	switch {
+	case isJPEG(data):
+		for _, segment := range readJPEGSegments(data) {
+			if segment.marker == 0xE1 && bytes.HasPrefix(segment.data, exifHeader) {
+				return segment.data[len(exifHeader):]
+			}
+		}
+	case isPNG(data):
+		for _, chunk := range readPNGChunks(data) {
+			if chunk.kind == "eXIf" {
+				return chunk.data
+			}
+		}
+	}
+
+	return nil
+}
+
+// Read EXIF block like `findExifBlock`, seeking over other segments and chunks instead of reading them.
+//
+// Returns nil without error if the image has no EXIF block, or is malformed.
+func readExifBlock(r io.ReadSeeker) ([]byte, error) {
+	data, err := readContainerParts(r, func(marker byte, kind string) bool { return marker == 0xE1 || kind == "eXIf" })
+	if err != nil {
+		return nil, err
+	}
+	return findExifBlock(data), nil
+}
+
+// IFD entry of TIFF structure.
+type ifdEntry struct {
+	order  binary.ByteOrder // Byte order of TIFF structure.
+	tiff   []byte           // Whole TIFF structure, offsets are relative to it.
+	kind   uint16           // Value type.
+	count  uint32           // Value count.
+	offset []byte           // Raw value or offset field.
+}
+
+// Read entries of first IFD, keyed by tag.
+func readIFD0(tiff []byte) map[uint16]ifdEntry {
+
+	entries := map[uint16]ifdEntry{}
+	if len(tiff) < 8 {
+		return entries
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return entries
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return entries
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		start := ifd + 2 + i*12
+		if start+12 > len(tiff) {
+			break
+		}
+		tag := order.Uint16(tiff[start:])
+		entries[tag] = ifdEntry{
+			order:  order,
+			tiff:   tiff,
+			kind:   order.Uint16(tiff[start+2:]),
+			count:  order.Uint32(tiff[start+4:]),
+			offset: tiff[start+8 : start+12],
+		}
+	}
+
+	return entries
+}
+
+// Get value of SHORT entry.
+func (entry ifdEntry) uint16Value() uint16 {
+	if entry.kind != 3 { // SHORT.
+		return 0
+	}
+	return entry.order.Uint16(entry.offset)
+}
+
+// Get value of ASCII entry, trailing NUL and spaces are removed.
+func (entry ifdEntry) stringValue() string {
+
+	if entry.kind != 2 { // ASCII.
+		return ""
+	}
+
+	// Values up to 4 bytes are stored in the offset field itself.
+	value := entry.offset
+	if entry.count > 4 {
+		start := int(entry.order.Uint32(entry.offset))
+		if start < 0 || start+int(entry.count) > len(entry.tiff) {
+			return "" // Truncated.
+		}
+		value = entry.tiff[start : start+int(entry.count)]
+	} else {
+		value = value[:entry.count]
+	}
+
+	// Copyright may hold photographer and editor notices separated by NUL.
+	parts := strings.Split(string(value), "\x00")
+	notices := []string{}
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			notices = append(notices, part)
+		}
+	}
+
+	return strings.Join(notices, ", ")
+}
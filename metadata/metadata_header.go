@@ -0,0 +1,85 @@
+// Description: Lightweight readers for image file headers, no pixel data is decoded here.
+package metadata
+
+import (
+	"bufio"
+	"image"
+	_ "image/gif"  // Register GIF header decoder.
+	_ "image/jpeg" // Register JPEG header decoder.
+	_ "image/png"  // Register PNG header decoder.
+	"io"
+	"os"
+	"strings"
+)
+
+// Basic information read from image file header.
+//
+// Format: Image format reported by decoder, e.g. `jpeg`, `png`.
+//
+// Width: Image width in pixels.
+//
+// Height: Image height in pixels.
+//
+// Orientation: EXIF orientation, 1 (upright) if absent.
+type Header struct {
+	Format      string // Image format.
+	Width       int    // Image width.
+	Height      int    // Image height.
+	Orientation int    // EXIF orientation.
+}
+
+// Most data read for image header, metadata before the frame header (e.g. large ICC profiles) fits easily.
+const headerReadLimit = 4 << 20
+
+// Read image header from file.
+//
+// Only the header and EXIF block are read, pixel data stays untouched.
+//
+// file_path: Path to image file.
+func ReadHeader(file_path string) (Header, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+
+	return ReadHeaderFrom(f)
+}
+
+// Read image header from opened image file, like `ReadHeader`.
+func ReadHeaderFrom(r io.ReadSeeker) (Header, error) {
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Header{}, err
+	}
+
+	// Decoders stop reading after the frame header.
+	conf, format, err := image.DecodeConfig(bufio.NewReader(io.LimitReader(r, headerReadLimit)))
+	if err != nil {
+		return Header{}, err
+	}
+
+	tiff, err := readExifBlock(r)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return Header{
+		Format:      NormalizeFormat(format),
+		Width:       conf.Width,
+		Height:      conf.Height,
+		Orientation: parseExifBlock(tiff).Orientation,
+	}, nil
+}
+
+// Normalize image format name.
+//
+// Format names are case-insensitive, and `jpg` is treated as `jpeg`.
+func NormalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
@@ -0,0 +1,181 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Errors
+var ErrInvalidICCChunk = errors.New("malformed embedded ICC profile")
+
+var jpegICCHeader = []byte("ICC_PROFILE\x00") // Header of JPEG APP2 segment holding ICC profile.
+
+// Largest decompressed profile of PNG `iCCP` chunk, guards against decompression bombs.
+const maxPNGProfileSize = 16 << 20
+
+// Largest profile part of one JPEG APP2 segment, segment length field counts itself, header and sequence bytes.
+const jpegICCPartSize = 0xFFFF - 2 - 14
+
+// Read embedded ICC profile from image file.
+//
+// Only the profile segments or chunk are read, pixel data is skipped.
+// Returns nil without error if the image has no embedded profile.
+//
+// file_path: Path to image file.
+func ReadICCProfile(file_path string) ([]byte, error) {
+
+	f, err := os.Open(file_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadICCProfileFrom(f)
+}
+
+// Read embedded ICC profile from opened image file, like `ReadICCProfile`.
+func ReadICCProfileFrom(r io.ReadSeeker) ([]byte, error) {
+
+	data, err := readContainerParts(r, func(marker byte, kind string) bool { return marker == 0xE2 || kind == "iCCP" })
+	if err != nil {
+		return nil, err
+	}
+
+	return ExtractICCProfile(data)
+}
+
+// Extract embedded ICC profile from encoded JPEG or PNG data.
+//
+// JPEG stores profile split over APP2 segments, PNG stores it zlib compressed in `iCCP` chunk.
+func ExtractICCProfile(data []byte) ([]byte, error) {
+
+	switch {
+	case isJPEG(data):
+		// Segment payload: header, sequence number (1-based), segment count, profile part.
+		type part struct {
+			sequence int
+			data     []byte
+		}
+		parts := []part{}
+		for _, segment := range readJPEGSegments(data) {
+			if segment.marker == 0xE2 && bytes.HasPrefix(segment.data, jpegICCHeader) && len(segment.data) >= len(jpegICCHeader)+2 {
+				parts = append(parts, part{int(segment.data[len(jpegICCHeader)]), segment.data[len(jpegICCHeader)+2:]})
+			}
+		}
+		if len(parts) == 0 {
+			return nil, nil
+		}
+
+		sort.SliceStable(parts, func(i int, j int) bool { return parts[i].sequence < parts[j].sequence })
+		profile := []byte{}
+		for i, p := range parts {
+			if p.sequence != i+1 {
+				return nil, ErrInvalidICCChunk // Missing or duplicate segment.
+			}
+			profile = append(profile, p.data...)
+		}
+		return profile, nil
+
+	case isPNG(data):
+		for _, chunk := range readPNGChunks(data) {
+			if chunk.kind != "iCCP" {
+				continue
+			}
+			// Chunk data: profile name, NUL, compression method (0 is zlib), compressed profile.
+			name_end := bytes.IndexByte(chunk.data, 0)
+			if name_end < 0 || name_end+2 > len(chunk.data) || chunk.data[name_end+1] != 0 {
+				return nil, ErrInvalidICCChunk
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(chunk.data[name_end+2:]))
+			if err != nil {
+				return nil, ErrInvalidICCChunk
+			}
+			defer reader.Close()
+			profile, err := io.ReadAll(io.LimitReader(reader, maxPNGProfileSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(profile) > maxPNGProfileSize {
+				return nil, fmt.Errorf("%w: profile exceeds %d bytes", ErrInvalidICCChunk, maxPNGProfileSize)
+			}
+			return profile, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Embed ICC profile into encoded JPEG or PNG data, replacing existing one.
+//
+// JPEG profile is placed after leading APP0 and APP1 segments (JFIF and EXIF). PNG profile is placed after
+// `IHDR` chunk, and `sRGB` chunk is dropped since PNG allows only one of both.
+//
+// data: Encoded image.
+// profile: ICC profile data.
+func EmbedICCProfile(data []byte, profile []byte) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	switch {
+	case isJPEG(data):
+		parts := (len(profile) + jpegICCPartSize - 1) / jpegICCPartSize
+		if parts > 255 {
+			return nil, fmt.Errorf("ICC profile of %d bytes is too large for JPEG", len(profile))
+		}
+
+		segments, image_data := scanJPEGSegments(data)
+		buf.Write(data[:2]) // SOI.
+		embedded := false
+		for _, segment := range segments {
+			if segment.marker == 0xE2 && bytes.HasPrefix(segment.data, jpegICCHeader) {
+				continue // Existing profile.
+			}
+			if !embedded && segment.marker != 0xE0 && segment.marker != 0xE1 {
+				writeJPEGICCSegments(buf, profile, parts)
+				embedded = true
+			}
+			writeJPEGSegment(buf, segment)
+		}
+		if !embedded {
+			writeJPEGICCSegments(buf, profile, parts)
+		}
+		buf.Write(data[image_data:])
+
+	case isPNG(data):
+		compressed := &bytes.Buffer{}
+		writer := zlib.NewWriter(compressed)
+		writer.Write(profile)
+		writer.Close()
+		iccp := pngChunk{"iCCP", append([]byte("ICC profile\x00\x00"), compressed.Bytes()...)}
+
+		buf.Write(pngSignature)
+		for _, chunk := range readPNGChunks(data) {
+			if chunk.kind == "iCCP" || chunk.kind == "sRGB" {
+				continue
+			}
+			writePNGChunk(buf, chunk)
+			if chunk.kind == "IHDR" {
+				writePNGChunk(buf, iccp)
+			}
+		}
+
+	default:
+		return nil, ErrUnsupportedContainer
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write ICC profile as JPEG APP2 segments.
+func writeJPEGICCSegments(buf *bytes.Buffer, profile []byte, parts int) {
+	for i := 0; i < parts; i++ {
+		part := profile[i*jpegICCPartSize : min(len(profile), (i+1)*jpegICCPartSize)]
+		payload := append(append(append([]byte{}, jpegICCHeader...), byte(i+1), byte(parts)), part...)
+		writeJPEGSegment(buf, jpegSegment{0xE2, payload})
+	}
+}
@@ -0,0 +1,220 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Build minimal JPEG containing only an EXIF segment with orientation, artist and copyright tags.
+func createExifJPEG(orientation uint16, order binary.ByteOrder) []byte {
+
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8)) // IFD0 offset.
+	binary.Write(tiff, order, uint16(3)) // Entry count.
+	binary.Write(tiff, order, uint16(exifTagOrientation))
+	binary.Write(tiff, order, uint16(3)) // SHORT.
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, orientation)
+	binary.Write(tiff, order, uint16(0)) // Padding of value field.
+	binary.Write(tiff, order, uint16(exifTagArtist))
+	binary.Write(tiff, order, uint16(2)) // ASCII, short enough to be stored in value field.
+	binary.Write(tiff, order, uint32(4))
+	tiff.WriteString("Ann\x00")
+	copyright := "(c) Ann\x00 \x00"
+	binary.Write(tiff, order, uint16(exifTagCopyright))
+	binary.Write(tiff, order, uint16(2)) // ASCII, stored after IFD.
+	binary.Write(tiff, order, uint32(len(copyright)))
+	binary.Write(tiff, order, uint32(8+2+3*12+4))
+	binary.Write(tiff, order, uint32(0)) // Next IFD.
+	tiff.WriteString(copyright)
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	jpeg := &bytes.Buffer{}
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(jpeg, binary.BigEndian, uint16(len(payload)+2))
+	jpeg.Write(payload)
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	return jpeg.Bytes()
+}
+
+func TestParseExif(t *testing.T) {
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		exif := ParseExif(createExifJPEG(6, order))
+		if exif.Orientation != 6 {
+			t.Fatalf("Expected orientation 6 (%v), got %d", order, exif.Orientation)
+		}
+		if read, err := ReadExifFrom(bytes.NewReader(createExifJPEG(6, order))); err != nil || read != exif {
+			t.Fatalf("Expected EXIF read from file to match parsed one, got %+v (%v)", read, err)
+		}
+		if exif.Artist != "Ann" || exif.Copyright != "(c) Ann" {
+			t.Fatalf("Expected artist and copyright of Ann (%v), got %+v", order, exif)
+		}
+	}
+
+	// Missing EXIF defaults to upright.
+	if exif := ParseExif([]byte{0xFF, 0xD8, 0xFF, 0xD9}); exif.Orientation != 1 {
+		t.Fatalf("Expected default orientation 1, got %d", exif.Orientation)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+
+	header, err := ReadHeader("../test_resources/test_ayaya.png")
+	if err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+
+	if header.Format != "png" || header.Width == 0 || header.Height == 0 {
+		t.Fatalf("Unexpected header: %+v", header)
+	}
+
+	// JPEG with EXIF segment before image data.
+	exif := createExifJPEG(6, binary.BigEndian)
+	encoded := &bytes.Buffer{}
+	jpeg.Encode(encoded, image.NewGray(image.Rect(0, 0, 30, 20)), nil)
+	jpeg_data := append(append([]byte{}, exif[:len(exif)-2]...), encoded.Bytes()[2:]...)
+
+	// PNG with `eXIf` chunk after image data, which is allowed.
+	encoded.Reset()
+	png.Encode(encoded, image.NewGray(image.Rect(0, 0, 30, 20)))
+	chunks := readPNGChunks(encoded.Bytes())
+	png_data := &bytes.Buffer{}
+	png_data.Write(pngSignature)
+	for _, chunk := range chunks {
+		if chunk.kind == "IEND" {
+			writePNGChunk(png_data, pngChunk{"eXIf", findExifBlock(exif)})
+		}
+		writePNGChunk(png_data, chunk)
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"exif.jpg": jpeg_data, "exif.png": png_data.Bytes()} {
+		file_path := filepath.Join(dir, name)
+		os.WriteFile(file_path, data, 0644)
+
+		header, err := ReadHeader(file_path)
+		if err != nil || header.Width != 30 || header.Height != 20 || header.Orientation != 6 {
+			t.Fatalf("Unexpected header of %s: %+v (%v)", name, header, err)
+		}
+		exif, err := ReadExif(file_path)
+		if err != nil || exif.Artist != "Ann" {
+			t.Fatalf("Unexpected EXIF of %s: %+v (%v)", name, exif, err)
+		}
+
+		// Truncated files read as without EXIF.
+		for _, size := range []int{0, 3, 10, len(data) / 2} {
+			if tiff, err := readExifBlock(bytes.NewReader(data[:size])); err != nil || (size < 20 && tiff != nil) {
+				t.Fatalf("Unexpected EXIF block of %s truncated to %d bytes (%v)", name, size, err)
+			}
+		}
+	}
+}
+
+func TestExtractICCProfile(t *testing.T) {
+
+	profile := bytes.Repeat([]byte("profile-data-"), 20)
+
+	// JPEG with profile split over two APP2 segments, stored out of order.
+	jpeg := &bytes.Buffer{}
+	jpeg.Write([]byte{0xFF, 0xD8})
+	for _, part := range []struct {
+		sequence byte
+		data     []byte
+	}{{2, profile[100:]}, {1, profile[:100]}} {
+		payload := append(append(append([]byte{}, jpegICCHeader...), part.sequence, 2), part.data...)
+		jpeg.Write([]byte{0xFF, 0xE2})
+		binary.Write(jpeg, binary.BigEndian, uint16(len(payload)+2))
+		jpeg.Write(payload)
+	}
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	extracted, err := ExtractICCProfile(jpeg.Bytes())
+	if err != nil || !bytes.Equal(extracted, profile) {
+		t.Fatalf("Expected profile from JPEG, got %q (%v)", extracted, err)
+	}
+
+	// PNG with compressed profile in `iCCP` chunk, CRC is not checked.
+	createPNG := func(profile []byte) []byte {
+		compressed := &bytes.Buffer{}
+		writer := zlib.NewWriter(compressed)
+		writer.Write(profile)
+		writer.Close()
+		chunk := append([]byte("Test\x00\x00"), compressed.Bytes()...)
+
+		png := bytes.NewBuffer(append([]byte{}, pngSignature...))
+		binary.Write(png, binary.BigEndian, uint32(len(chunk)))
+		png.WriteString("iCCP")
+		png.Write(chunk)
+		png.Write([]byte{0, 0, 0, 0})
+		return png.Bytes()
+	}
+
+	extracted, err = ExtractICCProfile(createPNG(profile))
+	if err != nil || !bytes.Equal(extracted, profile) {
+		t.Fatalf("Expected profile from PNG, got %q (%v)", extracted, err)
+	}
+
+	// Small chunk must not decompress into unbounded memory.
+	extracted, err = ExtractICCProfile(createPNG(make([]byte, maxPNGProfileSize+1)))
+	if !errors.Is(err, ErrInvalidICCChunk) || extracted != nil {
+		t.Fatalf("Expected oversized profile to be rejected, got %d bytes (%v)", len(extracted), err)
+	}
+
+	// Image without profile.
+	extracted, err = ReadICCProfile("../test_resources/test_ayaya.png")
+	if err != nil || extracted != nil {
+		t.Fatalf("Expected no profile, got %d bytes (%v)", len(extracted), err)
+	}
+}
+
+func TestEmbedICCProfile(t *testing.T) {
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	encoded_jpeg, encoded_png := &bytes.Buffer{}, &bytes.Buffer{}
+	jpeg.Encode(encoded_jpeg, img, nil)
+	png.Encode(encoded_png, img)
+
+	// Large profile spans several JPEG segments, second embedding replaces the first.
+	small, large := []byte("small-profile"), bytes.Repeat([]byte{7}, 70000)
+	for _, data := range [][]byte{encoded_jpeg.Bytes(), encoded_png.Bytes()} {
+		embedded, err := EmbedICCProfile(data, small)
+		if err == nil {
+			embedded, err = EmbedICCProfile(embedded, large)
+		}
+		if err != nil {
+			t.Fatalf("Failed to embed profile: %v", err)
+		}
+
+		extracted, err := ExtractICCProfile(embedded)
+		if err != nil || !bytes.Equal(extracted, large) {
+			t.Fatalf("Expected embedded profile of %d bytes, got %d bytes (%v)", len(large), len(extracted), err)
+		}
+		if read, err := ReadICCProfileFrom(bytes.NewReader(embedded)); err != nil || !bytes.Equal(read, large) {
+			t.Fatalf("Expected profile read from file of %d bytes, got %d bytes (%v)", len(large), len(read), err)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(embedded)); err != nil {
+			t.Fatalf("Expected image to stay decodable, got %v", err)
+		}
+	}
+
+	if _, err := EmbedICCProfile([]byte("GIF89a"), small); err != ErrUnsupportedContainer {
+		t.Fatalf("Expected unsupported container error, got %v", err)
+	}
+}